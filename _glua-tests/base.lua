@@ -46,3 +46,43 @@ local ok, a, b = xpcall(function()
      return err .. "!", "b"
   end)
 assert(not ok and string.find(a, "error!!") and b == nil)
+
+local ok, a, b = xpcall(function(x, y)
+     return y, x
+  end,
+  function(err)
+     assert(nil)
+  end, "a", "b")
+assert(ok and a == "b" and b == "a")
+
+local ok, a = xpcall(function(x)
+     error({code = x})
+  end,
+  function(err)
+     return err.code .. ":" .. type(debug.traceback())
+  end, 42)
+assert(not ok and a == "42:string")
+
+-- __pairs, __ipairs and __len
+local proxy = setmetatable({}, {
+  __pairs = function(t)
+    return function(_, k)
+      if k == nil then return "a", 1 elseif k == "a" then return "b", 2 end
+    end, t, nil
+  end,
+  __ipairs = function(t)
+    return function(_, i)
+      if i < 3 then return i + 1, (i + 1) * 10 end
+    end, t, 0
+  end,
+  __len = function() return 3 end,
+})
+local keys = {}
+for k, v in pairs(proxy) do table.insert(keys, k .. "=" .. v) end
+assert(table.concat(keys, ",") == "a=1,b=2")
+local values = {}
+for i, v in ipairs(proxy) do table.insert(values, v) end
+assert(table.concat(values, ",") == "10,20,30")
+assert(#proxy == 3)
+assert(rawlen(proxy) == 0 and rawlen({1, 2}) == 2 and rawlen("abc") == 3)
+assert(not pcall(rawlen, 1))
@@ -0,0 +1,41 @@
+assert(bit32.band() == 0xFFFFFFFF)
+assert(bit32.band(0xFF, 0x0F, 0x3C) == 0x0C)
+assert(bit32.bor() == 0)
+assert(bit32.bor(1, 2, 4) == 7)
+assert(bit32.bxor(0xFF, 0x0F) == 0xF0)
+assert(bit32.btest(1, 2) == false)
+assert(bit32.btest(3, 2) == true)
+assert(bit32.bnot(0) == 0xFFFFFFFF)
+assert(bit32.bnot(-1) == 0)
+assert(bit32.band(-1) == 0xFFFFFFFF)
+assert(bit32.band(2^32 + 3) == 3)
+assert(bit32.band(3.7) == 3)
+
+assert(bit32.lshift(1, 31) == 0x80000000)
+assert(bit32.lshift(1, 32) == 0)
+assert(bit32.lshift(0x10, -4) == 1)
+assert(bit32.rshift(0x80000000, 31) == 1)
+assert(bit32.rshift(0xFFFFFFFF, 32) == 0)
+assert(bit32.rshift(1, -4) == 0x10)
+assert(bit32.arshift(0x80000000, 4) == 0xF8000000)
+assert(bit32.arshift(0x40000000, 4) == 0x04000000)
+assert(bit32.arshift(0x80000000, 40) == 0xFFFFFFFF)
+assert(bit32.arshift(1, -4) == 0x10)
+
+assert(bit32.lrotate(0x80000001, 1) == 3)
+assert(bit32.lrotate(0x12345678, 32) == 0x12345678)
+assert(bit32.rrotate(3, 1) == 0x80000001)
+assert(bit32.rrotate(0x12345678, -4) == 0x23456781)
+
+assert(bit32.extract(0xF0, 4, 4) == 0xF)
+assert(bit32.extract(0x80000000, 31) == 1)
+assert(bit32.extract(0xFFFFFFFF, 0, 32) == 0xFFFFFFFF)
+assert(bit32.replace(0, 0xF, 4, 4) == 0xF0)
+assert(bit32.replace(0xFF, 0, 0) == 0xFE)
+
+local ok, msg = pcall(bit32.extract, 1, 30, 4)
+assert(not ok and string.find(msg, "trying to access non%-existent bits"))
+ok, msg = pcall(bit32.extract, 1, -1)
+assert(not ok and string.find(msg, "field cannot be negative"))
+ok, msg = pcall(bit32.replace, 1, 1, 0, 0)
+assert(not ok and string.find(msg, "width must be positive"))
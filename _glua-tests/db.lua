@@ -1,5 +1,5 @@
 -- debug lib tests
--- debug stuff are  partially implemented; hooks are not supported.
+-- debug stuff are  partially implemented.
 
 local function f1()
 end
@@ -84,3 +84,44 @@ assert(not ok and string.find(msg, "level out of range"))
 
 assert(debug.getinfo(100) == nil)
 assert(debug.getinfo(1, "a") == nil)
+
+local events = {}
+local function hooked(a)
+  local b = a + 1
+  return b
+end
+debug.sethook(function(event, line)
+  table.insert(events, event)
+end, "cr")
+hooked(1)
+debug.sethook()
+assert(#events == 3)
+assert(events[1] == "call" and events[2] == "return") -- hooked
+assert(events[3] == "call") -- debug.sethook
+assert(debug.gethook() == nil)
+
+local lines = {}
+local defined = debug.getinfo(1, "l").currentline + 1
+local function hookedlines()
+  local a = 1
+  local b = 2
+  return a + b
+end
+debug.sethook(function(event, line)
+  local info = debug.getinfo(2, "S")
+  if info.what == "Lua" and info.linedefined == defined then
+    table.insert(lines, line)
+  end
+end, "l")
+hookedlines()
+debug.sethook()
+assert(#lines == 3 and lines[1] == defined + 1 and lines[2] == defined + 2 and lines[3] == defined + 3)
+
+local count = 0
+local hook = function(event) count = count + 1 end
+debug.sethook(hook, "", 10)
+for i = 1, 100 do end
+local fn, mask, n = debug.gethook()
+debug.sethook()
+assert(fn == hook and mask == "" and n == 10)
+assert(count > 10)
@@ -0,0 +1,164 @@
+local lim = 15
+
+-- weak keys
+local a = {}; setmetatable(a, {__mode = 'k'});
+-- fill a with some `collectable' indices
+for i=1,lim do a[{}] = i end
+-- and some non-collectable ones
+for i=1,lim do local t={}; a[t]=t end
+for i=1,lim do a[i] = i end
+for i=1,lim do local s=string.rep('@', i); a[s] = s..'#' end
+collectgarbage()
+local i = 0
+for k,v in pairs(a) do assert(k==v or k..'#'==v); i=i+1 end
+assert(i == 3*lim)
+
+-- weak values
+a = {}; setmetatable(a, {__mode = 'v'});
+a[1] = string.rep('b', 21)
+collectgarbage()
+assert(a[1])   -- strings are *values*
+a[1] = nil
+-- fill a with some `collectable' values (in both parts of the table)
+for i=1,lim do a[i] = {} end
+for i=1,lim do a[i..'x'] = {} end
+-- and some non-collectable ones
+for i=1,lim do local t={}; a[t]=t end
+for i=1,lim do a[i+lim]=i..'x' end
+collectgarbage()
+local i = 0
+for k,v in pairs(a) do assert(k==v or k-lim..'x' == v); i=i+1 end
+assert(i == 2*lim)
+
+-- weak keys and values
+a = {}; setmetatable(a, {__mode = 'vk'});
+local x, y, z = {}, {}, {}
+-- keep only some items
+a[1], a[2], a[3] = x, y, z
+a[string.rep('$', 11)] = string.rep('$', 11)
+-- fill a with some `collectable' values
+for i=4,lim do a[i] = {} end
+for i=1,lim do a[{}] = i end
+for i=1,lim do local t={}; a[t]=t end
+collectgarbage()
+assert(next(a) ~= nil)
+local i = 0
+for k,v in pairs(a) do
+  assert((k == 1 and v == x) or
+         (k == 2 and v == y) or
+         (k == 3 and v == z) or k==v);
+  i = i+1
+end
+assert(i == 4)
+x,y,z=nil
+collectgarbage()
+assert(next(a) == string.rep('$', 11))
+
+-- the weakness is applied to existing entries
+a = {}
+for i=1,lim do a[i] = {} end
+for i=1,lim do a[{}] = i end
+local keep = {}
+a[keep] = keep
+setmetatable(a, {__mode = 'kv'})
+collectgarbage()
+assert(#a == 0 and next(a) == keep and next(a, keep) == nil)
+
+-- the table becomes strong again
+setmetatable(a, nil)
+a[{}] = 1
+collectgarbage()
+assert(next(a, keep) ~= nil)
+
+-- functions, userdata and threads are collectable
+a = setmetatable({}, {__mode = 'v'})
+a[1] = function() end
+a[2] = newproxy(true)
+a[3] = coroutine.create(function() end)
+a[4] = print
+collectgarbage()
+assert(a[1] == nil and a[2] == nil and a[3] == nil and a[4] == print)
+
+-- table functions see through weak references
+a = setmetatable({}, {__mode = 'v'})
+local t1, t2, t3 = {3}, {1}, {2}
+a[1], a[2], a[3] = t1, t2, t3
+table.sort(a, function(x, y) return x[1] < y[1] end)
+assert(a[1] == t2 and a[2] == t3 and a[3] == t1 and #a == 3)
+assert(table.remove(a) == t1 and #a == 2)
+table.insert(a, 1, t1)
+assert(a[1] == t1 and rawget(a, 1) == t1 and #a == 3)
+
+-- __gc metamethods of userdata
+local n = 0
+for i = 1, 10 do
+  local u = newproxy(true)
+  getmetatable(u).__gc = function(o)
+    assert(type(o) == "userdata")
+    n = n + 1
+  end
+end
+collectgarbage()
+assert(n == 10)
+
+-- a userdata is finalized only once
+collectgarbage()
+assert(n == 10)
+
+-- __gc x weak tables
+local u = newproxy(true)
+setmetatable(getmetatable(u), {__mode = "v"})
+getmetatable(u).__gc = function (o) os.exit(1) end  -- cannot happen
+collectgarbage()
+
+-- errors during collection
+u = newproxy(true)
+getmetatable(u).__gc = function () error "!!!" end
+u = nil
+local ok, msg = pcall(collectgarbage)
+assert(not ok and string.find(msg, "!!!"))
+
+-- collectgarbage options
+assert(collectgarbage("collect") == 0)
+assert(collectgarbage() == 0)
+assert(collectgarbage("step") == true)
+assert(collectgarbage("stop") == 0)
+assert(collectgarbage("restart") == 0)
+assert(collectgarbage("setpause", 150) == 200)
+assert(collectgarbage("setpause", 200) == 150)
+assert(collectgarbage("setstepmul", 300) == 200)
+assert(collectgarbage("setstepmul", 200) == 300)
+local ok, msg = pcall(collectgarbage, "nooption")
+assert(not ok and string.find(msg, "invalid option"))
+
+do
+  local x = collectgarbage("count")
+  assert(type(x) == "number" and x > 0)
+  local a = {}
+  for i = 1, 10000 do a[i] = {} end
+  assert(collectgarbage("count") > x + 100)
+  a = nil
+  collectgarbage()
+  assert(collectgarbage("count") < x + 100)
+end
+
+-- collect sweeps weak tables
+do
+  local a = setmetatable({}, {__mode = "k"})
+  for i = 1, 10 do a[{}] = i end
+  collectgarbage()
+  assert(next(a) == nil)
+end
+
+-- __mode is read when the metatable is set
+do
+  local mt = {}
+  local a = setmetatable({}, mt)
+  mt.__mode = "k"
+  for i = 1, 10 do a[{}] = i end
+  collectgarbage()
+  assert(next(a) ~= nil)
+  setmetatable(a, mt)
+  collectgarbage()
+  assert(next(a) == nil)
+end
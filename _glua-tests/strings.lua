@@ -1,8 +1,21 @@
 
-local ok, msg = pcall(function()
-  string.dump()
-end)
-assert(not ok and string.find(msg, "GopherLua does not support the string.dump"))
+local ok, msg = pcall(string.dump, print)
+assert(not ok and string.find(msg, "unable to dump given function"))
+do
+  local function f(a, b) local t = {a, b, "x", 1.5, true} return #t, t[3] .. a end
+  local d = string.dump(f)
+  assert(type(d) == "string" and string.sub(d, 1, 5) == "\27GLua")
+  local g = assert(loadstring(d))
+  local n, s = g("y", 2)
+  assert(n == 5 and s == "xy")
+  local r = d
+  local g2 = assert(load(function() local s = r; r = nil; return s end))
+  assert(select(2, g2("z")) == "xz")
+  local ok, msg = loadstring(string.sub(d, 1, #d - 3))
+  assert(not ok and string.find(msg, "truncated precompiled chunk"))
+  local sum = assert(loadstring(string.dump(function(t) local n = 0 for k, v in pairs(t) do n = n + v end return n end)))
+  assert(sum({1, 2, 3}) == 6)
+end
 assert(string.find("","aaa") == nil)
 assert(string.gsub("hello world", "(%w+)", "%1 %1 %c") == "hello hello %c world world %c")
 
@@ -11,3 +24,94 @@ assert(ret1 == 1)
 assert(ret2 == 3)
 assert(ret3 == "aaa")
 assert(ret4 == 4)
+
+-- string.pack, string.unpack and string.packsize
+assert(string.pack("i4", 100) == "\100\0\0\0")
+assert(string.pack(">i4", 100) == "\0\0\0\100")
+assert(string.pack("<h", -2) == "\254\255")
+assert(string.pack("b", -1) == "\255")
+assert(string.pack("B", 255) == "\255")
+assert(string.pack("i3", -1) == "\255\255\255")
+assert(string.pack("<i16", -1) == string.rep("\255", 16))
+assert(string.pack(">I2", 0x1234) == "\18\52")
+assert(string.pack("z", "abc") == "abc\0")
+assert(string.pack("s1", "abc") == "\3abc")
+assert(string.pack("c5", "abc") == "abc\0\0")
+assert(string.pack("!4 b i4", 1, 2) == "\1\0\0\0\2\0\0\0")
+assert(string.pack("b x b", 1, 2) == "\1\0\2")
+assert(string.pack("!8 b Xi4 b", 1, 2) == "\1\0\0\0\2")
+assert(#string.pack("d", 1.5) == 8)
+
+assert(string.packsize("i4") == 4)
+assert(string.packsize("!8 b i8") == 16)
+assert(string.packsize("c10 h") == 12)
+assert(string.packsize("<j J T") == 24)
+
+local a, b, c, d, pos = string.unpack("<i4 h z s1", string.pack("<i4 h z s1", -7, 300, "hello", "go"))
+assert(a == -7 and b == 300 and c == "hello" and d == "go" and pos == 16)
+assert(string.unpack("B", "\255") == 255)
+assert(string.unpack("b", "\255") == -1)
+assert(string.unpack(">I3", "\1\2\3") == 0x010203)
+assert(string.unpack("f", string.pack("f", 0.5)) == 0.5)
+assert(string.unpack("d", string.pack("d", -1.25)) == -1.25)
+assert(string.unpack("<i16", string.rep("\255", 16)) == -1)
+assert(string.unpack("c3", "abcdef", 2) == "bcd")
+assert(select(2, string.unpack("c3", "abcdef", -3)) == 7)
+
+local ok, msg = pcall(string.pack, "i1", 128)
+assert(not ok and string.find(msg, "integer overflow"))
+ok, msg = pcall(string.pack, "I1", -1)
+assert(not ok and string.find(msg, "unsigned overflow"))
+ok, msg = pcall(string.pack, "i4", 1.5)
+assert(not ok and string.find(msg, "number has no integer representation"))
+ok, msg = pcall(string.pack, "z", "a\0b")
+assert(not ok and string.find(msg, "string contains zeros"))
+ok, msg = pcall(string.pack, "c2", "abc")
+assert(not ok and string.find(msg, "string longer than given size"))
+ok, msg = pcall(string.pack, "i17", 1)
+assert(not ok and string.find(msg, "out of limits"))
+ok, msg = pcall(string.packsize, "c2147483647")
+assert(not ok and string.find(msg, "integral size out of limits"))
+ok, msg = pcall(string.pack, "y", 1)
+assert(not ok and string.find(msg, "invalid format option 'y'"))
+ok, msg = pcall(string.packsize, "s")
+assert(not ok and string.find(msg, "variable%-length format"))
+ok, msg = pcall(string.unpack, "i4", "abc")
+assert(not ok and string.find(msg, "data string too short"))
+ok, msg = pcall(string.unpack, "z", "abc")
+assert(not ok and string.find(msg, "unfinished string"))
+ok, msg = pcall(string.unpack, "<i9", "\0\0\0\0\0\0\0\0\1")
+assert(not ok and string.find(msg, "does not fit into Lua Integer"))
+
+-- string.format
+assert(string.format("%5.2s|%-5s|%.3s", "abc", "ab", "abcdef") == "   ab|ab   |abc")
+assert(string.format("%q", 'a"b\\c\nd\r\0e') == '"a\\"b\\\\c\\\nd\\r\\000e"')
+assert(string.format("%i %d %c%c", 3, -3.7, 65, 66) == "3 -3 AB")
+assert(string.format("%x %X %o %u", 255, 3.0, 8, 42) == "ff 3 10 42")
+assert(string.format("%x", -1) == "ffffffffffffffff")
+assert(string.format("%g %g %g", 0.1 + 0.2, 1e20, 100) == "0.3 1e+20 100")
+assert(string.format("%e %.3g %5.1f", 12.5, 2/3, 3.14159) == "1.250000e+01 0.667   3.1")
+assert(string.format("%5d|%-5d|%05d|%+d|% d|%.3d", 42, 42, 42, 42, 42, 7) == "   42|42   |00042|+42| 42|007")
+assert(string.format("%f %f %5.1f", 1/0, -1/0, 0/0) == "inf -inf   nan")
+assert(string.format("%s %s %s", nil, true, 1) == "nil true 1")
+assert(string.format("%s", setmetatable({}, {__tostring = function() return "obj" end})) == "obj")
+assert(string.format("%%d %s", "x") == "%d x")
+
+local ok, msg = pcall(string.format, "%y", 1)
+assert(not ok and string.find(msg, "invalid option '%%y' to 'format'"))
+ok, msg = pcall(string.format, "%d")
+assert(not ok and string.find(msg, "no value"))
+ok, msg = pcall(string.format, "%123d", 1)
+assert(not ok and string.find(msg, "invalid format %(width or precision too long%)"))
+ok, msg = pcall(string.format, "%------d", 1)
+assert(not ok and string.find(msg, "invalid format %(repeated flags%)"))
+ok, msg = pcall(string.format, "%d", "x")
+assert(not ok and string.find(msg, "number expected"))
+
+-- frontier patterns
+assert(string.gsub("THE (quick) fox", "%f[%a]%a+", "X") == "X (X) X")
+assert(string.find("THE (quick) fox", "%f[%l]%a+") == 6)
+assert(select(2, string.gsub("hello world from lua", "%f[%w]%w+", "")) == 4)
+assert(string.find("foo", "%f[%z]") == 4)
+local ok, msg = pcall(string.find, "foo", "%fo")
+assert(not ok and string.find(msg, "missing '%[' after '%%f' in pattern"))
@@ -35,3 +35,43 @@ for k, v in pairs(a) do
     error("unexpected key:" .. tostring(k))
   end
 end
+
+-- table.pack, table.unpack and table.move
+local t = table.pack(1, nil, 3)
+assert(t.n == 3 and t[1] == 1 and t[2] == nil and t[3] == 3)
+assert(table.pack().n == 0)
+local x, y, z = table.unpack({1, 2, 3})
+assert(x == 1 and y == 2 and z == 3)
+x, y = table.unpack({1, 2, 3}, 2)
+assert(x == 2 and y == 3)
+x, y, z = table.unpack({1, 2, 3}, 2, 4)
+assert(x == 2 and y == 3 and z == nil)
+assert(select("#", table.unpack({1, 2, 3}, 3, 2)) == 0)
+
+local function str(t, n) local r = {} for i = 1, n do r[i] = tostring(t[i]) end return table.concat(r, ",") end
+t = {1, 2, 3, 4, 5}
+assert(table.move(t, 2, 4, 1) == t)
+assert(str(t, 5) == "2,3,4,4,5")
+t = {1, 2, 3, 4, 5}
+table.move(t, 1, 3, 3)
+assert(str(t, 5) == "1,2,1,2,3")
+t = {1, 2, 3}
+table.move(t, 1, 3, 3)
+assert(str(t, 5) == "1,2,1,2,3")
+local t2 = table.move({1, 2, 3}, 1, 3, 2, {})
+assert(t2[1] == nil and str(t2, 4) == "nil,1,2,3")
+assert(#table.move({1, 2, 3}, 2, 1, 1, {}) == 0)
+
+local log = {}
+local src = setmetatable({}, {__index = function(_, k) return k * 10 end})
+local dst = setmetatable({}, {__newindex = function(t, k, v) table.insert(log, k .. "=" .. v); rawset(t, k, v) end})
+table.move(src, 1, 3, 1, dst)
+assert(table.concat(log, ",") == "1=10,2=20,3=30" and dst[3] == 30)
+local ok, msg = pcall(table.move, {}, -2^62, 2^62, 1)
+assert(not ok and string.find(msg, "too many elements to move"))
+ok, msg = pcall(table.move, {}, 1, 2^10 + 1, 2^63 - 2^10)
+assert(not ok and string.find(msg, "destination wrap around"))
+t = {1, 2, 3}
+table.move({}, 1, 2, 2, t)
+assert(t[1] == 1 and t[2] == nil and t[3] == nil)
+assert(not ok)
@@ -0,0 +1,69 @@
+-- "aä中𝄞"
+local s = "a\195\164\228\184\173\240\157\132\158"
+
+assert(utf8.char() == "")
+assert(utf8.char(97, 228, 20013, 119070) == s)
+assert(utf8.char(0xD800) == "\237\160\128")
+assert(not pcall(utf8.char, 0x110000))
+assert(not pcall(utf8.char, -1))
+
+assert(utf8.len(s) == 4)
+assert(utf8.len("") == 0)
+assert(utf8.len(s, 2) == 3)
+assert(utf8.len(s, -4) == 1)
+assert(utf8.len(s, 1, 1) == 1)
+local n, pos = utf8.len("ab\255cd")
+assert(n == nil and pos == 3)
+n, pos = utf8.len(s, 3)
+assert(n == nil and pos == 3)
+-- overlong and out of range sequences
+assert(utf8.len("\192\128") == nil)
+assert(utf8.len("\244\144\128\128") == nil)
+assert(utf8.len("\228\184") == nil)
+local ok, msg = pcall(utf8.len, s, 20)
+assert(not ok and string.find(msg, "initial position out of string"))
+
+local a, b, c, d = utf8.codepoint(s, 1, -1)
+assert(a == 97 and b == 228 and c == 20013 and d == 119070)
+assert(utf8.codepoint(s) == 97)
+assert(utf8.codepoint(s, 4) == 20013)
+assert(utf8.codepoint(s, -4) == 119070)
+assert(select("#", utf8.codepoint(s, 3, 2)) == 0)
+ok, msg = pcall(utf8.codepoint, s, 3)
+assert(not ok and string.find(msg, "invalid UTF%-8 code"))
+ok, msg = pcall(utf8.codepoint, s, 1, 100)
+assert(not ok and string.find(msg, "out of range"))
+
+assert(utf8.offset(s, 1) == 1)
+assert(utf8.offset(s, 3) == 4)
+assert(utf8.offset(s, 4) == 7)
+assert(utf8.offset(s, 5) == 11)
+assert(utf8.offset(s, 6) == nil)
+assert(utf8.offset(s, -1) == 7)
+assert(utf8.offset(s, -3) == 2)
+assert(utf8.offset(s, -5) == nil)
+assert(utf8.offset(s, 0, 5) == 4)
+assert(utf8.offset(s, 0, 11) == 11)
+assert(utf8.offset(s, 2, 4) == 7)
+ok, msg = pcall(utf8.offset, s, 1, 3)
+assert(not ok and string.find(msg, "initial position is a continuation byte"))
+ok, msg = pcall(utf8.offset, s, 1, 12)
+assert(not ok and string.find(msg, "position out of range"))
+
+local positions, codes = {}, {}
+for p, c in utf8.codes(s) do
+  table.insert(positions, p)
+  table.insert(codes, c)
+end
+assert(table.concat(positions, ",") == "1,2,4,7")
+assert(table.concat(codes, ",") == "97,228,20013,119070")
+ok, msg = pcall(function()
+  for p, c in utf8.codes("a\255b") do end
+end)
+assert(not ok and string.find(msg, "invalid UTF%-8 code"))
+
+local chars = {}
+for ch in string.gmatch(s, utf8.charpattern) do
+  table.insert(chars, ch)
+end
+assert(#chars == 4 and chars[3] == "\228\184\173")
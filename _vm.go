@@ -24,7 +24,11 @@ func mainLoop(L *LState, baseframe *callFrame) {
 		cf = L.currentFrame
 		inst = cf.Fn.Proto.Code[cf.Pc]
 		cf.Pc++
-		if jumpTable[int(inst>>26)](L, inst, baseframe) == 1 {
+		switch jumpTable[int(inst>>26)](L, inst, baseframe) {
+		case 1:
+			return
+		case 2:
+			mainLoopWithHook(L, baseframe)
 			return
 		}
 	}
@@ -50,13 +54,98 @@ func mainLoopWithContext(L *LState, baseframe *callFrame) {
 		cf.Pc++
 		select {
 		case <-L.ctx.Done():
-			L.RaiseError(L.ctx.Err().Error())
+			L.RaiseError("%s", L.ctx.Err().Error())
 			return
 		default:
-			if jumpTable[int(inst>>26)](L, inst, baseframe) == 1 {
+			switch jumpTable[int(inst>>26)](L, inst, baseframe) {
+			case 1:
+				return
+			case 2:
+				mainLoopWithHook(L, baseframe)
+				return
+			}
+		}
+	}
+}
+
+func mainLoopWithHook(L *LState, baseframe *callFrame) {
+	var inst uint32
+	var cf *callFrame
+
+	if L.stack.IsEmpty() {
+		return
+	}
+
+	L.currentFrame = L.stack.Last()
+	if L.currentFrame.Fn.IsG {
+		callGFunction(L, false)
+		return
+	}
+
+	for {
+		cf = L.currentFrame
+		if L.ctx != nil {
+			select {
+			case <-L.ctx.Done():
+				L.RaiseError("%s", L.ctx.Err().Error())
 				return
+			default:
 			}
 		}
+		inst = cf.Fn.Proto.Code[cf.Pc]
+		cf.Pc++
+		if L.G.instLimit > 0 {
+			L.countInstruction()
+		}
+		if L.G.mem.exceeded() {
+			L.checkMemory(0)
+		}
+		if L.hook != nil && !L.hook.running {
+			traceExec(L, cf)
+		}
+		if jumpTable[int(inst>>26)](L, inst, baseframe) == 1 {
+			return
+		}
+	}
+}
+
+// traceExec calls the debug hooks for the instruction that is about to be executed in the given frame.
+// cf.Pc has already been incremented as in the other instructions.
+func traceExec(L *LState, cf *callFrame) {
+	h := L.hook
+	proto := cf.Fn.Proto
+	pc := cf.Pc - 1
+	line := proto.DbgSourcePositions[pc]
+	op := opGetOpCode(proto.Code[pc])
+	newframe := cf != h.lastFrame
+	iscall := pc == 0 && (newframe || h.lastOp == OP_TAILCALL)
+	newline := false
+	switch {
+	case iscall:
+		newline = true
+	case newframe:
+		// returned from a callee: the call instruction is in the same line in most cases
+		newline = pc > 0 && line != proto.DbgSourcePositions[pc-1]
+	default:
+		newline = pc <= h.lastPc || line != h.lastLine
+	}
+	h.lastFrame, h.lastPc, h.lastLine, h.lastOp = cf, pc, line, op
+
+	if iscall && h.mask&HookCall != 0 {
+		L.callHook("call", -1)
+	}
+	if h.mask&HookCount != 0 {
+		h.counter--
+		if h.counter <= 0 {
+			h.counter = h.count
+			L.callHook("count", -1)
+		}
+	}
+	if newline && h.mask&HookLine != 0 {
+		L.callHook("line", line)
+	}
+	if op == OP_RETURN && h.mask&HookReturn != 0 {
+		L.callHook("return", -1)
 	}
 }
 
@@ -104,7 +193,17 @@ func switchToParentThread(L *LState, nargs int, haserror bool, kill bool) {
 
 func callGFunction(L *LState, tailcall bool) bool {
 	frame := L.currentFrame
+	hook := L.hook
+	if hook != nil && (hook.running || hook.mask&(HookCall|HookReturn) == 0) {
+		hook = nil
+	}
+	if hook != nil && hook.mask&HookCall != 0 {
+		L.callHook("call", -1)
+	}
 	gfnret := frame.Fn.GFunction(L)
+	if hook != nil && hook.mask&HookReturn != 0 && L.hook == hook && gfnret >= 0 {
+		L.callHook("return", -1)
+	}
 	if tailcall {
 		L.currentFrame = L.RemoveCallerFrame()
 	}
@@ -138,12 +237,16 @@ func threadRun(L *LState) {
 	defer func() {
 		if rcv := recover(); rcv != nil {
 			var lv LValue
+			typ := ApiErrorRun
 			if v, ok := rcv.(*ApiError); ok {
 				lv = v.Object
+				typ = v.Type
 			} else {
 				lv = LString(fmt.Sprint(rcv))
 			}
 			if parent := L.Parent; parent != nil {
+				// the parent reports the error with the same type, e.g. ApiErrorInstructionLimit
+				L.errType = typ
 				if L.wrapped {
 					L.Push(lv)
 					parent.Panic(L)
@@ -157,6 +260,8 @@ func threadRun(L *LState) {
 			}
 		}
 	}()
+	// limits or hooks may have been changed after the thread was created
+	L.updateMainLoop()
 	L.mainLoop(L, nil)
 }
 
@@ -338,7 +443,7 @@ func init() {
 			RA := lbase + A
 			B := int(inst & 0x1ff)    //GETB
 			C := int(inst>>9) & 0x1ff //GETC
-			v := newLTable(B, C)
+			v := L.newTable(B, C)
 			// +inline-call reg.Set RA v
 			return 0
 		},
@@ -372,6 +477,8 @@ func init() {
 			unaryv := L.rkValue(B)
 			if nm, ok := unaryv.(LNumber); ok {
 				// +inline-call reg.Set RA -nm
+			} else if it, ok := unaryv.(LInteger); ok {
+				// +inline-call reg.Set RA -it
 			} else {
 				op := L.metaOp1(unaryv, "__unm")
 				if op.Type() == LTFunction {
@@ -380,8 +487,12 @@ func init() {
 					L.Call(1, 1)
 					// +inline-call reg.Set RA reg.Pop()
 				} else if str, ok1 := unaryv.(LString); ok1 {
-					if num, err := parseNumber(string(str)); err == nil {
-						// +inline-call reg.Set RA -num
+					if num, err := parseNumberValue(string(str), L.Options.IntegerSubtype); err == nil {
+						if it, ok := num.(LInteger); ok {
+							// +inline-call reg.Set RA -it
+						} else {
+							// +inline-call reg.Set RA -num.(LNumber)
+						}
 					} else {
 						L.RaiseError("__unm undefined")
 					}
@@ -414,7 +525,11 @@ func init() {
 			B := int(inst & 0x1ff) //GETB
 			switch lv := L.rkValue(B).(type) {
 			case LString:
-				// +inline-call reg.SetNumber RA LNumber(len(lv))
+				if L.Options.IntegerSubtype {
+					// +inline-call reg.Set RA LInteger(len(lv))
+				} else {
+					// +inline-call reg.SetNumber RA LNumber(len(lv))
+				}
 			default:
 				op := L.metaOp1(lv, "__len")
 				if op.Type() == LTFunction {
@@ -422,14 +537,17 @@ func init() {
 					reg.Push(lv)
 					L.Call(1, 1)
 					ret := reg.Pop()
-					if ret.Type() == LTNumber {
-						v, _ := ret.(LNumber)
+					if v, ok := ret.(LNumber); ok {
 						// +inline-call reg.SetNumber RA v
 					} else {
 						// +inline-call reg.Set RA ret
 					}
 				} else if lv.Type() == LTTable {
-					// +inline-call reg.SetNumber RA LNumber(lv.(*LTable).Len())
+					if L.Options.IntegerSubtype {
+						// +inline-call reg.Set RA LInteger(lv.(*LTable).Len())
+					} else {
+						// +inline-call reg.SetNumber RA LNumber(lv.(*LTable).Len())
+					}
 				} else {
 					L.RaiseError("__len undefined")
 				}
@@ -499,8 +617,10 @@ func init() {
 				if v2, ok2 := rhs.(LNumber); ok2 {
 					ret = v1 <= v2
 				} else {
-					L.RaiseError("attempt to compare %v with %v", lhs.Type().String(), rhs.Type().String())
+					ret = numberLessThan(L, lhs, rhs, true)
 				}
+			} else if _, ok1 := lhs.(LInteger); ok1 {
+				ret = numberLessThan(L, lhs, rhs, true)
 			} else {
 				if lhs.Type() != rhs.Type() {
 					L.RaiseError("attempt to compare %v with %v", lhs.Type().String(), rhs.Type().String())
@@ -579,8 +699,14 @@ func init() {
 				callable, meta = L.metaCall(lv)
 			}
 			// +inline-call L.pushCallFrame callFrame{Fn:callable,Pc:0,Base:RA,LocalBase:RA+1,ReturnBase:RA,NArgs:nargs,NRet:nret,Parent:cf,TailCall:0} lv meta
-			if callable.IsG && callGFunction(L, false) {
-				return 1
+			if callable.IsG {
+				if callGFunction(L, false) {
+					return 1
+				}
+				// the Go function may have set a hook or a limit, the main loop must be switched
+				if L.needsCheckedLoop() {
+					return 2
+				}
 			}
 			return 0
 		},
@@ -627,6 +753,9 @@ func init() {
 				if L.currentFrame == nil || L.currentFrame.Fn.IsG || luaframe == baseframe {
 					return 1
 				}
+				if L.needsCheckedLoop() {
+					return 2
+				}
 			} else {
 				base := cf.Base
 				cf.Fn = callable
@@ -704,8 +833,9 @@ func init() {
 				} else {
 					L.RaiseError("for statement limit must be a number")
 				}
-			} else {
-				L.RaiseError("for statement init must be a number")
+			} else if L.forLoop(RA) {
+				Sbx := int(inst&0x3ffff) - opMaxArgSbx //GETSBX
+				cf.Pc += Sbx
 			}
 			return 0
 		},
@@ -716,14 +846,12 @@ func init() {
 			A := int(inst>>18) & 0xff //GETA
 			RA := lbase + A
 			Sbx := int(inst&0x3ffff) - opMaxArgSbx //GETSBX
-			if init, ok1 := reg.Get(RA).(LNumber); ok1 {
-				if step, ok2 := reg.Get(RA + 2).(LNumber); ok2 {
-					// +inline-call reg.SetNumber RA LNumber(init-step)
-				} else {
-					L.RaiseError("for statement step must be a number")
-				}
+			init, ok1 := reg.Get(RA).(LNumber)
+			step, ok2 := reg.Get(RA + 2).(LNumber)
+			if _, ok3 := reg.Get(RA + 1).(LInteger); ok1 && ok2 && !ok3 {
+				// +inline-call reg.SetNumber RA LNumber(init-step)
 			} else {
-				L.RaiseError("for statement init must be a number")
+				L.forPrep(RA)
 			}
 			cf.Pc += Sbx
 			return 0
@@ -762,7 +890,10 @@ func init() {
 				cf.Pc++
 			}
 			offset := (C - 1) * FieldsPerFlush
-			table := reg.Get(RA).(*LTable)
+			table, ok := reg.Get(RA).(*LTable)
+			if !ok {
+				L.RaiseError("attempt to set list items of a non-table object(%v)", reg.Get(RA).Type().String())
+			}
 			nelem := B
 			if B == 0 {
 				nelem = reg.Top() - RA - 1
@@ -898,6 +1029,9 @@ func objectArith(L *LState, opcode int, lhs, rhs LValue) LValue {
 	case OP_POW:
 		event = "__pow"
 	}
+	if v, ok := integerArith(L, opcode, lhs, rhs); ok {
+		return v
+	}
 	op := L.metaOp2(lhs, rhs, event)
 	if _, ok := op.(*LFunction); ok {
 		L.reg.Push(op)
@@ -907,12 +1041,12 @@ func objectArith(L *LState, opcode int, lhs, rhs LValue) LValue {
 		return L.reg.Pop()
 	}
 	if str, ok := lhs.(LString); ok {
-		if lnum, err := parseNumber(string(str)); err == nil {
+		if lnum, err := parseNumberValue(string(str), L.Options.IntegerSubtype); err == nil {
 			lhs = lnum
 		}
 	}
 	if str, ok := rhs.(LString); ok {
-		if rnum, err := parseNumber(string(str)); err == nil {
+		if rnum, err := parseNumberValue(string(str), L.Options.IntegerSubtype); err == nil {
 			rhs = rnum
 		}
 	}
@@ -921,8 +1055,11 @@ func objectArith(L *LState, opcode int, lhs, rhs LValue) LValue {
 			return numberArith(L, opcode, LNumber(v1), LNumber(v2))
 		}
 	}
-	L.RaiseError(fmt.Sprintf("cannot perform %v operation between %v and %v",
-		strings.TrimLeft(event, "_"), lhs.Type().String(), rhs.Type().String()))
+	if v, ok := integerArith(L, opcode, lhs, rhs); ok {
+		return v
+	}
+	L.RaiseError("cannot perform %v operation between %v and %v",
+		strings.TrimLeft(event, "_"), lhs.Type().String(), rhs.Type().String())
 
 	return LNil
 }
@@ -949,15 +1086,18 @@ func stringConcat(L *LState, total, last int) LValue {
 		} else {
 			buf := make([]string, total+1)
 			buf[total] = LVAsString(rhs)
+			n := len(buf[total])
 			for total > 0 {
 				lhs = L.reg.Get(i)
 				if !LVCanConvToString(lhs) {
 					break
 				}
 				buf[total-1] = LVAsString(lhs)
+				n += len(buf[total-1])
 				i--
 				total--
 			}
+			L.allocString(n)
 			rhs = LString(strings.Join(buf, ""))
 		}
 	}
@@ -970,7 +1110,10 @@ func lessThan(L *LState, lhs, rhs LValue) bool {
 		if v2, ok2 := rhs.(LNumber); ok2 {
 			return v1 < v2
 		}
-		L.RaiseError("attempt to compare %v with %v", lhs.Type().String(), rhs.Type().String())
+		return numberLessThan(L, lhs, rhs, false)
+	}
+	if _, ok1 := lhs.(LInteger); ok1 {
+		return numberLessThan(L, lhs, rhs, false)
 	}
 	if lhs.Type() != rhs.Type() {
 		L.RaiseError("attempt to compare %v with %v", lhs.Type().String(), rhs.Type().String())
@@ -997,9 +1140,13 @@ func equals(L *LState, lhs, rhs LValue, raw bool) bool {
 	case LTNil:
 		ret = true
 	case LTNumber:
-		v1, _ := lhs.(LNumber)
-		v2, _ := rhs.(LNumber)
-		ret = v1 == v2
+		v1, ok1 := lhs.(LNumber)
+		v2, ok2 := rhs.(LNumber)
+		if ok1 && ok2 {
+			ret = v1 == v2
+		} else {
+			ret = numberEquals(lhs, rhs)
+		}
 	case LTBool:
 		ret = bool(lhs.(LBool)) == bool(rhs.(LBool))
 	case LTString:
@@ -32,6 +32,8 @@ type allocator struct {
 
 	scratchValue  LValue
 	scratchValueP *iface
+
+	mem *memoryAccount
 }
 
 func newAllocator(size int) *allocator {
@@ -64,6 +66,7 @@ func (al *allocator) LNumber2I(v LNumber) LValue {
 	if cap(al.fptrs) == len(al.fptrs) {
 		al.fptrs = make([]float64, 0, al.size)
 		al.fheader = (*reflect.SliceHeader)(unsafe.Pointer(&al.fptrs))
+		al.mem.add(al.size * 8)
 	}
 
 	// alloc a new float, and store our value into it
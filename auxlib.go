@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"reflect"
 	"strings"
 )
 
@@ -22,6 +23,9 @@ func (ls *LState) CheckInt(n int) int {
 	if intv, ok := v.(LNumber); ok {
 		return int(intv)
 	}
+	if intv, ok := v.(LInteger); ok {
+		return int(intv)
+	}
 	ls.TypeError(n, LTNumber)
 	return 0
 }
@@ -31,6 +35,9 @@ func (ls *LState) CheckInt64(n int) int64 {
 	if intv, ok := v.(LNumber); ok {
 		return int64(intv)
 	}
+	if intv, ok := v.(LInteger); ok {
+		return int64(intv)
+	}
 	ls.TypeError(n, LTNumber)
 	return 0
 }
@@ -40,6 +47,9 @@ func (ls *LState) CheckNumber(n int) LNumber {
 	if lv, ok := v.(LNumber); ok {
 		return lv
 	}
+	if lv, ok := v.(LInteger); ok {
+		return LNumber(lv)
+	}
 	if lv, ok := v.(LString); ok {
 		if num, err := parseNumber(string(lv)); err == nil {
 			return num
@@ -149,6 +159,9 @@ func (ls *LState) OptInt(n int, d int) int {
 	if intv, ok := v.(LNumber); ok {
 		return int(intv)
 	}
+	if intv, ok := v.(LInteger); ok {
+		return int(intv)
+	}
 	ls.TypeError(n, LTNumber)
 	return 0
 }
@@ -161,6 +174,9 @@ func (ls *LState) OptInt64(n int, d int64) int64 {
 	if intv, ok := v.(LNumber); ok {
 		return int64(intv)
 	}
+	if intv, ok := v.(LInteger); ok {
+		return int64(intv)
+	}
 	ls.TypeError(n, LTNumber)
 	return 0
 }
@@ -173,6 +189,9 @@ func (ls *LState) OptNumber(n int, d LNumber) LNumber {
 	if lv, ok := v.(LNumber); ok {
 		return lv
 	}
+	if lv, ok := v.(LInteger); ok {
+		return LNumber(lv)
+	}
 	ls.TypeError(n, LTNumber)
 	return 0
 }
@@ -462,4 +481,65 @@ func (ls *LState) OptChannel(n int, ch chan LValue) chan LValue {
 
 /* }}} */
 
+/* generic argument helpers {{{ */
+
+// CheckUserDataOf checks whether the given index is a userdata whose Value is a T and returns the Value.
+// Otherwise, raises an error like "bad argument #1 to f (*main.Person expected, got number)".
+func CheckUserDataOf[T any](L *LState, n int) T {
+	if ud, ok := L.Get(n).(*LUserData); ok {
+		if v, ok := ud.Value.(T); ok {
+			return v
+		}
+	}
+	L.ArgError(n, fmt.Sprintf("%v expected, got %v", reflect.TypeFor[T](), luaTypeName(L.Get(n))))
+	var zero T
+	return zero
+}
+
+// CheckOf converts the given index to a T like the arguments of functions bridged by LState.Bridge, and
+// raises an error if it can not be converted.
+func CheckOf[T any](L *LState, n int) T {
+	return L.checkGoValue(n, reflect.TypeFor[T]()).Interface().(T)
+}
+
+// OptOf converts the given index to a T like CheckOf. If this argument is absent or is nil, returns d.
+func OptOf[T any](L *LState, n int, d T) T {
+	if L.Get(n) == LNil {
+		return d
+	}
+	return CheckOf[T](L, n)
+}
+
+// WrapOptions is a configuration of functions converted by Wrap.
+type WrapOptions struct {
+	// If ReturnErrors is true, a non-nil error returned by the function is returned to Lua as nil and the error
+	// message like io.open. Otherwise, the error is raised.
+	ReturnErrors bool
+}
+
+// Wrap converts a Go function to an LGFunction. The Lua arguments are converted to the parameter types of fn like
+// CheckOf, and the results are converted by LState.ToLValue. Parameters of the types *LState and
+// context.Context that precede the other parameters receive the calling LState and its context
+// (context.Background() if the LState has no context). A non-nil error returned as the last result is handled
+// as configured by opts.
+//
+//	L.SetGlobal("repeat", L.NewFunction(lua.Wrap(func(ctx context.Context, s string, n int) (string, error) {
+//		return strings.Repeat(s, n), ctx.Err()
+//	})))
+func Wrap(fn interface{}, opts ...WrapOptions) LGFunction {
+	rv := reflect.ValueOf(fn)
+	if rv.Kind() != reflect.Func || rv.IsNil() {
+		panic(fmt.Sprintf("lua: Wrap expects a function, got %T", fn))
+	}
+	var opt WrapOptions
+	if len(opts) > 0 {
+		opt = opts[0]
+	}
+	return func(L *LState) int {
+		return L.callGoFunction(rv, 1, opt.ReturnErrors)
+	}
+}
+
+/* }}} */
+
 //
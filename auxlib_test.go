@@ -1,7 +1,11 @@
 package lua
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"os"
+	"strings"
 	"testing"
 )
 
@@ -298,6 +302,72 @@ func TestOptChannel(t *testing.T) {
 	}, "channel expected, got string")
 }
 
+func TestCheckUserDataOf(t *testing.T) {
+	L := NewState()
+	defer L.Close()
+	type point struct{ X, Y int }
+	errorIfGFuncNotFail(t, L, func(L *LState) int {
+		ud := L.NewUserData()
+		ud.Value = &point{1, 2}
+		L.Push(ud)
+		errorIfNotEqual(t, 2, CheckUserDataOf[*point](L, 2).Y)
+		L.Push(LNumber(1))
+		CheckUserDataOf[*point](L, 3)
+		return 0
+	}, `\*lua.point expected, got number`)
+	errorIfGFuncNotFail(t, L, func(L *LState) int {
+		ud := L.NewUserData()
+		ud.Value = "str"
+		L.Push(ud)
+		CheckUserDataOf[*point](L, 2)
+		return 0
+	}, `\*lua.point expected, got userdata\(string\)`)
+}
+
+func TestCheckOfAndOptOf(t *testing.T) {
+	L := NewState()
+	defer L.Close()
+	errorIfGFuncNotFail(t, L, func(L *LState) int {
+		L.Push(LString("a"))
+		L.Push(L.NewTable())
+		L.Get(3).(*LTable).Append(LNumber(1))
+		errorIfNotEqual(t, "a", CheckOf[string](L, 2))
+		errorIfNotEqual(t, 1, CheckOf[[]int](L, 3)[0])
+		errorIfNotEqual(t, 10, OptOf[int](L, 4, 10))
+		errorIfNotEqual(t, 1.5, OptOf[float64](L, 4, 1.5))
+		OptOf[int](L, 2, 10)
+		return 0
+	}, `bad argument #2 to .*\(int expected, got string\)`)
+}
+
+func TestWrap(t *testing.T) {
+	L := NewState()
+	defer L.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	L.SetContext(ctx)
+	repeat := func(ctx context.Context, s string, n int) (string, error) {
+		if n < 0 {
+			return "", errors.New("negative count")
+		}
+		return strings.Repeat(s, n), ctx.Err()
+	}
+	L.SetGlobal("rep", L.NewFunction(Wrap(repeat)))
+	L.SetGlobal("try_rep", L.NewFunction(Wrap(repeat, WrapOptions{ReturnErrors: true})))
+	L.SetGlobal("top", L.NewFunction(Wrap(func(L *LState, v ...interface{}) int { return L.GetTop() })))
+	errorIfScriptFail(t, L, `
+	  assert(rep("ab", 2) == "abab")
+	  local ok, msg = try_rep("ab", -1)
+	  assert(ok == nil and msg == "negative count")
+	  assert(try_rep("a", 1) == "a")
+	  assert(top(1, nil, "a") == 3)
+	`)
+	errorIfScriptNotFail(t, L, `rep("ab", -1)`, "negative count")
+	errorIfScriptNotFail(t, L, `rep("ab", "x")`, `bad argument #2 to rep \(int expected, got string\)`)
+	L.RemoveContext()
+	errorIfScriptFail(t, L, `assert(rep("a", 3) == "aaa")`)
+}
+
 func TestLoadFileForShebang(t *testing.T) {
 	tmpFile, err := os.CreateTemp("", "")
 	errorIfNotNil(t, err)
@@ -334,3 +404,131 @@ func TestLoadFileForEmptyFile(t *testing.T) {
 	_, err = L.LoadFile(tmpFile.Name())
 	errorIfNotNil(t, err)
 }
+
+func TestLoadFileForBinaryChunk(t *testing.T) {
+	L := NewState()
+	defer L.Close()
+
+	fn, err := L.LoadString(`local a, b = ... return a + b, "sum"`)
+	errorIfNotNil(t, err)
+	data, err := fn.Proto.MarshalBinary()
+	errorIfNotNil(t, err)
+
+	tmpFile, err := os.CreateTemp("", "")
+	errorIfNotNil(t, err)
+	defer func() {
+		tmpFile.Close()
+		os.Remove(tmpFile.Name())
+	}()
+	err = os.WriteFile(tmpFile.Name(), data, 0644)
+	errorIfNotNil(t, err)
+
+	fn, err = L.LoadFile(tmpFile.Name())
+	errorIfNotNil(t, err)
+	L.Push(fn)
+	L.Push(LNumber(1))
+	L.Push(LNumber(2))
+	L.Call(2, 2)
+	errorIfNotEqual(t, LNumber(3), L.Get(-2))
+	errorIfNotEqual(t, LString("sum"), L.Get(-1))
+
+	proto := &FunctionProto{}
+	errorIfNotNil(t, proto.UnmarshalBinary(data))
+	errorIfNotEqual(t, fn.Proto.String(), proto.String())
+
+	data[len(BinaryChunkSignature)] = BinaryChunkVersion + 1
+	errorIfNil(t, proto.UnmarshalBinary(data))
+	errorIfNil(t, proto.UnmarshalBinary([]byte("print(1)")))
+}
+
+func TestVerifyProto(t *testing.T) {
+	L := NewState()
+	defer L.Close()
+
+	fn, err := L.LoadString(`local a = {1, 2, 3} for i, v in ipairs(a) do a[i] = v * 2 end return a[3]`)
+	errorIfNotNil(t, err)
+	errorIfNotNil(t, VerifyProto(fn.Proto))
+
+	corrupt := func(pc int, inst uint32) *FunctionProto {
+		data, err := fn.Proto.MarshalBinary()
+		errorIfNotNil(t, err)
+		proto := &FunctionProto{}
+		errorIfNotNil(t, proto.UnmarshalBinary(data))
+		proto.Code[pc] = inst
+		return proto
+	}
+	for _, inst := range []uint32{
+		opCreateABC(OP_MOVE, 200, 0, 0),
+		opCreateABx(OP_LOADK, 0, 100),
+		opCreateASbx(OP_JMP, 0, 1000),
+		opCreateABC(OP_GETUPVAL, 0, 0, 0),
+		opCreateABx(OP_CLOSURE, 0, 0),
+		opCreateABC(OP_LOADNIL, 0, 250, 0),
+		opCreateABC(opCodeMax+1, 0, 0, 0),
+	} {
+		proto := corrupt(0, inst)
+		err := VerifyProto(proto)
+		errorIfNil(t, err)
+		_, ok := err.(*VerifyError)
+		errorIfFalse(t, ok, "%v must be a VerifyError", err)
+
+		errorIfGFuncNotFail(t, L, func(L *LState) int {
+			L.NewFunctionFromProto(proto)
+			return 0
+		}, "bad function prototype")
+
+		data, err := proto.MarshalBinary()
+		errorIfNotNil(t, err)
+		_, err = L.Load(bytes.NewReader(data), "corrupt")
+		errorIfNil(t, err)
+	}
+
+	proto := corrupt(len(fn.Proto.Code)-1, opCreateABC(OP_NOP, 0, 0, 0))
+	errorIfNil(t, VerifyProto(proto))
+}
+
+func TestVerifyProtoRegisters(t *testing.T) {
+	L := NewState()
+	defer L.Close()
+
+	patch := func(src string, pc int, inst uint32) *FunctionProto {
+		fn, err := L.LoadString(src)
+		errorIfNotNil(t, err)
+		data, err := fn.Proto.MarshalBinary()
+		errorIfNotNil(t, err)
+		proto := &FunctionProto{}
+		errorIfNotNil(t, proto.UnmarshalBinary(data))
+		proto.Code[pc] = inst
+		return proto
+	}
+	for _, c := range []struct {
+		proto *FunctionProto
+		msg   string
+	}{
+		{patch(`local a, b = "x", "y" return a .. b`, 1, opCreateABC(OP_NOP, 0, 0, 0)),
+			"register 1 is read before it is written"},
+		// calls clear the registers above their results
+		{patch(`local a, b = "x", "y" return a .. b`, 2, opCreateABC(OP_CALL, 1, 1, 1)),
+			"register 1 is read before it is written"},
+		{patch(`local x = 1 local f = function() return x end f() return x`, 4, opCreateABC(OP_CALL, 0, 1, 1)),
+			"register 0 is referred to by an open upvalue"},
+	} {
+		err := VerifyProto(c.proto)
+		errorIfFalse(t, err != nil && strings.Contains(err.Error(), c.msg), "%v expected, got %v", c.msg, err)
+	}
+
+	// type errors in valid prototypes are Lua errors
+	for _, c := range []struct {
+		proto *FunctionProto
+		msg   string
+	}{
+		{patch(`return {1, 2}`, 0, opCreateABx(OP_LOADK, 0, 0)), "attempt to set list items of a non-table object(number)"},
+		{patch(`local t, k = {}, 1 return t[k]`, 2, opCreateABC(OP_GETTABLEKS, 2, 0, 1)), "string key expected, got number"},
+	} {
+		errorIfNotNil(t, VerifyProto(c.proto))
+		L.Push(L.NewFunctionFromProto(c.proto))
+		err := L.PCall(0, 0, nil)
+		errorIfFalse(t, err != nil && strings.Contains(err.Error(), c.msg), "%v expected, got %v", c.msg, err)
+		errorIfNotEqual(t, ApiErrorRun, err.(*ApiError).Type)
+	}
+}
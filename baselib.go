@@ -4,7 +4,6 @@ import (
 	"fmt"
 	"io"
 	"os"
-	"runtime"
 	"strconv"
 	"strings"
 )
@@ -38,6 +37,7 @@ var baseFuncs = map[string]LGFunction{
 	"print":          basePrint,
 	"rawequal":       baseRawEqual,
 	"rawget":         baseRawGet,
+	"rawlen":         baseRawLen,
 	"rawset":         baseRawSet,
 	"select":         baseSelect,
 	"_printregs":     base_PrintRegs,
@@ -57,15 +57,39 @@ var baseFuncs = map[string]LGFunction{
 
 func baseAssert(L *LState) int {
 	if !L.ToBool(1) {
-		L.RaiseError(L.OptString(2, "assertion failed!"))
+		L.RaiseError("%s", L.OptString(2, "assertion failed!"))
 		return 0
 	}
 	return L.GetTop()
 }
 
 func baseCollectGarbage(L *LState) int {
-	runtime.GC()
-	return 0
+	gc := &L.G.gc
+	switch opt := L.OptString(1, "collect"); opt {
+	case "collect":
+		L.collectGarbage(true)
+		L.Push(LNumber(0))
+	case "step":
+		L.collectGarbage(false)
+		L.Push(LTrue)
+	case "count":
+		L.Push(LNumber(float64(L.MemoryUsage()) / 1024))
+	case "stop":
+		gc.stopped = true
+		L.Push(LNumber(0))
+	case "restart":
+		gc.stopped = false
+		L.Push(LNumber(0))
+	case "setpause":
+		L.Push(LNumber(gc.pause))
+		gc.pause = L.OptInt(2, 0)
+	case "setstepmul":
+		L.Push(LNumber(gc.stepmul))
+		gc.stepmul = L.OptInt(2, 0)
+	default:
+		L.ArgError(1, "invalid option '"+opt+"'")
+	}
+	return 1
 }
 
 func baseDoFile(L *LState) int {
@@ -105,6 +129,9 @@ func baseGetFEnv(L *LState) int {
 		return 1
 	}
 
+	if iv, ok := value.(LInteger); ok {
+		value = LNumber(iv)
+	}
 	if number, ok := value.(LNumber); ok {
 		level := int(float64(number))
 		if level <= 0 {
@@ -141,14 +168,17 @@ func ipairsaux(L *LState) int {
 		return 0
 	} else {
 		L.Pop(1)
-		L.Push(LNumber(i))
-		L.Push(LNumber(i))
+		L.Push(integerValue(L, int64(i)))
+		L.Push(integerValue(L, int64(i)))
 		L.Push(v)
 		return 2
 	}
 }
 
 func baseIpairs(L *LState) int {
+	if metaCallPairs(L, "__ipairs") {
+		return 3
+	}
 	tb := L.CheckTable(1)
 	L.Push(L.Get(UpvalueIndex(1)))
 	L.Push(tb)
@@ -168,31 +198,68 @@ func loadaux(L *LState, reader io.Reader, chunkname string) int {
 }
 
 func baseLoad(L *LState) int {
-	fn := L.CheckFunction(1)
-	chunkname := L.OptString(2, "?")
-	top := L.GetTop()
-	buf := []string{}
-	for {
-		L.SetTop(top)
-		L.Push(fn)
-		L.Call(0, 1)
-		ret := L.reg.Pop()
-		if ret == LNil {
-			break
-		} else if LVCanConvToString(ret) {
-			str := ret.String()
-			if len(str) > 0 {
-				buf = append(buf, string(str))
-			} else {
+	var chunk string
+	chunkname := "?"
+	switch lv := L.Get(1).(type) {
+	case LString:
+		chunk = string(lv)
+		chunkname = "<string>"
+	case *LFunction:
+		top := L.GetTop()
+		buf := []string{}
+		for {
+			L.SetTop(top)
+			L.Push(lv)
+			L.Call(0, 1)
+			ret := L.reg.Pop()
+			if ret == LNil {
 				break
+			} else if LVCanConvToString(ret) {
+				str := ret.String()
+				if len(str) > 0 {
+					buf = append(buf, string(str))
+				} else {
+					break
+				}
+			} else {
+				L.Push(LNil)
+				L.Push(LString("reader function must return a string"))
+				return 2
 			}
-		} else {
-			L.Push(LNil)
-			L.Push(LString("reader function must return a string"))
-			return 2
 		}
+		chunk = strings.Join(buf, "")
+	default:
+		L.ArgError(1, "string or function expected")
+	}
+	chunkname = L.OptString(2, chunkname)
+	mode := L.OptString(3, "bt")
+	kind, allowed := "text", strings.Contains(mode, "t")
+	if strings.HasPrefix(chunk, BinaryChunkSignature) {
+		kind, allowed = "binary", strings.Contains(mode, "b")
+	}
+	if !allowed {
+		L.Push(LNil)
+		L.Push(LString(fmt.Sprintf("attempt to load a %s chunk (mode is '%s')", kind, mode)))
+		return 2
 	}
-	return loadaux(L, strings.NewReader(strings.Join(buf, "")), chunkname)
+	fn, err := L.Load(strings.NewReader(chunk), chunkname)
+	if err != nil {
+		L.Push(LNil)
+		L.Push(LString(err.Error()))
+		return 2
+	}
+	if L.GetTop() >= 4 {
+		// the env of a Lua52 chunk may be any value, but Lua51 chunks need a table
+		env := L.Get(4)
+		if tb, ok := env.(*LTable); ok {
+			fn.Env = tb
+		} else if !fn.hasEnvUpvalue() {
+			L.TypeError(4, LTTable)
+		}
+		fn.setEnvUpvalue(env)
+	}
+	L.Push(fn)
+	return 1
 }
 
 func baseLoadFile(L *LState) int {
@@ -249,7 +316,23 @@ func pairsaux(L *LState) int {
 	}
 }
 
+// metaCallPairs calls the __pairs or __ipairs metamethod of the first argument like Lua 5.2.
+// It returns false if the argument does not have the metamethod.
+func metaCallPairs(L *LState, event string) bool {
+	mm := L.GetMetaField(L.CheckAny(1), event)
+	if mm == LNil {
+		return false
+	}
+	L.Push(mm)
+	L.Push(L.Get(1))
+	L.Call(1, 3)
+	return true
+}
+
 func basePairs(L *LState) int {
+	if metaCallPairs(L, "__pairs") {
+		return 3
+	}
 	tb := L.CheckTable(1)
 	L.Push(L.Get(UpvalueIndex(1)))
 	L.Push(tb)
@@ -311,6 +394,18 @@ func baseRawGet(L *LState) int {
 	return 1
 }
 
+func baseRawLen(L *LState) int {
+	switch lv := L.Get(1).(type) {
+	case *LTable:
+		L.Push(LNumber(lv.Len()))
+	case LString:
+		L.Push(LNumber(len(lv)))
+	default:
+		L.ArgError(1, "table or string expected")
+	}
+	return 1
+}
+
 func baseRawSet(L *LState) int {
 	L.RawSet(L.CheckTable(1), L.CheckAny(2), L.CheckAny(3))
 	return 0
@@ -319,8 +414,8 @@ func baseRawSet(L *LState) int {
 func baseSelect(L *LState) int {
 	L.CheckTypes(1, LTNumber, LTString)
 	switch lv := L.Get(1).(type) {
-	case LNumber:
-		idx := int(lv)
+	case LNumber, LInteger:
+		idx := L.CheckInt(1)
 		num := L.GetTop()
 		if idx < 0 {
 			idx = num + idx
@@ -360,6 +455,9 @@ func baseSetFEnv(L *LState) int {
 		}
 	}
 
+	if iv, ok := value.(LInteger); ok {
+		value = LNumber(iv)
+	}
 	if number, ok := value.(LNumber); ok {
 		level := int(float64(number))
 		if level <= 0 {
@@ -406,7 +504,7 @@ func baseToNumber(L *LState) int {
 	noBase := L.Get(2) == LNil
 
 	switch lv := L.CheckAny(1).(type) {
-	case LNumber:
+	case LNumber, LInteger:
 		L.Push(lv)
 	case LString:
 		str := strings.Trim(string(lv), " \n\t")
@@ -422,6 +520,8 @@ func baseToNumber(L *LState) int {
 			}
 			if v, err := strconv.ParseInt(str, base, LNumberBit); err != nil {
 				L.Push(LNil)
+			} else if L.Options.IntegerSubtype {
+				L.Push(LInteger(v))
 			} else {
 				L.Push(LNumber(v))
 			}
@@ -463,7 +563,10 @@ func baseXPCall(L *LState) int {
 
 	top := L.GetTop()
 	L.Push(fn)
-	if err := L.PCall(0, MultRet, errfunc); err != nil {
+	for i := 3; i <= top; i++ {
+		L.Push(L.Get(i))
+	}
+	if err := L.PCall(top-2, MultRet, errfunc); err != nil {
 		L.Push(LFalse)
 		if aerr, ok := err.(*ApiError); ok {
 			L.Push(aerr.Object)
@@ -585,8 +688,11 @@ func baseNewProxy(L *LState) int {
 	L.SetTop(1)
 	if L.Get(1) == LTrue {
 		L.SetMetatable(ud, L.NewTable())
+		// __gc is usually set after the proxy has been created
+		L.setFinalizer(ud, nil)
 	} else if d, ok := L.Get(1).(*LUserData); ok {
 		L.SetMetatable(ud, L.GetMetatable(d))
+		L.setFinalizer(ud, nil)
 	}
 	L.Push(ud)
 	return 1
@@ -139,3 +139,42 @@ func assertOsDateFields(t *testing.T, key LValue, value LValue, expect time.Time
 		}
 	}
 }
+
+func TestPairsMetamethods(t *testing.T) {
+	L := NewState()
+	defer L.Close()
+	// a userdata proxy of a Go map
+	data := map[string]LValue{"x": LNumber(1)}
+	mt := L.NewTable()
+	L.SetField(mt, "__pairs", L.NewFunction(func(L *LState) int {
+		L.Push(L.NewFunction(func(L *LState) int {
+			if L.Get(2) != LNil {
+				return 0
+			}
+			L.Push(LString("x"))
+			L.Push(data["x"])
+			return 2
+		}))
+		L.Push(L.Get(1))
+		L.Push(LNil)
+		return 3
+	}))
+	L.SetField(mt, "__len", L.NewFunction(func(L *LState) int {
+		L.Push(LNumber(len(data)))
+		return 1
+	}))
+	ud := L.NewUserData()
+	L.SetMetatable(ud, mt)
+	L.SetGlobal("proxy", ud)
+	errorIfScriptFail(t, L, `
+	  local n = 0
+	  for k, v in pairs(proxy) do assert(k == "x" and v == 1); n = n + 1 end
+	  assert(n == 1 and #proxy == 1)
+	`)
+	errorIfNotEqual(t, 1, L.ObjLen(ud))
+
+	tbl := L.NewTable()
+	L.SetMetatable(tbl, mt)
+	errorIfNotEqual(t, 1, L.ObjLen(tbl))
+	errorIfScriptNotFail(t, L, `pairs(1)`, "table expected")
+}
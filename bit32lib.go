@@ -0,0 +1,162 @@
+package lua
+
+import (
+	"math"
+)
+
+func OpenBit32(L *LState) int {
+	mod := L.RegisterModule(Bit32LibName, bit32Funcs)
+	L.Push(mod)
+	return 1
+}
+
+var bit32Funcs = map[string]LGFunction{
+	"arshift": bit32Arshift,
+	"band":    bit32Band,
+	"bnot":    bit32Bnot,
+	"bor":     bit32Bor,
+	"btest":   bit32Btest,
+	"bxor":    bit32Bxor,
+	"extract": bit32Extract,
+	"replace": bit32Replace,
+	"lrotate": bit32Lrotate,
+	"lshift":  bit32Lshift,
+	"rrotate": bit32Rrotate,
+	"rshift":  bit32Rshift,
+}
+
+// bit32CheckUnsigned converts the n-th argument to an unsigned 32-bit integer. Numbers are truncated and taken
+// modulo 2^32 like Lua 5.2.
+func bit32CheckUnsigned(L *LState, n int) uint32 {
+	if iv, ok := L.Get(n).(LInteger); ok {
+		return uint32(iv)
+	}
+	f := float64(L.CheckNumber(n))
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return 0
+	}
+	f = math.Mod(math.Floor(f), 0x1p32)
+	if f < 0 {
+		f += 0x1p32
+	}
+	return uint32(f)
+}
+
+func bit32Push(L *LState, v uint32) {
+	L.Push(integerValue(L, int64(v)))
+}
+
+func bit32Fold(L *LState, init uint32, fn func(uint32, uint32) uint32) uint32 {
+	v := init
+	top := L.GetTop()
+	for i := 1; i <= top; i++ {
+		v = fn(v, bit32CheckUnsigned(L, i))
+	}
+	return v
+}
+
+func bit32Band(L *LState) int {
+	bit32Push(L, bit32Fold(L, math.MaxUint32, func(a, b uint32) uint32 { return a & b }))
+	return 1
+}
+
+func bit32Bor(L *LState) int {
+	bit32Push(L, bit32Fold(L, 0, func(a, b uint32) uint32 { return a | b }))
+	return 1
+}
+
+func bit32Bxor(L *LState) int {
+	bit32Push(L, bit32Fold(L, 0, func(a, b uint32) uint32 { return a ^ b }))
+	return 1
+}
+
+func bit32Btest(L *LState) int {
+	L.Push(LBool(bit32Fold(L, math.MaxUint32, func(a, b uint32) uint32 { return a & b }) != 0))
+	return 1
+}
+
+func bit32Bnot(L *LState) int {
+	bit32Push(L, ^bit32CheckUnsigned(L, 1))
+	return 1
+}
+
+// bit32Shift shifts x to the left by disp bits, or to the right if disp is negative.
+func bit32Shift(x uint32, disp int) uint32 {
+	switch {
+	case disp <= -32 || disp >= 32:
+		return 0
+	case disp < 0:
+		return x >> uint(-disp)
+	}
+	return x << uint(disp)
+}
+
+func bit32Lshift(L *LState) int {
+	bit32Push(L, bit32Shift(bit32CheckUnsigned(L, 1), L.CheckInt(2)))
+	return 1
+}
+
+func bit32Rshift(L *LState) int {
+	bit32Push(L, bit32Shift(bit32CheckUnsigned(L, 1), -L.CheckInt(2)))
+	return 1
+}
+
+func bit32Arshift(L *LState) int {
+	x := bit32CheckUnsigned(L, 1)
+	disp := L.CheckInt(2)
+	switch {
+	case disp < 0:
+		bit32Push(L, bit32Shift(x, -disp))
+	case disp >= 32:
+		bit32Push(L, uint32(int32(x)>>31))
+	default:
+		bit32Push(L, uint32(int32(x)>>uint(disp)))
+	}
+	return 1
+}
+
+func bit32Rotate(x uint32, disp int) uint32 {
+	disp &= 31
+	return x<<uint(disp) | x>>uint(32-disp)
+}
+
+func bit32Lrotate(L *LState) int {
+	bit32Push(L, bit32Rotate(bit32CheckUnsigned(L, 1), L.CheckInt(2)))
+	return 1
+}
+
+func bit32Rrotate(L *LState) int {
+	bit32Push(L, bit32Rotate(bit32CheckUnsigned(L, 1), -L.CheckInt(2)))
+	return 1
+}
+
+// bit32CheckField checks the field and width arguments of extract and replace, and returns the mask of the field.
+func bit32CheckField(L *LState, fieldn int) (int, uint32) {
+	field := L.CheckInt(fieldn)
+	width := L.OptInt(fieldn+1, 1)
+	if field < 0 {
+		L.ArgError(fieldn, "field cannot be negative")
+	}
+	if width <= 0 {
+		L.ArgError(fieldn+1, "width must be positive")
+	}
+	if field+width > 32 {
+		L.RaiseError("trying to access non-existent bits")
+	}
+	return field, uint32(math.MaxUint32 >> uint(32-width))
+}
+
+func bit32Extract(L *LState) int {
+	n := bit32CheckUnsigned(L, 1)
+	field, mask := bit32CheckField(L, 2)
+	bit32Push(L, (n>>uint(field))&mask)
+	return 1
+}
+
+func bit32Replace(L *LState) int {
+	n := bit32CheckUnsigned(L, 1)
+	v := bit32CheckUnsigned(L, 2)
+	field, mask := bit32CheckField(L, 3)
+	bit32Push(L, n&^(mask<<uint(field))|(v&mask)<<uint(field))
+	return 1
+}
@@ -0,0 +1,636 @@
+package lua
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+)
+
+var (
+	lvalueType  = reflect.TypeOf((*LValue)(nil)).Elem()
+	errorType   = reflect.TypeOf((*error)(nil)).Elem()
+	contextType = reflect.TypeOf((*context.Context)(nil)).Elem()
+	lstateType  = reflect.TypeOf((*LState)(nil))
+)
+
+// ToLValue converts a Go value to a Lua value. nil, booleans, numbers and strings are converted to the
+// corresponding Lua values, LValues are returned as is, []byte is converted to a string and functions are
+// converted to Lua functions by BridgeFunction. Other values, such as structs, pointers, maps and slices, are
+// exposed to Lua by Bridge.
+func (ls *LState) ToLValue(v interface{}) LValue {
+	switch gv := v.(type) {
+	case nil:
+		return LNil
+	case LValue:
+		return gv
+	case bool:
+		return LBool(gv)
+	case string:
+		return LString(gv)
+	case []byte:
+		return LString(gv)
+	case LGFunction:
+		return ls.NewFunction(gv)
+	case func(*LState) int:
+		return ls.NewFunction(gv)
+	}
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Bool:
+		return LBool(rv.Bool())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return integerValue(ls, rv.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if u := rv.Uint(); u > math.MaxInt64 {
+			return LNumber(u)
+		} else {
+			return integerValue(ls, int64(u))
+		}
+	case reflect.Float32, reflect.Float64:
+		return LNumber(rv.Float())
+	case reflect.String:
+		return LString(rv.String())
+	case reflect.Func:
+		if rv.IsNil() {
+			return LNil
+		}
+		return ls.BridgeFunction(v)
+	case reflect.Ptr, reflect.Map, reflect.Slice, reflect.Chan, reflect.Interface:
+		if rv.IsNil() {
+			return LNil
+		}
+	}
+	return ls.Bridge(v)
+}
+
+// Bridge returns a userdata that exposes a Go value to Lua. The Value of the userdata is v. The metatable of the
+// userdata is generated from the type of v and cached:
+//
+//   - exported fields of structs(and pointers to structs) can be read and, through pointers, assigned.
+//   - exported methods are called with the colon syntax, e.g. obj:Method(1, 2).
+//   - maps are indexed by keys, and slices and arrays are indexed by 1-based integers.
+//   - the length operator returns the length of maps, slices, arrays, strings and channels.
+//   - pairs iterates over fields, map entries or elements.
+//   - functions can be called.
+//
+// Lua values passed to Go are converted to the Go types that are expected, and conversion errors are raised
+// by ArgError. A function whose last result is a non-nil error raises the error.
+func (ls *LState) Bridge(v interface{}) *LUserData {
+	ud := ls.NewUserData()
+	ud.Value = v
+	ud.Metatable = ls.bridgeMetatable(reflect.TypeOf(v))
+	return ud
+}
+
+// BridgeFunction converts a Go function to a Lua function like Wrap.
+func (ls *LState) BridgeFunction(fn interface{}) *LFunction {
+	return ls.NewFunction(Wrap(fn))
+}
+
+/* conversion {{{ */
+
+// goValueOf returns the natural Go value of a Lua value. Userdata is converted to its Value.
+func goValueOf(lv LValue) interface{} {
+	switch v := lv.(type) {
+	case *LNilType:
+		return nil
+	case LBool:
+		return bool(v)
+	case LNumber:
+		return float64(v)
+	case LInteger:
+		return int64(v)
+	case LString:
+		return string(v)
+	case *LUserData:
+		return v.Value
+	}
+	return lv
+}
+
+func luaTypeName(lv LValue) string {
+	if ud, ok := lv.(*LUserData); ok && ud.Value != nil {
+		return fmt.Sprintf("userdata(%T)", ud.Value)
+	}
+	return lv.Type().String()
+}
+
+// luaToIntegral converts a number to an integer. ok is false if lv is not a number, and err is not nil if lv
+// has no integer representation.
+func luaToIntegral(lv LValue) (i int64, ok bool, err error) {
+	switch v := lv.(type) {
+	case LInteger:
+		return int64(v), true, nil
+	case LNumber:
+		if i, ok := floatToInteger(v); ok {
+			return int64(i), true, nil
+		}
+		return 0, true, fmt.Errorf("number %v has no integer representation", v)
+	}
+	return 0, false, nil
+}
+
+// luaToScalar converts a Lua value to a Go boolean, number or string and stores it in rv. These rules are shared
+// by the bridge(Wrap, CheckOf and so on) and Decode:
+//
+//   - booleans are converted from booleans.
+//   - integers are converted from numbers that have an integer representation and fit in the type.
+//   - floats are converted from numbers.
+//   - strings are converted from strings and numbers like CheckString.
+//
+// ok is false if the kind of rv is not one of them or lv can not be converted to it.
+func luaToScalar(lv LValue, rv reflect.Value) (ok bool, err error) {
+	switch rv.Kind() {
+	case reflect.Bool:
+		if b, ok := lv.(LBool); ok {
+			rv.SetBool(bool(b))
+			return true, nil
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, ok, err := luaToIntegral(lv)
+		if !ok || err != nil {
+			return ok, err
+		}
+		if rv.OverflowInt(i) {
+			return true, fmt.Errorf("number %v out of range for %v", lv, rv.Type())
+		}
+		rv.SetInt(i)
+		return true, nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		i, ok, err := luaToIntegral(lv)
+		if !ok || err != nil {
+			return ok, err
+		}
+		if i < 0 || rv.OverflowUint(uint64(i)) {
+			return true, fmt.Errorf("number %v out of range for %v", lv, rv.Type())
+		}
+		rv.SetUint(uint64(i))
+		return true, nil
+	case reflect.Float32, reflect.Float64:
+		if f, ok := numberAsFloat(lv); ok {
+			rv.SetFloat(float64(f))
+			return true, nil
+		}
+	case reflect.String:
+		switch lv.(type) {
+		case LString, LNumber, LInteger:
+			rv.SetString(lv.String())
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// luaToGo converts a Lua value to a Go value of the type t.
+func (ls *LState) luaToGo(lv LValue, t reflect.Type) (reflect.Value, error) {
+	if t.Kind() == reflect.Interface && t.NumMethod() == 0 {
+		if gv := goValueOf(lv); gv != nil {
+			return reflect.ValueOf(gv), nil
+		}
+		return reflect.Zero(t), nil
+	}
+	if reflect.TypeOf(lv).AssignableTo(t) {
+		return reflect.ValueOf(lv), nil
+	}
+	if ud, ok := lv.(*LUserData); ok && ud.Value != nil {
+		rv := reflect.ValueOf(ud.Value)
+		if rv.Type().AssignableTo(t) {
+			return rv, nil
+		}
+		if rv.Kind() == reflect.Ptr && !rv.IsNil() && rv.Type().Elem().AssignableTo(t) {
+			return rv.Elem(), nil
+		}
+	}
+	if lv == LNil {
+		switch t.Kind() {
+		case reflect.Ptr, reflect.Interface, reflect.Slice, reflect.Map, reflect.Func, reflect.Chan:
+			return reflect.Zero(t), nil
+		}
+	}
+	rv := reflect.New(t).Elem()
+	if ok, err := luaToScalar(lv, rv); ok || err != nil {
+		return rv, err
+	}
+	switch t.Kind() {
+	case reflect.Slice:
+		if s, ok := lv.(LString); ok && t.Elem().Kind() == reflect.Uint8 {
+			rv.SetBytes([]byte(s))
+			return rv, nil
+		}
+		if tb, ok := lv.(*LTable); ok {
+			n := tb.Len()
+			rv.Set(reflect.MakeSlice(t, n, n))
+			return rv, ls.luaToGoElements(tb, rv, n)
+		}
+	case reflect.Array:
+		if tb, ok := lv.(*LTable); ok {
+			return rv, ls.luaToGoElements(tb, rv, intMin(tb.Len(), rv.Len()))
+		}
+	case reflect.Map:
+		if tb, ok := lv.(*LTable); ok {
+			rv.Set(reflect.MakeMap(t))
+			var err error
+			tb.ForEach(func(key, value LValue) {
+				if err != nil {
+					return
+				}
+				k, kerr := ls.luaToGo(key, t.Key())
+				if kerr != nil {
+					err = fmt.Errorf("key %v: %v", key, kerr)
+					return
+				}
+				v, verr := ls.luaToGo(value, t.Elem())
+				if verr != nil {
+					err = fmt.Errorf("value of %v: %v", key, verr)
+					return
+				}
+				rv.SetMapIndex(k, v)
+			})
+			return rv, err
+		}
+	case reflect.Func:
+		if fn, ok := lv.(*LFunction); ok {
+			return ls.luaFunctionToGo(fn, t), nil
+		}
+	}
+	return rv, fmt.Errorf("%v expected, got %v", t, luaTypeName(lv))
+}
+
+func (ls *LState) luaToGoElements(tb *LTable, rv reflect.Value, n int) error {
+	et := rv.Type().Elem()
+	for i := 0; i < n; i++ {
+		v, err := ls.luaToGo(tb.RawGetInt(i+1), et)
+		if err != nil {
+			return fmt.Errorf("element %v: %v", i+1, err)
+		}
+		rv.Index(i).Set(v)
+	}
+	return nil
+}
+
+// luaFunctionToGo returns a Go function of the type t that calls the Lua function fn in this LState.
+func (ls *LState) luaFunctionToGo(fn *LFunction, t reflect.Type) reflect.Value {
+	return reflect.MakeFunc(t, func(args []reflect.Value) []reflect.Value {
+		ls.Push(fn)
+		for _, arg := range args {
+			ls.Push(ls.ToLValue(arg.Interface()))
+		}
+		nout := t.NumOut()
+		ls.Call(len(args), nout)
+		results := make([]reflect.Value, nout)
+		for i := 0; i < nout; i++ {
+			lv := ls.Get(i - nout)
+			v, err := ls.luaToGo(lv, t.Out(i))
+			if err != nil {
+				ls.RaiseError("bad result #%v (%v)", i+1, err.Error())
+			}
+			results[i] = v
+		}
+		ls.Pop(nout)
+		return results
+	})
+}
+
+// checkGoValue converts the n-th argument to a Go value of the type t. An error is raised by ArgError if the
+// argument can not be converted.
+func (ls *LState) checkGoValue(n int, t reflect.Type) reflect.Value {
+	v, err := ls.luaToGo(ls.Get(n), t)
+	if err != nil {
+		ls.ArgError(n, err.Error())
+	}
+	return v
+}
+
+// callGoFunction calls the Go function fn with the arguments from the first-th argument and pushes the results.
+// *LState and context.Context parameters before the other parameters receive this LState and its context.
+// If the last result of fn is a non-nil error, it is raised, or nil and the error message are returned if
+// returnErrors is true.
+func (ls *LState) callGoFunction(fn reflect.Value, first int, returnErrors bool) int {
+	ft := fn.Type()
+	nin := ft.NumIn()
+	top := ls.GetTop()
+	args := make([]reflect.Value, 0, nin)
+	n := first
+	for i := 0; i < nin; i++ {
+		pt := ft.In(i)
+		switch {
+		case n == first && pt == lstateType:
+			args = append(args, reflect.ValueOf(ls))
+		case n == first && pt == contextType:
+			ctx := ls.Context()
+			if ctx == nil {
+				ctx = context.Background()
+			}
+			args = append(args, reflect.ValueOf(&ctx).Elem())
+		case i == nin-1 && ft.IsVariadic():
+			for ; n <= top; n++ {
+				args = append(args, ls.checkGoValue(n, pt.Elem()))
+			}
+		default:
+			args = append(args, ls.checkGoValue(n, pt))
+			n++
+		}
+	}
+	results := fn.Call(args)
+	if nout := len(results); nout > 0 && ft.Out(nout-1) == errorType {
+		if err := results[nout-1]; !err.IsNil() {
+			if returnErrors {
+				ls.Push(LNil)
+				ls.Push(LString(err.Interface().(error).Error()))
+				return 2
+			}
+			ls.RaiseError("%s", err.Interface().(error).Error())
+		}
+		results = results[:nout-1]
+	}
+	for _, result := range results {
+		ls.Push(ls.ToLValue(result.Interface()))
+	}
+	return len(results)
+}
+
+/* }}} */
+
+/* metatables {{{ */
+
+// bridgeType holds the members of a Go type that are exposed to Lua.
+type bridgeType struct {
+	typ        reflect.Type
+	fieldNames []string
+	fields     map[string][]int
+	methods    map[string]*LFunction
+}
+
+func (ls *LState) bridgeMetatable(t reflect.Type) *LTable {
+	if mt, ok := ls.G.bridgeMts[t]; ok {
+		return mt
+	}
+	if ls.G.bridgeMts == nil {
+		ls.G.bridgeMts = make(map[reflect.Type]*LTable)
+	}
+	bt := &bridgeType{typ: t, fields: map[string][]int{}, methods: map[string]*LFunction{}}
+	if st := t; st.Kind() == reflect.Struct || st.Kind() == reflect.Ptr && st.Elem().Kind() == reflect.Struct {
+		if st.Kind() == reflect.Ptr {
+			st = st.Elem()
+		}
+		for _, field := range reflect.VisibleFields(st) {
+			if _, dup := bt.fields[field.Name]; !field.IsExported() || dup {
+				continue
+			}
+			bt.fieldNames = append(bt.fieldNames, field.Name)
+			bt.fields[field.Name] = field.Index
+		}
+	}
+	for i := 0; i < t.NumMethod(); i++ {
+		method := t.Method(i)
+		if !method.IsExported() {
+			continue
+		}
+		bt.methods[method.Name] = ls.NewFunction(func(L *LState) int {
+			recv := L.CheckUserData(1)
+			rv := reflect.ValueOf(recv.Value)
+			if !rv.IsValid() || rv.Type() != t {
+				L.ArgError(1, fmt.Sprintf("%v expected, got %v", t, luaTypeName(recv)))
+			}
+			return L.callGoFunction(rv.Method(method.Index), 2, false)
+		})
+	}
+
+	mt := ls.NewTable()
+	mt.RawSetString("__index", ls.NewFunction(bt.index))
+	mt.RawSetString("__newindex", ls.NewFunction(bt.newIndex))
+	mt.RawSetString("__len", ls.NewFunction(bt.length))
+	mt.RawSetString("__pairs", ls.NewFunction(bt.pairs))
+	mt.RawSetString("__tostring", ls.NewFunction(bridgeToString))
+	mt.RawSetString("__eq", ls.NewFunction(userDataValueEq))
+	if t.Kind() == reflect.Func {
+		mt.RawSetString("__call", ls.NewFunction(func(L *LState) int {
+			return L.callGoFunction(reflect.ValueOf(L.CheckUserData(1).Value), 2, false)
+		}))
+	}
+	ls.G.bridgeMts[t] = mt
+	return mt
+}
+
+// bridgeIndex converts a Lua key to a 0-based index of a slice or an array.
+func bridgeIndex(key LValue, length int) (int, bool) {
+	var i int
+	switch k := key.(type) {
+	case LNumber:
+		i = int(k)
+		if LNumber(i) != k {
+			return 0, false
+		}
+	case LInteger:
+		i = int(k)
+	default:
+		return 0, false
+	}
+	return i - 1, i >= 1 && i <= length
+}
+
+// value returns the Go value of the userdata, and the struct that the value refers to if any.
+func (bt *bridgeType) value(L *LState) (reflect.Value, reflect.Value) {
+	rv := reflect.ValueOf(L.CheckUserData(1).Value)
+	sv := rv
+	if sv.Kind() == reflect.Ptr && !sv.IsNil() {
+		sv = sv.Elem()
+	}
+	if sv.Kind() != reflect.Struct {
+		sv = reflect.Value{}
+	}
+	return rv, sv
+}
+
+// bridgeElement converts a field or an element to a Lua value. Addressable structs and arrays are converted as
+// pointers, so that they can be modified from Lua.
+func bridgeElement(L *LState, v reflect.Value) LValue {
+	if (v.Kind() == reflect.Struct || v.Kind() == reflect.Array) && v.CanAddr() {
+		v = v.Addr()
+	}
+	return L.ToLValue(v.Interface())
+}
+
+func (bt *bridgeType) index(L *LState) int {
+	rv, sv := bt.value(L)
+	key := L.Get(2)
+	if name, ok := key.(LString); ok {
+		if fn, ok := bt.methods[string(name)]; ok {
+			L.Push(fn)
+			return 1
+		}
+		if index, ok := bt.fields[string(name)]; ok && sv.IsValid() {
+			if field, err := sv.FieldByIndexErr(index); err == nil {
+				L.Push(bridgeElement(L, field))
+				return 1
+			}
+		}
+	}
+	if rv.Kind() == reflect.Ptr && !rv.IsNil() && (rv.Elem().Kind() == reflect.Array || rv.Elem().Kind() == reflect.Slice) {
+		rv = rv.Elem()
+	}
+	switch rv.Kind() {
+	case reflect.Map:
+		if k, err := L.luaToGo(key, rv.Type().Key()); err == nil {
+			if v := rv.MapIndex(k); v.IsValid() {
+				L.Push(L.ToLValue(v.Interface()))
+				return 1
+			}
+		}
+	case reflect.Slice, reflect.Array, reflect.String:
+		if i, ok := bridgeIndex(key, rv.Len()); ok {
+			L.Push(bridgeElement(L, rv.Index(i)))
+			return 1
+		}
+	}
+	L.Push(LNil)
+	return 1
+}
+
+func (bt *bridgeType) newIndex(L *LState) int {
+	rv, sv := bt.value(L)
+	key := L.Get(2)
+	value := L.Get(3)
+	if name, ok := key.(LString); ok {
+		if index, ok := bt.fields[string(name)]; ok && sv.IsValid() {
+			field, err := sv.FieldByIndexErr(index)
+			if err != nil || !field.CanSet() {
+				L.RaiseError("cannot set field '%s' of %v", name, bt.typ)
+			}
+			v, err := L.luaToGo(value, field.Type())
+			if err != nil {
+				L.RaiseError("cannot set field '%s' of %v (%v)", name, bt.typ, err.Error())
+			}
+			field.Set(v)
+			return 0
+		}
+	}
+	if rv.Kind() == reflect.Ptr && !rv.IsNil() && (rv.Elem().Kind() == reflect.Array || rv.Elem().Kind() == reflect.Slice) {
+		rv = rv.Elem()
+	}
+	switch rv.Kind() {
+	case reflect.Map:
+		k, err := L.luaToGo(key, rv.Type().Key())
+		if err != nil {
+			L.RaiseError("invalid key for %v (%v)", bt.typ, err.Error())
+		}
+		if value == LNil {
+			rv.SetMapIndex(k, reflect.Value{})
+			return 0
+		}
+		v, err := L.luaToGo(value, rv.Type().Elem())
+		if err != nil {
+			L.RaiseError("invalid value for %v (%v)", bt.typ, err.Error())
+		}
+		rv.SetMapIndex(k, v)
+		return 0
+	case reflect.Slice, reflect.Array:
+		i, ok := bridgeIndex(key, rv.Len())
+		if !ok {
+			L.RaiseError("index %v out of range for %v", key, bt.typ)
+		}
+		elem := rv.Index(i)
+		if !elem.CanSet() {
+			L.RaiseError("cannot set an element of %v", bt.typ)
+		}
+		v, err := L.luaToGo(value, elem.Type())
+		if err != nil {
+			L.RaiseError("invalid value for %v (%v)", bt.typ, err.Error())
+		}
+		elem.Set(v)
+		return 0
+	}
+	L.RaiseError("cannot set '%v' of %v", key, bt.typ)
+	return 0
+}
+
+func (bt *bridgeType) length(L *LState) int {
+	rv, _ := bt.value(L)
+	if rv.Kind() == reflect.Ptr && !rv.IsNil() && rv.Elem().Kind() == reflect.Array {
+		rv = rv.Elem()
+	}
+	switch rv.Kind() {
+	case reflect.Map, reflect.Slice, reflect.Array, reflect.String, reflect.Chan:
+		L.Push(LNumber(rv.Len()))
+		return 1
+	}
+	L.RaiseError("attempt to get length of %v", bt.typ)
+	return 0
+}
+
+func (bt *bridgeType) pairs(L *LState) int {
+	rv, sv := bt.value(L)
+	if rv.Kind() == reflect.Ptr && !rv.IsNil() && (rv.Elem().Kind() == reflect.Array || rv.Elem().Kind() == reflect.Slice) {
+		rv = rv.Elem()
+	}
+	var next func() (LValue, LValue, bool)
+	switch {
+	case sv.IsValid():
+		i := 0
+		next = func() (LValue, LValue, bool) {
+			for ; i < len(bt.fieldNames); i++ {
+				field, err := sv.FieldByIndexErr(bt.fields[bt.fieldNames[i]])
+				if err != nil {
+					continue
+				}
+				i++
+				return LString(bt.fieldNames[i-1]), bridgeElement(L, field), true
+			}
+			return LNil, LNil, false
+		}
+	case rv.Kind() == reflect.Map:
+		keys := rv.MapKeys()
+		sort.Slice(keys, func(i, j int) bool { return fmt.Sprint(keys[i]) < fmt.Sprint(keys[j]) })
+		i := 0
+		next = func() (LValue, LValue, bool) {
+			for ; i < len(keys); i++ {
+				if v := rv.MapIndex(keys[i]); v.IsValid() {
+					i++
+					return L.ToLValue(keys[i-1].Interface()), L.ToLValue(v.Interface()), true
+				}
+			}
+			return LNil, LNil, false
+		}
+	case rv.Kind() == reflect.Slice || rv.Kind() == reflect.Array:
+		i := 0
+		next = func() (LValue, LValue, bool) {
+			if i >= rv.Len() {
+				return LNil, LNil, false
+			}
+			i++
+			return LNumber(i), bridgeElement(L, rv.Index(i-1)), true
+		}
+	default:
+		L.RaiseError("cannot iterate over %v", bt.typ)
+	}
+	L.Push(L.NewFunction(func(L *LState) int {
+		key, value, ok := next()
+		if !ok {
+			L.Push(LNil)
+			return 1
+		}
+		L.Push(key)
+		L.Push(value)
+		return 2
+	}))
+	L.Push(L.Get(1))
+	L.Push(LNil)
+	return 3
+}
+
+func bridgeToString(L *LState) int {
+	L.Push(LString(fmt.Sprint(L.CheckUserData(1).Value)))
+	return 1
+}
+
+// userDataValueEq is an __eq metamethod that compares the Values of userdata.
+func userDataValueEq(L *LState) int {
+	lhs := reflect.ValueOf(L.CheckUserData(1).Value)
+	rhs := reflect.ValueOf(L.CheckUserData(2).Value)
+	L.Push(LBool(lhs.IsValid() && rhs.IsValid() && lhs.Type() == rhs.Type() && lhs.Comparable() && lhs.Equal(rhs)))
+	return 1
+}
+
+/* }}} */
@@ -0,0 +1,144 @@
+package lua
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+type bridgeTestPoint struct {
+	X, Y int
+}
+
+type bridgeTestShape struct {
+	bridgeTestPoint
+	Name   string
+	Tags   []string
+	Attrs  map[string]int
+	Center bridgeTestPoint
+	hidden int
+}
+
+func (s *bridgeTestShape) Move(dx, dy int) {
+	s.X += dx
+	s.Y += dy
+}
+
+func (s *bridgeTestShape) Describe(prefix string, extra ...int) string {
+	return fmt.Sprintf("%s%s(%d,%d)%v", prefix, s.Name, s.X, s.Y, extra)
+}
+
+func (s *bridgeTestShape) Scale(f float64) (float64, error) {
+	if f <= 0 {
+		return 0, errors.New("scale must be positive")
+	}
+	return float64(s.X) * f, nil
+}
+
+func (s bridgeTestShape) String() string {
+	return "shape " + s.Name
+}
+
+func TestBridgeStruct(t *testing.T) {
+	L := NewState()
+	defer L.Close()
+	shape := &bridgeTestShape{
+		bridgeTestPoint: bridgeTestPoint{1, 2},
+		Name:            "box",
+		Tags:            []string{"a", "b"},
+		Attrs:           map[string]int{"w": 3},
+	}
+	L.SetGlobal("shape", L.ToLValue(shape))
+	errorIfScriptFail(t, L, `
+	  assert(shape.Name == "box" and shape.X == 1 and shape.Y == 2)
+	  assert(shape.hidden == nil and shape.Unknown == nil)
+	  shape.Name = "square"
+	  shape:Move(10, 20)
+	  assert(shape.X == 11 and shape.Y == 22)
+	  assert(shape:Describe("> ") == "> square(11,22)[]")
+	  assert(shape:Describe("> ", 1, 2) == "> square(11,22)[1 2]")
+	  assert(shape:Scale(2) == 22)
+	  assert(tostring(shape) == "shape square")
+
+	  shape.Center.X = 5
+	  assert(shape.Center.X == 5)
+	  assert(#shape.Tags == 2 and shape.Tags[2] == "b" and shape.Tags[3] == nil)
+	  shape.Tags[1] = "z"
+	  assert(shape.Attrs.w == 3 and #shape.Attrs == 1)
+	  shape.Attrs.h = 4
+	  shape.Attrs.w = nil
+
+	  local names = {}
+	  for k, v in pairs(shape) do names[#names + 1] = k end
+	  assert(table.concat(names, ",") == "X,Y,Name,Tags,Attrs,Center")
+	  local tags = {}
+	  for i, v in pairs(shape.Tags) do tags[i] = v end
+	  assert(table.concat(tags, ",") == "z,b")
+	`)
+	errorIfNotEqual(t, "square", shape.Name)
+	errorIfNotEqual(t, 5, shape.Center.X)
+	errorIfNotEqual(t, "z", shape.Tags[0])
+	errorIfFalse(t, len(shape.Attrs) == 1 && shape.Attrs["h"] == 4, "%v", shape.Attrs)
+	errorIfNotEqual(t, shape, L.GetGlobal("shape").(*LUserData).Value)
+
+	errorIfScriptNotFail(t, L, `shape:Move("a", 1)`, `bad argument #2 to Move \(int expected, got string\)`)
+	errorIfScriptNotFail(t, L, `shape:Scale(0)`, "scale must be positive")
+	errorIfScriptNotFail(t, L, `shape.X = "a"`, `cannot set field 'X' of \*lua.bridgeTestShape \(int expected, got string\)`)
+	errorIfScriptNotFail(t, L, `shape.Move(1, 2)`, `bad argument #1 to Move \(userdata expected, got number\)`)
+}
+
+func TestBridgeValues(t *testing.T) {
+	L := NewState()
+	defer L.Close()
+	errorIfNotEqual(t, LNil, L.ToLValue(nil))
+	errorIfNotEqual(t, LNil, L.ToLValue((*bridgeTestShape)(nil)))
+	errorIfNotEqual(t, LNumber(3), L.ToLValue(uint8(3)))
+	errorIfNotEqual(t, LString("abc"), L.ToLValue([]byte("abc")))
+	errorIfNotEqual(t, LTrue, L.ToLValue(true))
+
+	var received []int
+	L.SetGlobal("sum", L.ToLValue(func(values []int, opts map[string]bool) (int, error) {
+		received = values
+		total := 0
+		for _, v := range values {
+			total += v
+		}
+		if opts["negate"] {
+			total = -total
+		}
+		return total, nil
+	}))
+	L.SetGlobal("apply", L.ToLValue(func(L *LState, fn func(int) string, v int) string {
+		return fn(v) + "!"
+	}))
+	L.SetGlobal("point", L.Bridge(bridgeTestPoint{1, 2}))
+	L.SetGlobal("grid", L.ToLValue(&[2][2]int{{1, 2}, {3, 4}}))
+	errorIfScriptFail(t, L, `
+	  assert(sum({1, 2, 3}) == 6 and sum({1, 2}, {negate = true}) == -3)
+	  assert(apply(function(v) return tostring(v * 2) end, 21) == "42!")
+	  assert(point.X == 1 and point == point)
+	  assert(grid[2][1] == 3)
+	  grid[2][1] = 30
+	`)
+	errorIfFalse(t, len(received) == 2 && received[1] == 2, "%v", received)
+	errorIfNotEqual(t, 30, L.GetGlobal("grid").(*LUserData).Value.(*[2][2]int)[1][0])
+	errorIfScriptNotFail(t, L, `sum({1, "a"})`, `bad argument #1 to sum \(element 2: int expected, got string\)`)
+	errorIfScriptNotFail(t, L, `point.X = 2`, "cannot set field 'X' of lua.bridgeTestPoint")
+	errorIfScriptNotFail(t, L, `grid[3] = 1`, "index 3 out of range")
+
+	err := L.DoString(`sum(1)`)
+	errorIfFalse(t, err != nil && strings.Contains(err.Error(), "[]int expected, got number"), "%v", err)
+}
+
+func TestBridgeIntegers(t *testing.T) {
+	L := NewState()
+	defer L.Close()
+	L.SetGlobal("ints", L.ToLValue(func(a int, b int64, c uint8) int64 { return int64(a) + b + int64(c) }))
+	errorIfScriptFail(t, L, `assert(ints(1, 2.0, 3) == 6)`)
+	errorIfScriptNotFail(t, L, `ints(3.7, 1, 2)`, `bad argument #1 to ints \(number 3.7 has no integer representation\)`)
+	errorIfScriptNotFail(t, L, `ints(1, 1e20, 2)`, `bad argument #2 to ints \(number 1e\+20 has no integer representation\)`)
+	errorIfScriptNotFail(t, L, `ints(1, 0/0, 2)`, `bad argument #2 to ints \(number .* has no integer representation\)`)
+	errorIfScriptNotFail(t, L, `ints(1, 2, 2.9)`, `bad argument #3 to ints \(number 2.9 has no integer representation\)`)
+	errorIfScriptNotFail(t, L, `ints(1, 2, 256)`, `bad argument #3 to ints \(number 256 out of range for uint8\)`)
+}
@@ -0,0 +1,244 @@
+package lua
+
+import (
+	"fmt"
+)
+
+// ClassDef is implemented by Class. It is used to declare the parent of a class.
+type ClassDef interface {
+	// Name returns the name of the class.
+	Name() string
+	// Metatable returns the metatable of the class in the LState. The metatable is created on first use.
+	Metatable(L *LState) *LTable
+	members() *classMembers
+}
+
+// classMembers holds the members of a class, with functions that check the receiver for the class.
+type classMembers struct {
+	parent      ClassDef
+	methods     map[string]LGFunction
+	getters     map[string]LGFunction
+	setters     map[string]LGFunction
+	metamethods map[string]LGFunction
+}
+
+// Class is a builder of a userdata type whose values are Ts. Methods, properties and metamethods are inherited
+// from the parent class, and the checks of the parent accept instances of its subclasses. Since the values of
+// subclasses have other Go types, a parent class should be declared with an interface type that the values of
+// its subclasses implement.
+//
+//	var shapeClass = lua.NewClass[Shape]("Shape").
+//		Method("area", func(L *lua.LState, s Shape) int {
+//			L.Push(lua.LNumber(s.Area()))
+//			return 1
+//		})
+//	var circleClass = lua.NewClass[*Circle]("Circle").Extends(shapeClass).
+//		Property("radius", func(L *lua.LState, c *Circle) lua.LValue {
+//			return lua.LNumber(c.Radius)
+//		}, nil)
+//
+//	L.SetGlobal("c", circleClass.New(L, &Circle{Radius: 2}))
+//
+// Instances have the default __tostring("Circle: 0x...") and __eq(the Values are equal) metamethods unless
+// they are declared.
+type Class[T any] struct {
+	name string
+	classMembers
+}
+
+// NewClass returns a new class named name. The name is also the key of the metatable in the registry, so
+// it can be retrieved by LState.GetTypeMetatable.
+func NewClass[T any](name string) *Class[T] {
+	return &Class[T]{
+		name: name,
+		classMembers: classMembers{
+			methods:     map[string]LGFunction{},
+			getters:     map[string]LGFunction{},
+			setters:     map[string]LGFunction{},
+			metamethods: map[string]LGFunction{},
+		},
+	}
+}
+
+func (c *Class[T]) Name() string {
+	return c.name
+}
+
+func (c *Class[T]) members() *classMembers {
+	return &c.classMembers
+}
+
+// Extends sets the parent class.
+func (c *Class[T]) Extends(parent ClassDef) *Class[T] {
+	c.parent = parent
+	return c
+}
+
+// Method declares a method. fn is called with the checked receiver, and the other arguments start at 2.
+func (c *Class[T]) Method(name string, fn func(L *LState, self T) int) *Class[T] {
+	c.methods[name] = func(L *LState) int {
+		return fn(L, c.Check(L, 1))
+	}
+	return c
+}
+
+// Property declares a property that is read by get and assigned by set. A property without a setter is
+// read-only.
+func (c *Class[T]) Property(name string, get func(L *LState, self T) LValue, set func(L *LState, self T, value LValue)) *Class[T] {
+	c.getters[name] = func(L *LState) int {
+		L.Push(get(L, c.Check(L, 1)))
+		return 1
+	}
+	delete(c.setters, name)
+	if set != nil {
+		c.setters[name] = func(L *LState) int {
+			set(L, c.Check(L, 1), L.Get(3))
+			return 0
+		}
+	}
+	return c
+}
+
+// Metamethod declares a metamethod such as __add or __call. __index and __newindex are called for keys that are
+// not methods or properties.
+func (c *Class[T]) Metamethod(event string, fn LGFunction) *Class[T] {
+	c.metamethods[event] = fn
+	return c
+}
+
+// New returns a new instance of the class whose Value is v.
+func (c *Class[T]) New(L *LState, v T) *LUserData {
+	ud := L.NewUserData()
+	ud.Value = v
+	ud.Metatable = c.Metatable(L)
+	return ud
+}
+
+// Check checks whether the given index is an instance of the class or its subclasses and returns its Value.
+// Otherwise, raises an error like "bad argument #1 to f (Foo expected, got Bar)".
+func (c *Class[T]) Check(L *LState, n int) T {
+	lv := L.Get(n)
+	if ud, ok := lv.(*LUserData); ok && classIsInstance(ud, c.Metatable(L)) {
+		if v, ok := ud.Value.(T); ok {
+			return v
+		}
+	}
+	L.ArgError(n, fmt.Sprintf("%s expected, got %s", c.name, classNameOf(lv)))
+	var zero T
+	return zero
+}
+
+// Metatable returns the metatable of the class in the LState. The metatable is created on first use.
+func (c *Class[T]) Metatable(L *LState) *LTable {
+	if mt, ok := L.GetTypeMetatable(c.name).(*LTable); ok && mt.RawGetString("__name") == LString(c.name) {
+		return mt
+	}
+	var parentMt LValue = LNil
+	if c.parent != nil {
+		parentMt = c.parent.Metatable(L)
+	}
+	mt := L.NewTypeMetatable(c.name)
+	mt.RawSetString("__name", LString(c.name))
+	mt.RawSetString("__parent", parentMt)
+
+	// members of subclasses override the members of their parents
+	members := &classMembers{
+		methods:     map[string]LGFunction{},
+		getters:     map[string]LGFunction{},
+		setters:     map[string]LGFunction{},
+		metamethods: map[string]LGFunction{"__tostring": classToString, "__eq": userDataValueEq},
+	}
+	var chain []*classMembers
+	for def := ClassDef(c); def != nil; def = def.members().parent {
+		chain = append(chain, def.members())
+	}
+	for i := len(chain) - 1; i >= 0; i-- {
+		for _, m := range []struct{ dst, src map[string]LGFunction }{
+			{members.methods, chain[i].methods},
+			{members.getters, chain[i].getters},
+			{members.setters, chain[i].setters},
+			{members.metamethods, chain[i].metamethods},
+		} {
+			for name, fn := range m.src {
+				m.dst[name] = fn
+			}
+		}
+		for name := range chain[i].getters {
+			if _, ok := chain[i].setters[name]; !ok {
+				delete(members.setters, name)
+			}
+		}
+	}
+
+	methods := L.SetFuncs(L.NewTable(), members.methods)
+	index, newIndex := members.metamethods["__index"], members.metamethods["__newindex"]
+	delete(members.metamethods, "__index")
+	delete(members.metamethods, "__newindex")
+	L.SetFuncs(mt, members.metamethods)
+	mt.RawSetString("__index", L.NewFunction(func(L *LState) int {
+		if name, ok := L.Get(2).(LString); ok {
+			if get, ok := members.getters[string(name)]; ok {
+				return get(L)
+			}
+			if fn := methods.RawGetString(string(name)); fn != LNil {
+				L.Push(fn)
+				return 1
+			}
+		}
+		if index != nil {
+			return index(L)
+		}
+		L.Push(LNil)
+		return 1
+	}))
+	mt.RawSetString("__newindex", L.NewFunction(func(L *LState) int {
+		if name, ok := L.Get(2).(LString); ok {
+			if set, ok := members.setters[string(name)]; ok {
+				return set(L)
+			}
+			if _, ok := members.getters[string(name)]; ok {
+				L.RaiseError("property '%s' of %s is read-only", name, classNameOf(L.Get(1)))
+			}
+		}
+		if newIndex != nil {
+			return newIndex(L)
+		}
+		L.RaiseError("cannot set field '%s' of %s", L.Get(2).String(), classNameOf(L.Get(1)))
+		return 0
+	}))
+	return mt
+}
+
+// classIsInstance returns true if the userdata is an instance of the class whose metatable is mt or its
+// subclasses.
+func classIsInstance(ud *LUserData, mt *LTable) bool {
+	for cur := ud.Metatable; cur != LNil; {
+		tb, ok := cur.(*LTable)
+		if !ok {
+			return false
+		}
+		if tb == mt {
+			return true
+		}
+		cur = tb.RawGetString("__parent")
+	}
+	return false
+}
+
+// classNameOf returns the class name of an instance, or the type name of other values.
+func classNameOf(lv LValue) string {
+	if ud, ok := lv.(*LUserData); ok {
+		if mt, ok := ud.Metatable.(*LTable); ok {
+			if name, ok := mt.RawGetString("__name").(LString); ok {
+				return string(name)
+			}
+		}
+	}
+	return lv.Type().String()
+}
+
+func classToString(L *LState) int {
+	ud := L.CheckUserData(1)
+	L.Push(LString(fmt.Sprintf("%s: %p", classNameOf(ud), ud)))
+	return 1
+}
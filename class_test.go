@@ -0,0 +1,108 @@
+package lua
+
+import (
+	"math"
+	"testing"
+)
+
+type classTestShape interface {
+	Area() float64
+}
+
+type classTestCircle struct {
+	Radius float64
+}
+
+func (c *classTestCircle) Area() float64 { return math.Pi * c.Radius * c.Radius }
+
+type classTestRect struct {
+	W, H float64
+}
+
+func (r *classTestRect) Area() float64 { return r.W * r.H }
+
+func newClassTestClasses() (*Class[classTestShape], *Class[*classTestCircle], *Class[*classTestRect]) {
+	shape := NewClass[classTestShape]("Shape").
+		Method("area", func(L *LState, s classTestShape) int {
+			L.Push(LNumber(s.Area()))
+			return 1
+		}).
+		Method("kind", func(L *LState, s classTestShape) int {
+			L.Push(LString("shape"))
+			return 1
+		}).
+		Property("size", func(L *LState, s classTestShape) LValue {
+			return LNumber(s.Area())
+		}, nil)
+	circle := NewClass[*classTestCircle]("Circle").Extends(shape).
+		Method("kind", func(L *LState, c *classTestCircle) int {
+			L.Push(LString("circle"))
+			return 1
+		}).
+		Property("radius", func(L *LState, c *classTestCircle) LValue {
+			return LNumber(c.Radius)
+		}, func(L *LState, c *classTestCircle, v LValue) {
+			c.Radius = float64(L.CheckNumber(3))
+		})
+	rect := NewClass[*classTestRect]("Rect").Extends(shape).
+		Metamethod("__tostring", func(L *LState) int {
+			L.Push(LString("rect"))
+			return 1
+		}).
+		Metamethod("__index", func(L *LState) int {
+			L.Push(LString("dynamic " + L.CheckString(2)))
+			return 1
+		})
+	return shape, circle, rect
+}
+
+func TestClass(t *testing.T) {
+	L := NewState()
+	defer L.Close()
+	_, circle, rect := newClassTestClasses()
+	c := &classTestCircle{Radius: 1}
+	L.SetGlobal("c", circle.New(L, c))
+	L.SetGlobal("c2", circle.New(L, c))
+	L.SetGlobal("r", rect.New(L, &classTestRect{W: 2, H: 3}))
+	errorIfScriptFail(t, L, `
+	  assert(math.abs(c:area() - math.pi) < 1e-9 and c.size == c:area())
+	  assert(c:kind() == "circle" and r:kind() == "shape")
+	  c.radius = 2
+	  assert(c.radius == 2 and r:area() == 6)
+	  assert(string.find(tostring(c), "^Circle: 0x") and tostring(r) == "rect")
+	  assert(c == c2 and c ~= r)
+	  assert(c.unknown == nil and r.unknown == "dynamic unknown")
+	`)
+	errorIfNotEqual(t, 2.0, c.Radius)
+	errorIfNotEqual(t, L.GetTypeMetatable("Circle"), L.GetMetatable(L.GetGlobal("c")))
+
+	errorIfScriptNotFail(t, L, `c.size = 1`, "property 'size' of Circle is read-only")
+	errorIfScriptNotFail(t, L, `c.color = "red"`, "cannot set field 'color' of Circle")
+	errorIfScriptNotFail(t, L, `c.kind(r)`, `bad argument #1 to kind \(Circle expected, got Rect\)`)
+	errorIfScriptNotFail(t, L, `r.area(1)`, `bad argument #1 to area \(Shape expected, got number\)`)
+	errorIfScriptNotFail(t, L, `c.radius = "a"`, "number expected, got string")
+}
+
+func TestClassCheck(t *testing.T) {
+	L := NewState()
+	defer L.Close()
+	shape, circle, rect := newClassTestClasses()
+	L.SetGlobal("describe", L.NewFunction(func(L *LState) int {
+		s := shape.Check(L, 1)
+		r := rect.Check(L, 2)
+		L.Push(LNumber(s.Area() + r.Area()))
+		return 1
+	}))
+	L.SetGlobal("c", circle.New(L, &classTestCircle{Radius: 1}))
+	L.SetGlobal("r", rect.New(L, &classTestRect{W: 2, H: 3}))
+	L.SetGlobal("other", L.NewUserData())
+	errorIfScriptFail(t, L, `assert(describe(r, r) == 12)`)
+	errorIfScriptNotFail(t, L, `describe(c, c)`, `bad argument #2 to describe \(Rect expected, got Circle\)`)
+	errorIfScriptNotFail(t, L, `describe(other, r)`, `bad argument #1 to describe \(Shape expected, got userdata\)`)
+
+	// instances of the same classes in other states are not mixed up
+	L2 := NewState()
+	defer L2.Close()
+	L2.SetGlobal("c", circle.New(L2, &classTestCircle{Radius: 3}))
+	errorIfScriptFail(t, L2, `assert(c.radius == 3 and c:kind() == "circle")`)
+}
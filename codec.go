@@ -0,0 +1,505 @@
+package lua
+
+import (
+	"encoding"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+// maxCodecDepth is the maximum nesting depth of values that are converted by Decode and Encode. It stops
+// the conversion of cyclic values.
+const maxCodecDepth = 100
+
+var (
+	durationType        = reflect.TypeOf(time.Duration(0))
+	timeType            = reflect.TypeOf(time.Time{})
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+	textMarshalerType   = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+	identifierPattern   = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+)
+
+// ValueError is the error returned by Decode and Encode. Path locates the value that can not be converted,
+// e.g. servers[2].port.
+type ValueError struct {
+	Path    string
+	Message string
+}
+
+func (e *ValueError) Error() string {
+	if len(e.Path) == 0 {
+		return e.Message
+	}
+	return e.Path + ": " + e.Message
+}
+
+func newValueError(path string, format string, args ...interface{}) *ValueError {
+	return &ValueError{Path: path, Message: fmt.Sprintf(format, args...)}
+}
+
+func codecFieldPath(path, name string) string {
+	if len(path) == 0 {
+		return name
+	}
+	return path + "." + name
+}
+
+func codecKeyPath(path string, key LValue) string {
+	if s, ok := key.(LString); ok {
+		if identifierPattern.MatchString(string(s)) {
+			return codecFieldPath(path, string(s))
+		}
+		return fmt.Sprintf("%s[%q]", path, string(s))
+	}
+	return fmt.Sprintf("%s[%v]", path, key)
+}
+
+// codecField is a struct field that is converted by Decode and Encode.
+type codecField struct {
+	name      string
+	index     []int
+	omitEmpty bool
+}
+
+// codecFields returns the fields of a struct type. The name of a field is the name in the `lua` tag or the
+// name of the field, and fields tagged with "-" are skipped. Fields of embedded structs without a name are
+// promoted like encoding/json does: a field hides the fields with the same name in deeper embedded structs,
+// and of the fields with the same name at the same depth, the only tagged one is used. Other conflicting fields
+// are ignored.
+func codecFields(t reflect.Type) []codecField {
+	type embedded struct {
+		t     reflect.Type
+		index []int
+	}
+	type candidate struct {
+		field  codecField
+		tagged bool
+	}
+	fields := []codecField{}
+	names := map[string]bool{}
+	visited := map[reflect.Type]bool{}
+	for level := []embedded{{t, nil}}; len(level) > 0; {
+		var next []embedded
+		candidates := map[string][]candidate{}
+		order := []string{}
+		for _, e := range level {
+			if visited[e.t] {
+				continue
+			}
+			visited[e.t] = true
+			for i := 0; i < e.t.NumField(); i++ {
+				sf := e.t.Field(i)
+				tag := sf.Tag.Get("lua")
+				if tag == "-" {
+					continue
+				}
+				name, opts, _ := strings.Cut(tag, ",")
+				fieldIndex := append(append([]int{}, e.index...), i)
+				ft := sf.Type
+				if ft.Kind() == reflect.Ptr {
+					ft = ft.Elem()
+				}
+				if sf.Anonymous && len(name) == 0 && ft.Kind() == reflect.Struct {
+					next = append(next, embedded{ft, fieldIndex})
+					continue
+				}
+				if !sf.IsExported() {
+					continue
+				}
+				tagged := len(name) > 0
+				if !tagged {
+					name = sf.Name
+				}
+				if names[name] {
+					continue
+				}
+				if len(candidates[name]) == 0 {
+					order = append(order, name)
+				}
+				field := codecField{name, fieldIndex, slices.Contains(strings.Split(opts, ","), "omitempty")}
+				candidates[name] = append(candidates[name], candidate{field, tagged})
+			}
+		}
+		for _, name := range order {
+			names[name] = true
+			cs := candidates[name]
+			if len(cs) > 1 {
+				cs = slices.DeleteFunc(cs, func(c candidate) bool { return !c.tagged })
+			}
+			if len(cs) == 1 {
+				fields = append(fields, cs[0].field)
+			}
+		}
+		level = next
+	}
+	slices.SortFunc(fields, func(a, b codecField) int { return slices.Compare(a.index, b.index) })
+	return fields
+}
+
+/* Decode {{{ */
+
+// Decode stores a Lua value in the Go value pointed to by out. Tables are decoded into structs, maps, slices
+// and arrays, and the fields of structs are matched by the names in `lua:"name"` tags or the field names.
+// Keys that do not match any field are ignored, and nil leaves the Go value unchanged, so out may hold
+// default values. time.Duration is decoded from a string like "1m30s" or a number of seconds, time.Time from an
+// RFC 3339 string or a number of seconds since the Unix epoch, and types that implement
+// encoding.TextUnmarshaler from strings. Decode returns a *ValueError that locates the value that can not be
+// decoded.
+func Decode(lv LValue, out interface{}) error {
+	rv := reflect.ValueOf(out)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return newValueError("", "lua: Decode expects a non-nil pointer, got %T", out)
+	}
+	return decodeValue(lv, rv.Elem(), "", 0)
+}
+
+func decodeTypeError(path string, expected string, lv LValue) error {
+	return newValueError(path, "expected %s, got %s", expected, lv.Type().String())
+}
+
+func decodeValue(lv LValue, rv reflect.Value, path string, depth int) error {
+	if depth > maxCodecDepth {
+		return newValueError(path, "value is nested too deeply")
+	}
+	if lv == LNil {
+		return nil
+	}
+	t := rv.Type()
+	if lvt := reflect.TypeOf(lv); lvt.AssignableTo(t) && t.Kind() != reflect.Interface || t == lvalueType {
+		rv.Set(reflect.ValueOf(lv))
+		return nil
+	}
+	if ud, ok := lv.(*LUserData); ok && ud.Value != nil && reflect.TypeOf(ud.Value).AssignableTo(t) {
+		rv.Set(reflect.ValueOf(ud.Value))
+		return nil
+	}
+	switch t {
+	case durationType:
+		switch v := lv.(type) {
+		case LString:
+			d, err := time.ParseDuration(string(v))
+			if err != nil {
+				return newValueError(path, "%s", err.Error())
+			}
+			rv.SetInt(int64(d))
+			return nil
+		case LNumber, LInteger:
+			f, _ := numberAsFloat(v)
+			rv.SetInt(int64(float64(f) * float64(time.Second)))
+			return nil
+		}
+		return decodeTypeError(path, "duration", lv)
+	case timeType:
+		if f, ok := numberAsFloat(lv); ok {
+			sec, frac := math.Modf(float64(f))
+			rv.Set(reflect.ValueOf(time.Unix(int64(sec), int64(frac*1e9))))
+			return nil
+		}
+	}
+	if rv.CanAddr() && rv.Addr().Type().Implements(textUnmarshalerType) {
+		s, ok := lv.(LString)
+		if !ok {
+			return decodeTypeError(path, "string", lv)
+		}
+		if err := rv.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(s)); err != nil {
+			return newValueError(path, "%s", err.Error())
+		}
+		return nil
+	}
+
+	if ok, err := luaToScalar(lv, rv); err != nil {
+		return newValueError(path, "%s", err.Error())
+	} else if ok {
+		return nil
+	}
+	switch t.Kind() {
+	case reflect.Ptr:
+		if rv.IsNil() {
+			rv.Set(reflect.New(t.Elem()))
+		}
+		return decodeValue(lv, rv.Elem(), path, depth+1)
+	case reflect.Interface:
+		if t.NumMethod() != 0 {
+			break
+		}
+		v, err := decodeInterface(lv, path, depth)
+		if err != nil {
+			return err
+		}
+		if v != nil {
+			rv.Set(reflect.ValueOf(v))
+		}
+		return nil
+	case reflect.Bool:
+		return decodeTypeError(path, "boolean", lv)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64:
+		return decodeTypeError(path, "number", lv)
+	case reflect.String:
+		return decodeTypeError(path, "string", lv)
+	case reflect.Slice:
+		if s, ok := lv.(LString); ok && t.Elem().Kind() == reflect.Uint8 {
+			rv.SetBytes([]byte(s))
+			return nil
+		}
+		tb, ok := lv.(*LTable)
+		if !ok {
+			return decodeTypeError(path, "table", lv)
+		}
+		n := tb.Len()
+		rv.Set(reflect.MakeSlice(t, n, n))
+		return decodeElements(tb, rv, path, depth)
+	case reflect.Array:
+		tb, ok := lv.(*LTable)
+		if !ok {
+			return decodeTypeError(path, "table", lv)
+		}
+		if n := tb.Len(); n > rv.Len() {
+			return newValueError(path, "expected at most %d elements, got %d", rv.Len(), n)
+		}
+		return decodeElements(tb, rv, path, depth)
+	case reflect.Map:
+		tb, ok := lv.(*LTable)
+		if !ok {
+			return decodeTypeError(path, "table", lv)
+		}
+		if rv.IsNil() {
+			rv.Set(reflect.MakeMap(t))
+		}
+		var err error
+		tb.ForEach(func(key, value LValue) {
+			if err != nil {
+				return
+			}
+			kpath := codecKeyPath(path, key)
+			k := reflect.New(t.Key()).Elem()
+			if err = decodeValue(key, k, kpath, depth+1); err != nil {
+				return
+			}
+			v := reflect.New(t.Elem()).Elem()
+			if err = decodeValue(value, v, kpath, depth+1); err != nil {
+				return
+			}
+			rv.SetMapIndex(k, v)
+		})
+		return err
+	case reflect.Struct:
+		tb, ok := lv.(*LTable)
+		if !ok {
+			return decodeTypeError(path, "table", lv)
+		}
+		for _, field := range codecFields(t) {
+			value := tb.RawGetString(field.name)
+			if value == LNil {
+				continue
+			}
+			fv, err := fieldByIndexAlloc(rv, field.index)
+			if err != nil {
+				return newValueError(codecFieldPath(path, field.name), "%s", err.Error())
+			}
+			if err := decodeValue(value, fv, codecFieldPath(path, field.name), depth+1); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return newValueError(path, "cannot decode %s into %s", lv.Type().String(), t)
+}
+
+func decodeElements(tb *LTable, rv reflect.Value, path string, depth int) error {
+	n := tb.Len()
+	for i := 0; i < n; i++ {
+		if err := decodeValue(tb.RawGetInt(i+1), rv.Index(i), fmt.Sprintf("%s[%d]", path, i+1), depth+1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// decodeInterface converts a Lua value to a natural Go value. Tables whose keys are 1..n are converted to
+// []interface{}, and other tables to map[string]interface{}.
+func decodeInterface(lv LValue, path string, depth int) (interface{}, error) {
+	if depth > maxCodecDepth {
+		return nil, newValueError(path, "value is nested too deeply")
+	}
+	tb, ok := lv.(*LTable)
+	if !ok {
+		return goValueOf(lv), nil
+	}
+	n := tb.Len()
+	count := 0
+	tb.ForEach(func(LValue, LValue) { count++ })
+	var err error
+	if n > 0 && n == count {
+		values := make([]interface{}, n)
+		for i := range values {
+			if values[i], err = decodeInterface(tb.RawGetInt(i+1), fmt.Sprintf("%s[%d]", path, i+1), depth+1); err != nil {
+				return nil, err
+			}
+		}
+		return values, nil
+	}
+	values := make(map[string]interface{}, count)
+	tb.ForEach(func(key, value LValue) {
+		if err != nil {
+			return
+		}
+		var v interface{}
+		if v, err = decodeInterface(value, codecKeyPath(path, key), depth+1); err == nil {
+			values[key.String()] = v
+		}
+	})
+	return values, err
+}
+
+// fieldByIndexAlloc returns the nested field of a struct, allocating nil embedded pointers.
+func fieldByIndexAlloc(rv reflect.Value, index []int) (reflect.Value, error) {
+	for i, x := range index {
+		if i > 0 && rv.Kind() == reflect.Ptr {
+			if rv.IsNil() {
+				if !rv.CanSet() {
+					return rv, fmt.Errorf("cannot set embedded pointer to unexported struct %s", rv.Type().Elem())
+				}
+				rv.Set(reflect.New(rv.Type().Elem()))
+			}
+			rv = rv.Elem()
+		}
+		rv = rv.Field(x)
+	}
+	return rv, nil
+}
+
+/* }}} */
+
+/* Encode {{{ */
+
+// Encode converts a Go value to a Lua value. Structs and maps are encoded as tables with string or number keys,
+// and slices and arrays as tables with the elements at 1..n. The fields of structs are named by `lua:"name"`
+// tags, and fields with the omitempty option are omitted if they are empty. time.Duration is encoded as a
+// string like "1m30s", and time.Time and types that implement encoding.TextMarshaler are encoded as strings.
+// Encode returns a *ValueError that locates the value that can not be encoded.
+func Encode(L *LState, in interface{}) (LValue, error) {
+	if in == nil {
+		return LNil, nil
+	}
+	return encodeValue(L, reflect.ValueOf(in), "", 0)
+}
+
+func isEmptyValue(rv reflect.Value) bool {
+	switch rv.Kind() {
+	case reflect.Slice, reflect.Map, reflect.Array, reflect.String:
+		return rv.Len() == 0
+	}
+	return rv.IsZero()
+}
+
+func encodeValue(L *LState, rv reflect.Value, path string, depth int) (LValue, error) {
+	if depth > maxCodecDepth {
+		return LNil, newValueError(path, "value is nested too deeply")
+	}
+	if !rv.IsValid() {
+		return LNil, nil
+	}
+	t := rv.Type()
+	if t.Implements(lvalueType) {
+		if rv.Kind() == reflect.Interface && rv.IsNil() {
+			return LNil, nil
+		}
+		return rv.Interface().(LValue), nil
+	}
+	if t == durationType {
+		return LString(time.Duration(rv.Int()).String()), nil
+	}
+	if t.Implements(textMarshalerType) && (t.Kind() != reflect.Ptr || !rv.IsNil()) {
+		text, err := rv.Interface().(encoding.TextMarshaler).MarshalText()
+		if err != nil {
+			return LNil, newValueError(path, "%s", err.Error())
+		}
+		return LString(text), nil
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if rv.IsNil() {
+			return LNil, nil
+		}
+		return encodeValue(L, rv.Elem(), path, depth+1)
+	case reflect.Bool:
+		return LBool(rv.Bool()), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return integerValue(L, rv.Int()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if u := rv.Uint(); u > math.MaxInt64 {
+			return LNumber(u), nil
+		} else {
+			return integerValue(L, int64(u)), nil
+		}
+	case reflect.Float32, reflect.Float64:
+		return LNumber(rv.Float()), nil
+	case reflect.String:
+		return LString(rv.String()), nil
+	case reflect.Slice, reflect.Array:
+		if t.Kind() == reflect.Slice {
+			if rv.IsNil() {
+				return LNil, nil
+			}
+			if t.Elem().Kind() == reflect.Uint8 {
+				return LString(rv.Bytes()), nil
+			}
+		}
+		tb := L.CreateTable(rv.Len(), 0)
+		for i := 0; i < rv.Len(); i++ {
+			v, err := encodeValue(L, rv.Index(i), fmt.Sprintf("%s[%d]", path, i+1), depth+1)
+			if err != nil {
+				return LNil, err
+			}
+			tb.RawSetInt(i+1, v)
+		}
+		return tb, nil
+	case reflect.Map:
+		if rv.IsNil() {
+			return LNil, nil
+		}
+		tb := L.CreateTable(0, rv.Len())
+		iter := rv.MapRange()
+		for iter.Next() {
+			key, err := encodeValue(L, iter.Key(), path, depth+1)
+			if err != nil {
+				return LNil, err
+			}
+			if key == LNil {
+				return LNil, newValueError(path, "map key %v can not be a table key", iter.Key())
+			}
+			v, err := encodeValue(L, iter.Value(), codecKeyPath(path, key), depth+1)
+			if err != nil {
+				return LNil, err
+			}
+			tb.RawSet(key, v)
+		}
+		return tb, nil
+	case reflect.Struct:
+		fields := codecFields(t)
+		tb := L.CreateTable(0, len(fields))
+		for _, field := range fields {
+			fv, err := rv.FieldByIndexErr(field.index)
+			if err != nil || field.omitEmpty && isEmptyValue(fv) {
+				// fields of nil embedded pointers are omitted
+				continue
+			}
+			v, err := encodeValue(L, fv, codecFieldPath(path, field.name), depth+1)
+			if err != nil {
+				return LNil, err
+			}
+			tb.RawSetString(field.name, v)
+		}
+		return tb, nil
+	}
+	return LNil, newValueError(path, "cannot encode %s", t)
+}
+
+/* }}} */
@@ -0,0 +1,198 @@
+package lua
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+type codecTestServer struct {
+	Host string `lua:"host"`
+	Port int    `lua:"port"`
+}
+
+type codecTestCommon struct {
+	Name    string `lua:"name"`
+	Verbose bool   `lua:"verbose,omitempty"`
+}
+
+type codecTestConfig struct {
+	codecTestCommon
+	Servers  []codecTestServer `lua:"servers"`
+	Timeout  time.Duration     `lua:"timeout"`
+	Interval time.Duration     `lua:"interval"`
+	Started  time.Time         `lua:"started"`
+	Labels   map[string]string `lua:"labels,omitempty"`
+	Limits   [2]float64        `lua:"limits"`
+	Backup   *codecTestServer  `lua:"backup,omitempty"`
+	Extra    interface{}       `lua:"extra,omitempty"`
+	Handler  *LFunction        `lua:"handler,omitempty"`
+	Ignored  string            `lua:"-"`
+	Retries  int               `lua:"retries"`
+}
+
+type codecTestBase struct {
+	Name  string
+	Level int `lua:"level"`
+	ID    int
+}
+
+type codecTestOther struct {
+	ID int
+}
+
+type codecTestEmbedding struct {
+	codecTestBase
+	*codecTestOther
+	Name  string
+	Debug bool `lua:"debug,string,omitempty"`
+}
+
+func TestCodecEmbeddedFields(t *testing.T) {
+	L := NewState()
+	defer L.Close()
+
+	// fields of outer structs hide fields of embedded structs, and conflicting fields at the same depth
+	// are ignored
+	var v codecTestEmbedding
+	errorIfScriptFail(t, L, `v = {Name = "outer", level = 2, ID = 3}`)
+	errorIfNotNil(t, Decode(L.GetGlobal("v"), &v))
+	errorIfNotEqual(t, "outer", v.Name)
+	errorIfNotEqual(t, "", v.codecTestBase.Name)
+	errorIfNotEqual(t, 2, v.Level)
+	errorIfNotEqual(t, 0, v.codecTestBase.ID)
+
+	lv, err := Encode(L, codecTestEmbedding{codecTestBase: codecTestBase{Name: "inner"}, Name: "outer"})
+	errorIfNotNil(t, err)
+	L.SetGlobal("v", lv)
+	errorIfScriptFail(t, L, `assert(v.Name == "outer" and v.level == 0 and v.ID == nil and v.debug == nil)`)
+}
+
+func TestDecode(t *testing.T) {
+	L := NewState()
+	defer L.Close()
+	errorIfScriptFail(t, L, `
+	  config = {
+	    name = "app",
+	    verbose = true,
+	    servers = {{host = "a", port = 80}, {host = "b", port = 8080}},
+	    timeout = "1m30s",
+	    interval = 0.5,
+	    started = "2024-01-02T03:04:05Z",
+	    labels = {env = "prod"},
+	    limits = {1.5, 2},
+	    backup = {host = "c", port = 1},
+	    extra = {1, 2, {k = "v"}},
+	    handler = function() end,
+	    Ignored = "x",
+	    unknown = true,
+	  }
+	`)
+	cfg := codecTestConfig{Retries: 3}
+	errorIfNotNil(t, Decode(L.GetGlobal("config"), &cfg))
+	errorIfNotEqual(t, "app", cfg.Name)
+	errorIfNotEqual(t, true, cfg.Verbose)
+	errorIfNotEqual(t, 2, len(cfg.Servers))
+	errorIfNotEqual(t, codecTestServer{"b", 8080}, cfg.Servers[1])
+	errorIfNotEqual(t, 90*time.Second, cfg.Timeout)
+	errorIfNotEqual(t, 500*time.Millisecond, cfg.Interval)
+	errorIfNotEqual(t, time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC), cfg.Started)
+	errorIfNotEqual(t, "prod", cfg.Labels["env"])
+	errorIfNotEqual(t, [2]float64{1.5, 2}, cfg.Limits)
+	errorIfNotEqual(t, codecTestServer{"c", 1}, *cfg.Backup)
+	extra := cfg.Extra.([]interface{})
+	errorIfNotEqual(t, 3, len(extra))
+	errorIfNotEqual(t, "v", extra[2].(map[string]interface{})["k"])
+	errorIfNil(t, cfg.Handler)
+	errorIfNotEqual(t, "", cfg.Ignored)
+	errorIfNotEqual(t, 3, cfg.Retries)
+
+	var wrapper struct {
+		Config codecTestConfig `lua:"config"`
+	}
+	errorIfScriptFail(t, L, `config.servers[2].port = "80"`)
+	err := Decode(L.Get(GlobalsIndex), &wrapper)
+	errorIfNotEqual(t, "config.servers[2].port: expected number, got string", err.Error())
+	errorIfNotEqual(t, "config.servers[2].port", err.(*ValueError).Path)
+
+	for script, expected := range map[string]string{
+		`return {port = 1.5}`:                "port: number 1.5 has no integer representation",
+		`return {servers = {"a"}}`:           "servers[1]: expected table, got string",
+		`return {timeout = "soon"}`:          `timeout: time: invalid duration "soon"`,
+		`return {limits = {1, 2, 3}}`:        "limits: expected at most 2 elements, got 3",
+		`return {labels = {["a b"] = true}}`: `labels["a b"]: expected string, got boolean`,
+		`return {port = 1e20}`:               "port: number 1e+20 has no integer representation",
+		`return 1`:                           "expected table, got number",
+	} {
+		errorIfScriptFail(t, L, `f = function() `+script+` end`)
+		errorIfNotNil(t, L.CallByParam(P{Fn: L.GetGlobal("f"), NRet: 1, Protect: true}))
+		var cfg struct {
+			codecTestConfig
+			Port int `lua:"port"`
+		}
+		err := Decode(L.Get(-1), &cfg)
+		L.Pop(1)
+		errorIfFalse(t, err != nil && err.Error() == expected, "%v: expected %q, got %v", script, expected, err)
+	}
+	errorIfFalse(t, strings.Contains(Decode(LNil, cfg).Error(), "non-nil pointer"), "")
+
+	// numbers are converted to strings like CheckString
+	var server codecTestServer
+	errorIfScriptFail(t, L, `server = {host = 10, port = 80.0}`)
+	errorIfNotNil(t, Decode(L.GetGlobal("server"), &server))
+	errorIfNotEqual(t, codecTestServer{"10", 80}, server)
+}
+
+func TestEncode(t *testing.T) {
+	L := NewState()
+	defer L.Close()
+	cfg := &codecTestConfig{
+		codecTestCommon: codecTestCommon{Name: "app"},
+		Servers:         []codecTestServer{{"a", 80}},
+		Timeout:         90 * time.Second,
+		Started:         time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+		Limits:          [2]float64{1.5, 2},
+		Extra:           map[string]interface{}{"list": []int{1, 2}},
+	}
+	lv, err := Encode(L, cfg)
+	errorIfNotNil(t, err)
+	L.SetGlobal("config", lv)
+	errorIfScriptFail(t, L, `
+	  assert(config.name == "app" and config.verbose == nil)
+	  assert(config.servers[1].host == "a" and config.servers[1].port == 80)
+	  assert(config.timeout == "1m30s" and config.interval == "0s")
+	  assert(config.started == "2024-01-02T03:04:05Z")
+	  assert(config.labels == nil and config.backup == nil and config.Ignored == nil)
+	  assert(config.limits[1] == 1.5 and #config.limits == 2)
+	  assert(config.extra.list[2] == 2)
+	`)
+
+	var decoded codecTestConfig
+	errorIfNotNil(t, Decode(lv, &decoded))
+	errorIfNotEqual(t, cfg.Timeout, decoded.Timeout)
+	errorIfNotEqual(t, cfg.Started, decoded.Started)
+	errorIfNotEqual(t, cfg.Servers[0], decoded.Servers[0])
+
+	_, err = Encode(L, map[string]interface{}{"servers": []interface{}{1, func() {}}})
+	errorIfNotEqual(t, "servers[2]: cannot encode func()", err.Error())
+	lv, err = Encode(L, nil)
+	errorIfNotNil(t, err)
+	errorIfNotEqual(t, LNil, lv)
+}
+
+func TestDecodeMatchesCheckOf(t *testing.T) {
+	L := NewState()
+	defer L.Close()
+	for _, lv := range []LValue{LNumber(80), LNumber(1.5), LNumber(1e20), LString("80"), LTrue} {
+		var i int
+		var s string
+		decoded := []bool{Decode(lv, &i) == nil, Decode(lv, &s) == nil}
+		for j, check := range []func(L *LState) int{
+			func(L *LState) int { CheckOf[int](L, 1); return 0 },
+			func(L *LState) int { CheckOf[string](L, 1); return 0 },
+		} {
+			err := L.GPCall(check, lv)
+			errorIfFalse(t, (err == nil) == decoded[j], "%v: Decode and CheckOf must agree: %v", lv, err)
+		}
+	}
+}
@@ -27,6 +27,9 @@ const (
 const regNotDefined = opMaxArgsA + 1
 const labelNoJump = 0
 
+// envName is the name of the variable that holds the environment in the Lua52 dialect.
+const envName = "_ENV"
+
 type expcontext struct {
 	ctype expContextType
 	reg   int
@@ -124,19 +127,25 @@ func isVarArgReturnExpr(expr ast.Expr) bool {
 	return false
 }
 
-func lnumberValue(expr ast.Expr) (LNumber, bool) {
+func lnumberValue(context *funcContext, expr ast.Expr) (LNumber, bool) {
 	if ex, ok := expr.(*ast.NumberExpr); ok {
-		lv, err := parseNumber(ex.Value)
+		lv, err := numberExprValue(context, ex)
 		if err != nil {
 			lv = LNumber(math.NaN())
 		}
-		return lv, true
+		num, ok := lv.(LNumber)
+		return num, ok
 	} else if ex, ok := expr.(*constLValueExpr); ok {
-		return ex.Value.(LNumber), true
+		num, ok := ex.Value.(LNumber)
+		return num, ok
 	}
 	return 0, false
 }
 
+func numberExprValue(context *funcContext, expr *ast.NumberExpr) (LValue, error) {
+	return parseNumberValue(expr.Value, context.Options.IntegerSubtype)
+}
+
 /* utilities }}} */
 
 type gotoLabelDesc struct { // {{{
@@ -410,6 +419,7 @@ type funcContext struct {
 	labelPc         map[int]int
 	gotosCount      int
 	unresolvedGotos map[int]*gotoLabelDesc
+	Options         CompileOptions
 }
 
 func newFuncContext(sourcename string, parent *funcContext) *funcContext {
@@ -426,6 +436,9 @@ func newFuncContext(sourcename string, parent *funcContext) *funcContext {
 		unresolvedGotos: map[int]*gotoLabelDesc{},
 	}
 	fc.Blocks = []*codeBlock{fc.Block}
+	if parent != nil {
+		fc.Options = parent.Options
+	}
 	return fc
 }
 
@@ -690,6 +703,9 @@ func compileAssignStmtLeft(context *funcContext, stmt *ast.AssignStmt) (int, []*
 	reg := context.RegTop()
 	acs := make([]*assigncontext, 0, len(stmt.Lhs))
 	for _, lhs := range stmt.Lhs {
+		if ident, ok := lhs.(*ast.IdentExpr); ok && isEnvGlobal(context, ident) {
+			lhs = envIndexExpr(ident)
+		}
 		switch st := lhs.(type) {
 		case *ast.IdentExpr:
 			identtype := getIdentRefType(context, context, st)
@@ -1152,7 +1168,7 @@ func compileExpr(context *funcContext, reg int, expr ast.Expr, ec *expcontext) i
 		code.AddABx(OP_LOADK, sreg, context.ConstIndex(LString(ex.Value)), sline(ex))
 		return sused
 	case *ast.NumberExpr:
-		num, err := parseNumber(ex.Value)
+		num, err := numberExprValue(context, ex)
 		if err != nil {
 			num = LNumber(math.NaN())
 		}
@@ -1173,6 +1189,9 @@ func compileExpr(context *funcContext, reg int, expr ast.Expr, ec *expcontext) i
 	case *ast.IdentExpr:
 		switch getIdentRefType(context, context, ex) {
 		case ecGlobal:
+			if context.Options.Dialect == Lua52 {
+				return compileExpr(context, reg, envIndexExpr(ex), ec)
+			}
 			code.AddABx(OP_GETGLOBAL, sreg, context.ConstIndex(LString(ex.Value)), sline(ex))
 		case ecUpvalue:
 			code.AddABC(OP_GETUPVAL, sreg, context.Upvalues.RegisterUnique(ex.Value), 0, sline(ex))
@@ -1267,11 +1286,11 @@ func compileExprWithMVPropagation(context *funcContext, expr ast.Expr, reg *int,
 	compileExprWithPropagation(context, expr, reg, save, context.Code.PropagateMV)
 } // }}}
 
-func constFold(exp ast.Expr) ast.Expr { // {{{
+func constFold(context *funcContext, exp ast.Expr) ast.Expr { // {{{
 	switch expr := exp.(type) {
 	case *ast.ArithmeticOpExpr:
-		lvalue, lisconst := lnumberValue(constFold(expr.Lhs))
-		rvalue, risconst := lnumberValue(constFold(expr.Rhs))
+		lvalue, lisconst := lnumberValue(context, constFold(context, expr.Lhs))
+		rvalue, risconst := lnumberValue(context, constFold(context, expr.Rhs))
 		if lisconst && risconst {
 			switch expr.Operator {
 			case "+":
@@ -1293,10 +1312,17 @@ func constFold(exp ast.Expr) ast.Expr { // {{{
 			return expr
 		}
 	case *ast.UnaryMinusOpExpr:
-		expr.Expr = constFold(expr.Expr)
-		if value, ok := lnumberValue(expr.Expr); ok {
+		expr.Expr = constFold(context, expr.Expr)
+		if value, ok := lnumberValue(context, expr.Expr); ok {
 			return &constLValueExpr{Value: LNumber(-value)}
 		}
+		if ex, ok := expr.Expr.(*ast.NumberExpr); ok {
+			if value, err := numberExprValue(context, ex); err == nil {
+				if iv, ok := value.(LInteger); ok {
+					return &constLValueExpr{Value: -iv}
+				}
+			}
+		}
 		return expr
 	default:
 
@@ -1419,7 +1445,7 @@ func compileTableExpr(context *funcContext, reg int, ex *ast.TableExpr, ec *expc
 } // }}}
 
 func compileArithmeticOpExpr(context *funcContext, reg int, expr *ast.ArithmeticOpExpr, ec *expcontext) { // {{{
-	exp := constFold(expr)
+	exp := constFold(context, expr)
 	if ex, ok := exp.(*constLValueExpr); ok {
 		exp.SetLine(sline(expr))
 		compileExpr(context, reg, ex, ec)
@@ -1477,7 +1503,7 @@ func compileUnaryOpExpr(context *funcContext, reg int, expr ast.Expr, ec *expcon
 	var operandexpr ast.Expr
 	switch ex := expr.(type) {
 	case *ast.UnaryMinusOpExpr:
-		exp := constFold(ex)
+		exp := constFold(context, ex)
 		if lvexpr, ok := exp.(*constLValueExpr); ok {
 			exp.SetLine(sline(expr))
 			compileExpr(context, reg, lvexpr, ec)
@@ -1744,6 +1770,10 @@ func loadRk(context *funcContext, reg *int, expr ast.Expr, cnst LValue) int { //
 
 func getIdentRefType(context *funcContext, current *funcContext, expr *ast.IdentExpr) expContextType { // {{{
 	if current == nil {
+		if context.Options.Dialect == Lua52 && expr.Value == envName {
+			// the _ENV upvalue of the main chunk
+			return ecUpvalue
+		}
 		return ecGlobal
 	} else if current.FindLocalVar(expr.Value) > -1 {
 		if current == context {
@@ -1754,6 +1784,23 @@ func getIdentRefType(context *funcContext, current *funcContext, expr *ast.Ident
 	return getIdentRefType(context, current.Parent, expr)
 } // }}}
 
+// envIndexExpr returns the expression _ENV.name that a global variable is translated to in the Lua52 dialect.
+func envIndexExpr(ident *ast.IdentExpr) ast.Expr {
+	env := &ast.IdentExpr{Value: envName}
+	key := &ast.StringExpr{Value: ident.Value}
+	expr := &ast.AttrGetExpr{Object: env, Key: key}
+	for _, ex := range []ast.Expr{env, key, expr} {
+		ex.SetLine(sline(ident))
+		ex.SetLastLine(eline(ident))
+	}
+	return expr
+}
+
+// isEnvGlobal returns true if the global variable ident is accessed through _ENV.
+func isEnvGlobal(context *funcContext, ident *ast.IdentExpr) bool {
+	return context.Options.Dialect == Lua52 && getIdentRefType(context, context, ident) == ecGlobal
+}
+
 func getExprName(context *funcContext, expr ast.Expr) string { // {{{
 	switch ex := expr.(type) {
 	case *ast.IdentExpr:
@@ -1780,13 +1827,20 @@ func patchCode(context *funcContext) { // {{{
 		curop := opGetOpCode(inst)
 		switch curop {
 		case OP_CLOSURE:
+			if reg := opGetArgA(inst); reg > maxreg {
+				maxreg = reg
+			}
 			pc += int(context.Proto.FunctionPrototypes[opGetArgBx(inst)].NumUpvalues)
 			moven = 0
 			continue
 		case OP_SETGLOBAL, OP_SETUPVAL, OP_EQ, OP_LT, OP_LE, OP_TEST,
-			OP_TAILCALL, OP_RETURN, OP_FORPREP, OP_FORLOOP, OP_TFORLOOP,
+			OP_TAILCALL, OP_RETURN, OP_FORPREP, OP_FORLOOP,
 			OP_SETLIST, OP_CLOSE:
 			/* nothing to do */
+		case OP_TFORLOOP:
+			if reg := opGetArgA(inst) + 2 + opGetArgC(inst); reg > maxreg {
+				maxreg = reg
+			}
 		case OP_CALL:
 			if reg := opGetArgA(inst) + opGetArgC(inst) - 2; reg > maxreg {
 				maxreg = reg
@@ -1846,7 +1900,32 @@ func patchCode(context *funcContext) { // {{{
 	context.Proto.NumUsedRegisters = uint8(maxreg)
 } // }}}
 
+// Dialect selects the language version a chunk is compiled as.
+type Dialect int
+
+const (
+	// Lua51 resolves global variables in the environment of the running function, which can be changed
+	// with setfenv.
+	Lua51 Dialect = iota
+	// Lua52 resolves global variables as fields of the lexical _ENV variable like Lua 5.2. The main chunk has
+	// _ENV as its first upvalue, which is set to the environment table when the chunk is loaded.
+	Lua52
+)
+
+// CompileOptions holds the options that control the code generation.
+type CompileOptions struct {
+	// IntegerSubtype makes the integer literals LInteger constants.
+	IntegerSubtype bool
+	// Dialect is the language version of the chunk. This defaults to Lua51.
+	Dialect Dialect
+}
+
 func Compile(chunk []ast.Stmt, name string) (proto *FunctionProto, err error) { // {{{
+	return CompileWithOptions(chunk, name, CompileOptions{})
+} // }}}
+
+// CompileWithOptions compiles the chunk like Compile with the given options.
+func CompileWithOptions(chunk []ast.Stmt, name string, opts CompileOptions) (proto *FunctionProto, err error) { // {{{
 	defer func() {
 		if rcv := recover(); rcv != nil {
 			if _, ok := rcv.(*CompileError); ok {
@@ -1864,7 +1943,12 @@ func Compile(chunk []ast.Stmt, name string) (proto *FunctionProto, err error) {
 		funcexpr.SetLastLine(eline(chunk[len(chunk)-1]) + 1)
 	}
 	context := newFuncContext(name, nil)
+	context.Options = opts
+	if opts.Dialect == Lua52 {
+		context.Upvalues.RegisterUnique(envName)
+	}
 	compileFunctionExpr(context, funcexpr, ecnone(0))
 	proto = context.Proto
+	proto.setVerified()
 	return
 } // }}}
@@ -14,6 +14,10 @@ var MaxArrayIndex = 67108864
 
 type LNumber float64
 
+// LInteger is the integer subtype of numbers. Integers are produced only when Options.IntegerSubtype is enabled
+// or Go code pushes them.
+type LInteger int64
+
 const LNumberBit = 64
 const LNumberScanFormat = "%f"
 const LuaVersion = "Lua 5.1"
@@ -13,11 +13,13 @@ func OpenDebug(L *LState) int {
 
 var debugFuncs = map[string]LGFunction{
 	"getfenv":      debugGetFEnv,
+	"gethook":      debugGetHook,
 	"getinfo":      debugGetInfo,
 	"getlocal":     debugGetLocal,
 	"getmetatable": debugGetMetatable,
 	"getupvalue":   debugGetUpvalue,
 	"setfenv":      debugSetFEnv,
+	"sethook":      debugSetHook,
 	"setlocal":     debugSetLocal,
 	"setmetatable": debugSetMetatable,
 	"setupvalue":   debugSetUpvalue,
@@ -29,6 +31,25 @@ func debugGetFEnv(L *LState) int {
 	return 1
 }
 
+func debugGetHook(L *LState) int {
+	ls := L
+	if th, ok := L.Get(1).(*LState); ok {
+		ls = th
+	}
+	if ls.hook == nil {
+		L.Push(LNil)
+		return 1
+	}
+	if ls.hook.luafn != LNil {
+		L.Push(ls.hook.luafn)
+	} else {
+		L.Push(LString("external hook"))
+	}
+	L.Push(LString(hookMaskToString(ls.hook.mask)))
+	L.Push(LNumber(ls.hook.count))
+	return 3
+}
+
 func debugGetInfo(L *LState) int {
 	L.CheckTypes(1, LTFunction, LTNumber)
 	arg1 := L.Get(1)
@@ -41,8 +62,8 @@ func debugGetInfo(L *LState) int {
 	case *LFunction:
 		dbg = &Debug{}
 		fn, err = L.GetInfo(">"+what, dbg, lv)
-	case LNumber:
-		dbg, ok = L.GetStack(int(lv))
+	case LNumber, LInteger:
+		dbg, ok = L.GetStack(int(LVAsNumber(lv)))
 		if !ok {
 			L.Push(LNil)
 			return 1
@@ -111,6 +132,33 @@ func debugSetFEnv(L *LState) int {
 	return 0
 }
 
+func debugSetHook(L *LState) int {
+	ls := L
+	argbase := 0
+	if th, ok := L.Get(1).(*LState); ok {
+		ls = th
+		argbase = 1
+	}
+	if L.Get(argbase+1) == LNil {
+		ls.SetHook(nil, 0, 0)
+		return 0
+	}
+	fn := L.CheckFunction(argbase + 1)
+	mask := hookMaskFromString(L.CheckString(argbase + 2))
+	count := L.OptInt(argbase+3, 0)
+	ls.setHook(func(L *LState, dbg *Debug) {
+		L.Push(fn)
+		L.Push(LString(dbg.Event))
+		if dbg.Event == "line" {
+			L.Push(LNumber(dbg.CurrentLine))
+			L.Call(2, 0)
+		} else {
+			L.Call(1, 0)
+		}
+	}, fn, mask, count)
+	return 0
+}
+
 func debugSetLocal(L *LState) int {
 	level := L.CheckInt(1)
 	idx := L.CheckInt(2)
@@ -171,3 +219,31 @@ func debugTraceback(L *LState) int {
 	L.Push(LString(traceback))
 	return 1
 }
+
+func hookMaskFromString(s string) HookMask {
+	var mask HookMask
+	if strings.Contains(s, "c") {
+		mask |= HookCall
+	}
+	if strings.Contains(s, "r") {
+		mask |= HookReturn
+	}
+	if strings.Contains(s, "l") {
+		mask |= HookLine
+	}
+	return mask
+}
+
+func hookMaskToString(mask HookMask) string {
+	buf := []string{}
+	if mask&HookCall != 0 {
+		buf = append(buf, "c")
+	}
+	if mask&HookReturn != 0 {
+		buf = append(buf, "r")
+	}
+	if mask&HookLine != 0 {
+		buf = append(buf, "l")
+	}
+	return strings.Join(buf, "")
+}
@@ -0,0 +1,319 @@
+package lua
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+/* binary chunks {{{ */
+
+// BinaryChunkSignature is the signature at the beginning of precompiled chunks.
+// A chunk that starts with this signature is loaded as a precompiled chunk instead of a source.
+const BinaryChunkSignature = "\x1bGLua"
+
+// BinaryChunkVersion is the version of the precompiled chunk format.
+// Chunks with a different version are rejected by FunctionProto.UnmarshalBinary.
+const BinaryChunkVersion = 1
+
+const (
+	constTagNil byte = iota
+	constTagFalse
+	constTagTrue
+	constTagNumber
+	constTagString
+	constTagInteger
+)
+
+var errTruncatedChunk = errors.New("truncated precompiled chunk")
+
+// MarshalBinary encodes the function prototype into a precompiled chunk.
+// The chunk can be loaded by LState.Load, LState.LoadFile and FunctionProto.UnmarshalBinary.
+func (fp *FunctionProto) MarshalBinary() ([]byte, error) {
+	enc := &protoEncoder{buf: make([]byte, 0, 256)}
+	enc.buf = append(enc.buf, BinaryChunkSignature...)
+	enc.buf = append(enc.buf, BinaryChunkVersion)
+	if err := enc.proto(fp); err != nil {
+		return nil, err
+	}
+	return enc.buf, nil
+}
+
+// UnmarshalBinary decodes a precompiled chunk created by FunctionProto.MarshalBinary into the function prototype.
+func (fp *FunctionProto) UnmarshalBinary(data []byte) error {
+	if len(data) < len(BinaryChunkSignature)+1 || string(data[:len(BinaryChunkSignature)]) != BinaryChunkSignature {
+		return errors.New("bad header in precompiled chunk")
+	}
+	if v := data[len(BinaryChunkSignature)]; v != BinaryChunkVersion {
+		return fmt.Errorf("precompiled chunk version mismatch (expected %d, got %d)", BinaryChunkVersion, v)
+	}
+	dec := &protoDecoder{buf: data[len(BinaryChunkSignature)+1:]}
+	proto, err := dec.proto(0)
+	if err != nil {
+		return err
+	}
+	if len(dec.buf) != 0 {
+		return errors.New("garbage at the end of precompiled chunk")
+	}
+	*fp = *proto
+	return nil
+}
+
+type protoEncoder struct {
+	buf []byte
+}
+
+func (enc *protoEncoder) uint(v uint64) {
+	enc.buf = binary.AppendUvarint(enc.buf, v)
+}
+
+func (enc *protoEncoder) int(v int) {
+	enc.buf = binary.AppendVarint(enc.buf, int64(v))
+}
+
+func (enc *protoEncoder) string(s string) {
+	enc.uint(uint64(len(s)))
+	enc.buf = append(enc.buf, s...)
+}
+
+func (enc *protoEncoder) proto(fp *FunctionProto) error {
+	enc.string(fp.SourceName)
+	enc.int(fp.LineDefined)
+	enc.int(fp.LastLineDefined)
+	enc.buf = append(enc.buf, fp.NumUpvalues, fp.NumParameters, fp.IsVarArg, fp.NumUsedRegisters)
+
+	enc.uint(uint64(len(fp.Code)))
+	for _, inst := range fp.Code {
+		enc.buf = binary.LittleEndian.AppendUint32(enc.buf, inst)
+	}
+
+	enc.uint(uint64(len(fp.Constants)))
+	for _, c := range fp.Constants {
+		switch v := c.(type) {
+		case *LNilType:
+			enc.buf = append(enc.buf, constTagNil)
+		case LBool:
+			if v {
+				enc.buf = append(enc.buf, constTagTrue)
+			} else {
+				enc.buf = append(enc.buf, constTagFalse)
+			}
+		case LNumber:
+			enc.buf = append(enc.buf, constTagNumber)
+			enc.buf = binary.LittleEndian.AppendUint64(enc.buf, math.Float64bits(float64(v)))
+		case LString:
+			enc.buf = append(enc.buf, constTagString)
+			enc.string(string(v))
+		case LInteger:
+			enc.buf = append(enc.buf, constTagInteger)
+			enc.buf = binary.LittleEndian.AppendUint64(enc.buf, uint64(v))
+		default:
+			return fmt.Errorf("can not dump a constant of type %v", c.Type().String())
+		}
+	}
+
+	enc.uint(uint64(len(fp.FunctionPrototypes)))
+	for _, p := range fp.FunctionPrototypes {
+		if err := enc.proto(p); err != nil {
+			return err
+		}
+	}
+
+	enc.uint(uint64(len(fp.DbgSourcePositions)))
+	for _, pos := range fp.DbgSourcePositions {
+		enc.int(pos)
+	}
+	enc.uint(uint64(len(fp.DbgLocals)))
+	for _, local := range fp.DbgLocals {
+		enc.string(local.Name)
+		enc.int(local.StartPc)
+		enc.int(local.EndPc)
+	}
+	enc.uint(uint64(len(fp.DbgCalls)))
+	for _, call := range fp.DbgCalls {
+		enc.string(call.Name)
+		enc.int(call.Pc)
+	}
+	enc.uint(uint64(len(fp.DbgUpvalues)))
+	for _, name := range fp.DbgUpvalues {
+		enc.string(name)
+	}
+	return nil
+}
+
+// maxProtoDepth is the maximum nesting level of function prototypes in precompiled chunks.
+const maxProtoDepth = 200
+
+type protoDecoder struct {
+	buf []byte
+	err error
+}
+
+func (dec *protoDecoder) fail(err error) {
+	if dec.err == nil {
+		dec.err = err
+	}
+	dec.buf = nil
+}
+
+func (dec *protoDecoder) bytes(n int) []byte {
+	if n < 0 || n > len(dec.buf) {
+		dec.fail(errTruncatedChunk)
+		return nil
+	}
+	b := dec.buf[:n]
+	dec.buf = dec.buf[n:]
+	return b
+}
+
+func (dec *protoDecoder) byte() byte {
+	if b := dec.bytes(1); b != nil {
+		return b[0]
+	}
+	return 0
+}
+
+func (dec *protoDecoder) uint() uint64 {
+	v, n := binary.Uvarint(dec.buf)
+	if n <= 0 {
+		dec.fail(errTruncatedChunk)
+		return 0
+	}
+	dec.buf = dec.buf[n:]
+	return v
+}
+
+func (dec *protoDecoder) int() int {
+	v, n := binary.Varint(dec.buf)
+	if n <= 0 || v < math.MinInt32 || v > math.MaxInt32 {
+		dec.fail(errTruncatedChunk)
+		return 0
+	}
+	dec.buf = dec.buf[n:]
+	return int(v)
+}
+
+// length reads a number of elements. Each element takes at least size bytes, so lengths that exceed the remaining
+// data are rejected before anything is allocated.
+func (dec *protoDecoder) length(size int) int {
+	v := dec.uint()
+	if v > uint64(len(dec.buf)/size) {
+		dec.fail(errTruncatedChunk)
+		return 0
+	}
+	return int(v)
+}
+
+func (dec *protoDecoder) string() string {
+	return string(dec.bytes(dec.length(1)))
+}
+
+func (dec *protoDecoder) proto(depth int) (*FunctionProto, error) {
+	if depth > maxProtoDepth {
+		return nil, errors.New("function prototypes are nested too deeply in precompiled chunk")
+	}
+	fp := &FunctionProto{}
+	fp.SourceName = dec.string()
+	fp.LineDefined = dec.int()
+	fp.LastLineDefined = dec.int()
+	fp.NumUpvalues = dec.byte()
+	fp.NumParameters = dec.byte()
+	fp.IsVarArg = dec.byte()
+	fp.NumUsedRegisters = dec.byte()
+
+	fp.Code = make([]uint32, dec.length(4))
+	for i := range fp.Code {
+		if b := dec.bytes(4); b != nil {
+			fp.Code[i] = binary.LittleEndian.Uint32(b)
+		}
+	}
+
+	fp.Constants = make([]LValue, dec.length(1))
+	fp.stringConstants = make([]string, len(fp.Constants))
+	for i := range fp.Constants {
+		switch tag := dec.byte(); tag {
+		case constTagNil:
+			fp.Constants[i] = LNil
+		case constTagFalse:
+			fp.Constants[i] = LFalse
+		case constTagTrue:
+			fp.Constants[i] = LTrue
+		case constTagNumber:
+			var v uint64
+			if b := dec.bytes(8); b != nil {
+				v = binary.LittleEndian.Uint64(b)
+			}
+			fp.Constants[i] = LNumber(math.Float64frombits(v))
+		case constTagString:
+			s := dec.string()
+			fp.Constants[i] = LString(s)
+			fp.stringConstants[i] = s
+		case constTagInteger:
+			var v uint64
+			if b := dec.bytes(8); b != nil {
+				v = binary.LittleEndian.Uint64(b)
+			}
+			fp.Constants[i] = LInteger(v)
+		default:
+			dec.fail(fmt.Errorf("bad constant type %d in precompiled chunk", tag))
+		}
+	}
+
+	fp.FunctionPrototypes = make([]*FunctionProto, dec.length(1))
+	for i := range fp.FunctionPrototypes {
+		p, err := dec.proto(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		fp.FunctionPrototypes[i] = p
+	}
+
+	fp.DbgSourcePositions = make([]int, dec.length(1))
+	for i := range fp.DbgSourcePositions {
+		fp.DbgSourcePositions[i] = dec.int()
+	}
+	fp.DbgLocals = make([]*DbgLocalInfo, dec.length(3))
+	for i := range fp.DbgLocals {
+		fp.DbgLocals[i] = &DbgLocalInfo{Name: dec.string(), StartPc: dec.int(), EndPc: dec.int()}
+	}
+	fp.DbgCalls = make([]DbgCall, dec.length(2))
+	for i := range fp.DbgCalls {
+		fp.DbgCalls[i] = DbgCall{Name: dec.string(), Pc: dec.int()}
+	}
+	fp.DbgUpvalues = make([]string, dec.length(1))
+	for i := range fp.DbgUpvalues {
+		fp.DbgUpvalues[i] = dec.string()
+	}
+
+	if dec.err != nil {
+		return nil, dec.err
+	}
+	return fp, nil
+}
+
+// isBinaryChunk reports whether the chunk read by reader starts with BinaryChunkSignature.
+func isBinaryChunk(reader *bufio.Reader) bool {
+	sig, _ := reader.Peek(len(BinaryChunkSignature))
+	return string(sig) == BinaryChunkSignature
+}
+
+// loadBinary loads a precompiled chunk. The loaded prototypes are verified by VerifyProto.
+func (ls *LState) loadBinary(reader io.Reader, name string) (*LFunction, error) {
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, newApiErrorE(ApiErrorFile, err)
+	}
+	proto := &FunctionProto{}
+	if err := proto.UnmarshalBinary(data); err != nil {
+		return nil, newApiErrorE(ApiErrorSyntax, fmt.Errorf("%s: %w", name, err))
+	}
+	if err := VerifyProto(proto); err != nil {
+		return nil, newApiErrorE(ApiErrorSyntax, fmt.Errorf("%s: %w", name, err))
+	}
+	return newChunkFunction(proto, ls.currentEnv()), nil
+}
+
+/* }}} */
@@ -0,0 +1,172 @@
+package lua
+
+import (
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+	"weak"
+)
+
+// finalizerWaitTimeout is the maximum time to wait for the Go runtime to queue finalizers after a collection.
+const finalizerWaitTimeout = time.Second
+
+// finalizerQueue manages userdata that have finalizers. When the Go garbage collector finds that such userdata are
+// unreachable, they are queued by runtime finalizers and the finalizers are run later in the owning LState.
+type finalizerQueue struct {
+	mu     sync.Mutex
+	queued chan struct{}
+	queue  []*LUserData
+	// pending is the length of queue. This can be read without locking mu.
+	pending atomic.Int32
+	// tracked holds weak pointers to the userdata that have not been collected yet, in creation order.
+	tracked []weak.Pointer[LUserData]
+	// ncollected is the number of tracked userdata that have been found unreachable, and nqueued is the number of
+	// userdata that have been queued by the runtime finalizers.
+	ncollected int
+	nqueued    int
+}
+
+func (fq *finalizerQueue) track(ud *LUserData) {
+	if ud.finalizable {
+		return
+	}
+	ud.finalizable = true
+	fq.mu.Lock()
+	defer fq.mu.Unlock()
+	if n := len(fq.tracked); n >= 64 && n&(n-1) == 0 {
+		fq.sweep()
+	}
+	fq.tracked = append(fq.tracked, weak.Make(ud))
+	// runtime.AddCleanup can not be used because finalizers must be called with ud itself. The Go runtime never
+	// collects an object with a finalizer that can be reached from itself, so such userdata are finalized by takeAll.
+	runtime.SetFinalizer(ud, fq.enqueue)
+}
+
+// enqueue is called by the Go runtime when ud becomes unreachable.
+func (fq *finalizerQueue) enqueue(ud *LUserData) {
+	fq.mu.Lock()
+	defer fq.mu.Unlock()
+	fq.queue = append(fq.queue, ud)
+	fq.pending.Store(int32(len(fq.queue)))
+	fq.nqueued++
+	if fq.queued != nil {
+		select {
+		case fq.queued <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// sweep removes the unreachable userdata from tracked. mu must be locked.
+func (fq *finalizerQueue) sweep() {
+	tracked := fq.tracked[:0]
+	for _, wp := range fq.tracked {
+		if wp.Value() == nil {
+			fq.ncollected++
+			continue
+		}
+		tracked = append(tracked, wp)
+	}
+	clear(fq.tracked[len(tracked):])
+	fq.tracked = tracked
+}
+
+// collect runs the Go garbage collector and waits until the finalizers of the userdata that have been found
+// unreachable are queued. It returns the queued userdata.
+func (fq *finalizerQueue) collect() []*LUserData {
+	runtime.GC()
+	fq.mu.Lock()
+	if fq.queued == nil {
+		fq.queued = make(chan struct{}, 1)
+	}
+	fq.sweep()
+	deadline := time.After(finalizerWaitTimeout)
+	for fq.nqueued < fq.ncollected {
+		fq.mu.Unlock()
+		select {
+		case <-fq.queued:
+		case <-deadline:
+			fq.mu.Lock()
+			fq.ncollected = fq.nqueued
+			fq.mu.Unlock()
+		}
+		fq.mu.Lock()
+	}
+	fq.mu.Unlock()
+	return fq.take()
+}
+
+// take returns the queued userdata and clears the queue.
+func (fq *finalizerQueue) take() []*LUserData {
+	if fq.pending.Load() == 0 {
+		return nil
+	}
+	fq.mu.Lock()
+	defer fq.mu.Unlock()
+	queue := fq.queue
+	fq.queue = nil
+	fq.pending.Store(0)
+	return queue
+}
+
+// takeAll returns all the queued and the reachable userdata in reverse creation order, and cancels the runtime
+// finalizers of them.
+func (fq *finalizerQueue) takeAll() []*LUserData {
+	uds := fq.take()
+	fq.mu.Lock()
+	defer fq.mu.Unlock()
+	for i := len(fq.tracked) - 1; i >= 0; i-- {
+		if ud := fq.tracked[i].Value(); ud != nil {
+			runtime.SetFinalizer(ud, nil)
+			uds = append(uds, ud)
+		}
+	}
+	fq.tracked = nil
+	return uds
+}
+
+// setFinalizer makes ud finalizable. The __gc metamethod of ud and fn(if fn is not nil) are called
+// when ud is collected or this LState is closed.
+func (ls *LState) setFinalizer(ud *LUserData, fn func(*LUserData)) {
+	if fn != nil {
+		ud.finalizer = fn
+	}
+	ls.G.finalizers.track(ud)
+}
+
+// runFinalizers calls the finalizers of the given userdata. Errors in __gc metamethods are raised after all the
+// finalizers are called if raise is true, otherwise they are ignored.
+func (ls *LState) runFinalizers(uds []*LUserData, raise bool) {
+	var firsterr error
+	for _, ud := range uds {
+		if fn := ud.finalizer; fn != nil {
+			ud.finalizer = nil
+			fn(ud)
+		}
+		if gc := ls.metaOp1(ud, "__gc"); gc.Type() == LTFunction {
+			ls.Push(gc)
+			ls.Push(ud)
+			if err := ls.PCall(1, 0, nil); err != nil && firsterr == nil {
+				firsterr = err
+			}
+		}
+	}
+	if firsterr != nil && raise {
+		ls.RaiseError("error in __gc metamethod (%v)", firsterr.(*ApiError).Object.String())
+	}
+}
+
+// closeFinalizers calls the finalizers of all the userdata when this LState is closed.
+// Errors are ignored because nobody can handle them.
+func (ls *LState) closeFinalizers() {
+	uds := ls.G.finalizers.takeAll()
+	if len(uds) == 0 {
+		return
+	}
+	defer func() { recover() }()
+	if ls.stack.IsEmpty() {
+		ls.reg.SetTop(0)
+	}
+	ls.runFinalizers(uds, false)
+}
@@ -40,6 +40,8 @@ type FunctionProto struct {
 	DbgUpvalues        []string
 
 	stringConstants []string
+	// verified is non-zero if this prototype has been created by the compiler or verified by VerifyProto.
+	verified uint32
 }
 
 /* Upvalue {{{ */
@@ -163,6 +165,41 @@ func newLFunctionL(proto *FunctionProto, env *LTable, nupvalue int) *LFunction {
 	}
 }
 
+// newDetachedLFunction creates a Lua function that is not enclosed by any function. All its upvalues are closed and nil.
+func newDetachedLFunction(proto *FunctionProto, env *LTable) *LFunction {
+	fn := newLFunctionL(proto, env, int(proto.NumUpvalues))
+	for i := range fn.Upvalues {
+		fn.Upvalues[i] = &Upvalue{value: LNil, closed: true}
+	}
+	return fn
+}
+
+// newChunkFunction creates a function of a main chunk. If the chunk has been compiled with the Lua52 dialect,
+// its _ENV upvalue is set to env.
+func newChunkFunction(proto *FunctionProto, env *LTable) *LFunction {
+	if proto.NumUpvalues == 0 {
+		return newLFunctionL(proto, env, 0)
+	}
+	fn := newDetachedLFunction(proto, env)
+	fn.setEnvUpvalue(env)
+	return fn
+}
+
+// hasEnvUpvalue returns true if the first upvalue of the function is _ENV.
+func (fn *LFunction) hasEnvUpvalue() bool {
+	return !fn.IsG && len(fn.Upvalues) > 0 && fn.Upvalues[0] != nil &&
+		len(fn.Proto.DbgUpvalues) > 0 && fn.Proto.DbgUpvalues[0] == envName
+}
+
+// setEnvUpvalue sets the _ENV upvalue of the function. It returns false if the function has no _ENV upvalue.
+func (fn *LFunction) setEnvUpvalue(env LValue) bool {
+	if !fn.hasEnvUpvalue() {
+		return false
+	}
+	fn.Upvalues[0].SetValue(env)
+	return true
+}
+
 func newLFunctionG(gfunc LGFunction, env *LTable, nupvalue int) *LFunction {
 	return &LFunction{
 		IsG: true,
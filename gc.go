@@ -0,0 +1,74 @@
+package lua
+
+import (
+	"weak"
+)
+
+const (
+	defaultGCPause   = 200
+	defaultGCStepMul = 200
+)
+
+// gcState holds the garbage collection settings and the weak tables of a global state.
+// The memory itself is managed by the Go garbage collector, so collectgarbage only controls the work that
+// is done in the LState: sweeping weak tables and calling finalizers.
+type gcState struct {
+	// stopped is true while the automatic finalizer calls are suspended by collectgarbage("stop").
+	stopped bool
+	pause   int
+	stepmul int
+	// weakTables holds weak pointers to the tables that have been made weak by setmetatable.
+	weakTables []weak.Pointer[LTable]
+}
+
+// registerWeakTable registers tb so that it is swept by collectgarbage.
+func (gc *gcState) registerWeakTable(tb *LTable) {
+	if n := len(gc.weakTables); n >= 64 && n&(n-1) == 0 {
+		gc.compactWeakTables()
+	}
+	gc.weakTables = append(gc.weakTables, weak.Make(tb))
+}
+
+// compactWeakTables removes the collected tables, the tables that are no longer weak and
+// the duplicated entries from weakTables.
+func (gc *gcState) compactWeakTables() {
+	seen := make(map[weak.Pointer[LTable]]struct{}, len(gc.weakTables))
+	tables := gc.weakTables[:0]
+	for _, wp := range gc.weakTables {
+		tb := wp.Value()
+		if tb == nil || tb.weak == 0 {
+			continue
+		}
+		if _, ok := seen[wp]; ok {
+			continue
+		}
+		seen[wp] = struct{}{}
+		tables = append(tables, wp)
+	}
+	clear(gc.weakTables[len(tables):])
+	gc.weakTables = tables
+}
+
+// sweepWeakTables removes the collected entries from all the weak tables.
+func (gc *gcState) sweepWeakTables() {
+	gc.compactWeakTables()
+	for _, wp := range gc.weakTables {
+		if tb := wp.Value(); tb != nil {
+			tb.sweepWeak()
+		}
+	}
+}
+
+// collectGarbage performs a garbage collection cycle. If full is true, the Go garbage collector is run and
+// the finalizers of all the unreachable userdata are called. Otherwise, only the finalizers that are
+// already queued are called.
+func (ls *LState) collectGarbage(full bool) {
+	var uds []*LUserData
+	if full {
+		uds = ls.G.finalizers.collect()
+	} else {
+		uds = ls.G.finalizers.take()
+	}
+	ls.G.gc.sweepWeakTables()
+	ls.runFinalizers(uds, true)
+}
@@ -1,6 +1,6 @@
 module github.com/yuin/gopher-lua
 
-go 1.23
+go 1.24
 
 require github.com/chzyer/readline v0.0.0-20180603132655-2972be24d48e
 
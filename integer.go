@@ -0,0 +1,329 @@
+package lua
+
+import (
+	"math"
+	"strconv"
+	"strings"
+)
+
+/* integer subtype {{{ */
+
+// parseInteger parses a decimal or hexadecimal integer. Hexadecimal integers up to 0xffffffffffffffff wrap around
+// like Lua 5.3.
+func parseInteger(number string) (LInteger, bool) {
+	number = strings.Trim(number, " \t\n")
+	neg := false
+	s := number
+	if len(s) > 0 && (s[0] == '-' || s[0] == '+') {
+		neg = s[0] == '-'
+		s = s[1:]
+	}
+	if len(s) > 2 && s[0] == '0' && (s[1] == 'x' || s[1] == 'X') {
+		v, err := strconv.ParseUint(s[2:], 16, 64)
+		if err != nil {
+			return 0, false
+		}
+		if neg {
+			v = -v
+		}
+		return LInteger(v), true
+	}
+	v, err := strconv.ParseInt(number, 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return LInteger(v), true
+}
+
+// parseNumberValue parses a number. If integers is true, integers are returned as LInteger.
+func parseNumberValue(number string, integers bool) (LValue, error) {
+	if integers {
+		if v, ok := parseInteger(number); ok {
+			return v, nil
+		}
+	}
+	return parseNumber(number)
+}
+
+// floatToInteger converts f to an integer if f has an exact integer representation.
+func floatToInteger(f LNumber) (LInteger, bool) {
+	if math.Floor(float64(f)) != float64(f) || float64(f) < -0x1p63 || float64(f) >= 0x1p63 {
+		return 0, false
+	}
+	return LInteger(f), true
+}
+
+// integerValue returns v as an LInteger if the integer subtype is enabled, or as an LNumber otherwise.
+func integerValue(L *LState, v int64) LValue {
+	if L.Options.IntegerSubtype {
+		return LInteger(v)
+	}
+	return LNumber(v)
+}
+
+// checkInteger returns the n-th argument as an integer. Unlike CheckInt64, a float argument must have an exact
+// integer representation.
+func (ls *LState) checkInteger(n int) int64 {
+	lv := ls.Get(n)
+	if s, ok := lv.(LString); ok {
+		if iv, ok := parseInteger(string(s)); ok {
+			return int64(iv)
+		}
+		if num, err := parseNumber(string(s)); err == nil {
+			lv = num
+		}
+	}
+	switch v := lv.(type) {
+	case LInteger:
+		return int64(v)
+	case LNumber:
+		if iv, ok := floatToInteger(v); ok {
+			return int64(iv)
+		}
+		ls.ArgError(n, "number has no integer representation")
+	}
+	ls.TypeError(n, LTNumber)
+	return 0
+}
+
+// checkNumberValue returns the n-th argument as an LInteger or an LNumber. Strings are converted like operands of
+// arithmetic operations.
+func (ls *LState) checkNumberValue(n int) LValue {
+	switch lv := ls.Get(n).(type) {
+	case LInteger, LNumber:
+		return lv
+	case LString:
+		if num, err := parseNumberValue(string(lv), ls.Options.IntegerSubtype); err == nil {
+			return num
+		}
+	}
+	ls.TypeError(n, LTNumber)
+	return LNil
+}
+
+// normalizeKey converts integer table keys that are equal to an LNumber to that LNumber so that an integer and a
+// float with the same value refer to the same table entry.
+func normalizeKey(key LValue) LValue {
+	if v, ok := key.(LInteger); ok {
+		if iv, ok := floatToInteger(LNumber(v)); ok && iv == v {
+			return LNumber(v)
+		}
+	}
+	return key
+}
+
+// integerArith performs an arithmetic operation on numbers at least one of which is an LInteger.
+// It returns false if lhs or rhs is not a number.
+func integerArith(L *LState, opcode int, lhs, rhs LValue) (LValue, bool) {
+	i1, ok1 := lhs.(LInteger)
+	i2, ok2 := rhs.(LInteger)
+	if !ok1 && !ok2 {
+		return nil, false
+	}
+	if ok1 && ok2 {
+		switch opcode {
+		case OP_ADD:
+			return i1 + i2, true
+		case OP_SUB:
+			return i1 - i2, true
+		case OP_MUL:
+			return i1 * i2, true
+		case OP_MOD:
+			if i2 == 0 {
+				L.RaiseError("attempt to perform '%v'", "n%0")
+			}
+			if i2 == -1 {
+				return LInteger(0), true
+			}
+			v := i1 % i2
+			if v != 0 && (v^i2) < 0 {
+				v += i2
+			}
+			return v, true
+		}
+	}
+	f1, ok1 := numberAsFloat(lhs)
+	f2, ok2 := numberAsFloat(rhs)
+	if !ok1 || !ok2 {
+		return nil, false
+	}
+	return numberArith(L, opcode, f1, f2), true
+}
+
+// numberAsFloat converts an LNumber or an LInteger to LNumber.
+func numberAsFloat(lv LValue) (LNumber, bool) {
+	switch v := lv.(type) {
+	case LNumber:
+		return v, true
+	case LInteger:
+		return LNumber(v), true
+	}
+	return 0, false
+}
+
+// compareNumbers compares two numbers at least one of which is an LInteger without losing precision.
+// It returns -1, 0 or 1, and ok is false if lhs or rhs is not a number or one of them is NaN.
+func compareNumbers(lhs, rhs LValue) (ret int, ok bool) {
+	switch v1 := lhs.(type) {
+	case LInteger:
+		switch v2 := rhs.(type) {
+		case LInteger:
+			return compareInts(int64(v1), int64(v2)), true
+		case LNumber:
+			return compareIntFloat(int64(v1), float64(v2))
+		}
+	case LNumber:
+		if v2, ok := rhs.(LInteger); ok {
+			ret, ok := compareIntFloat(int64(v2), float64(v1))
+			return -ret, ok
+		}
+	}
+	return 0, false
+}
+
+// numberLess reports whether lhs < rhs for numbers of any subtype.
+func numberLess(lhs, rhs LValue) bool {
+	if ret, ok := compareNumbers(lhs, rhs); ok {
+		return ret < 0
+	}
+	f1, _ := numberAsFloat(lhs)
+	f2, _ := numberAsFloat(rhs)
+	return f1 < f2
+}
+
+func compareInts(i1, i2 int64) int {
+	switch {
+	case i1 < i2:
+		return -1
+	case i1 > i2:
+		return 1
+	}
+	return 0
+}
+
+func compareIntFloat(i int64, f float64) (int, bool) {
+	switch {
+	case math.IsNaN(f):
+		return 0, false
+	case f >= 0x1p63:
+		return -1, true
+	case f < -0x1p63:
+		return 1, true
+	}
+	if c := compareInts(i, int64(math.Floor(f))); c != 0 {
+		return c, true
+	}
+	if math.Floor(f) != f {
+		return -1, true
+	}
+	return 0, true
+}
+
+// numberLessThan reports whether lhs < rhs (or lhs <= rhs if orEqual is true) for numbers at least one of which is
+// an LInteger. An error is raised if lhs or rhs is not a number.
+func numberLessThan(L *LState, lhs, rhs LValue, orEqual bool) bool {
+	if lhs.Type() != LTNumber || rhs.Type() != LTNumber {
+		L.RaiseError("attempt to compare %v with %v", lhs.Type().String(), rhs.Type().String())
+	}
+	ret, ok := compareNumbers(lhs, rhs)
+	return ok && (ret < 0 || orEqual && ret == 0)
+}
+
+// numberEquals reports whether two numbers at least one of which is an LInteger are equal.
+func numberEquals(lhs, rhs LValue) bool {
+	ret, ok := compareNumbers(lhs, rhs)
+	return ok && ret == 0
+}
+
+// forPrep prepares a numeric for loop whose control values are not all LNumbers. If the initial value and the step
+// are integers, the loop counts with integers and the limit register holds the number of remaining iterations.
+// Otherwise all the values are converted to LNumber.
+func (ls *LState) forPrep(ra int) {
+	reg := ls.reg
+	init, limit, step := reg.Get(ra), reg.Get(ra+1), reg.Get(ra+2)
+	finit, ok := numberAsFloat(init)
+	if !ok {
+		ls.RaiseError("for statement init must be a number")
+	}
+	flimit, ok := numberAsFloat(limit)
+	if !ok {
+		ls.RaiseError("for statement limit must be a number")
+	}
+	fstep, ok := numberAsFloat(step)
+	if !ok {
+		ls.RaiseError("for statement step must be a number")
+	}
+	iinit, ok1 := init.(LInteger)
+	istep, ok2 := step.(LInteger)
+	if !ok1 || !ok2 {
+		reg.Set(ra, finit-fstep)
+		reg.Set(ra+1, flimit)
+		reg.Set(ra+2, fstep)
+		return
+	}
+	if istep == 0 {
+		ls.RaiseError("'for' step is zero")
+	}
+	ilimit, ok := limit.(LInteger)
+	if !ok {
+		ilimit, ok = clipForLimit(flimit, istep > 0)
+	}
+	if !ok || (istep > 0 && iinit > ilimit) || (istep < 0 && iinit < ilimit) {
+		// the loop does not run
+		reg.Set(ra, LNumber(0))
+		reg.Set(ra+1, LNumber(-1))
+		reg.Set(ra+2, LNumber(1))
+		return
+	}
+	var diff, ustep uint64
+	if istep > 0 {
+		diff, ustep = uint64(ilimit)-uint64(iinit), uint64(istep)
+	} else {
+		diff, ustep = uint64(iinit)-uint64(ilimit), uint64(-(istep+1))+1
+	}
+	count := diff / ustep
+	if count < math.MaxUint64 {
+		count++
+	}
+	reg.Set(ra, iinit-istep)
+	reg.Set(ra+1, LInteger(count))
+}
+
+// clipForLimit converts a float limit of a for loop that counts with integers to an integer.
+// It returns false if the loop does not run.
+func clipForLimit(limit LNumber, up bool) (LInteger, bool) {
+	f := float64(limit)
+	switch {
+	case math.IsNaN(f):
+		return 0, false
+	case f >= 0x1p63:
+		return math.MaxInt64, up
+	case f < -0x1p63:
+		return math.MinInt64, !up
+	case up:
+		return LInteger(math.Floor(f)), true
+	}
+	return LInteger(math.Ceil(f)), true
+}
+
+// forLoop advances a numeric for loop that counts with integers. It returns true if the loop continues.
+func (ls *LState) forLoop(ra int) bool {
+	reg := ls.reg
+	idx, ok1 := reg.Get(ra).(LInteger)
+	count, ok2 := reg.Get(ra + 1).(LInteger)
+	step, ok3 := reg.Get(ra + 2).(LInteger)
+	if !ok1 || !ok2 || !ok3 {
+		ls.RaiseError("for statement init must be a number")
+	}
+	if count == 0 {
+		reg.SetTop(ra + 1)
+		return false
+	}
+	idx += step
+	reg.Set(ra, idx)
+	reg.Set(ra+1, LInteger(uint64(count)-1))
+	reg.Set(ra+3, idx)
+	return true
+}
+
+/* }}} */
@@ -202,6 +202,7 @@ func OpenIo(L *LState) int {
 
 var fileMethods = map[string]LGFunction{
 	"__tostring": fileToString,
+	"__gc":       fileGc,
 	"write":      fileWrite,
 	"close":      fileClose,
 	"flush":      fileFlush,
@@ -229,6 +230,20 @@ func fileToString(L *LState) int {
 	return 1
 }
 
+func fileGc(L *LState) int {
+	file := checkFile(L)
+	if file.closed {
+		return 0
+	}
+	for _, finfo := range stdFiles {
+		if file.fp == finfo.file {
+			return 0
+		}
+	}
+	fileCloseAux(L, file)
+	return 0
+}
+
 func fileWriteAux(L *LState, file *lFile, idx int) int {
 	if n := fileIsWritable(L, file); n != 0 {
 		return n
@@ -298,7 +313,7 @@ func fileCloseAux(L *LState, file *lFile) int {
 	}
 
 errreturn:
-	L.RaiseError(err.Error())
+	L.RaiseError("%s", err.Error())
 	return 0
 }
 
@@ -331,8 +346,8 @@ func fileReadAux(L *LState, file *lFile, idx int) int {
 	top := L.GetTop()
 	for i := idx; i <= top; i++ {
 		switch lv := L.Get(i).(type) {
-		case LNumber:
-			size := int64(lv)
+		case LNumber, LInteger:
+			size := int64(LVAsNumber(lv))
 			if size == 0 {
 				_, err = file.reader.ReadByte()
 				if err == io.EOF {
@@ -474,7 +489,7 @@ func fileLinesIter(L *LState) int {
 			L.Push(LNil)
 			return 1
 		}
-		L.RaiseError(err.Error())
+		L.RaiseError("%s", err.Error())
 	}
 	L.Push(LString(string(buf)))
 	return 1
@@ -544,7 +559,7 @@ func ioInput(L *LState) int {
 	case LString:
 		file, err := newFile(L, nil, string(lv), os.O_RDONLY, 0600, false, true)
 		if err != nil {
-			L.RaiseError(err.Error())
+			L.RaiseError("%s", err.Error())
 		}
 		L.Get(UpvalueIndex(1)).(*LTable).RawSetInt(fileDefInIndex, file)
 		L.Push(file)
@@ -590,7 +605,7 @@ func ioLinesIter(L *LState) int {
 			L.Push(LNil)
 			return 1
 		}
-		L.RaiseError(err.Error())
+		L.RaiseError("%s", err.Error())
 	}
 	L.Push(LString(string(buf)))
 	return 1
@@ -724,7 +739,7 @@ func ioOutput(L *LState) int {
 	case LString:
 		file, err := newFile(L, nil, string(lv), os.O_WRONLY|os.O_CREATE, 0600, true, false)
 		if err != nil {
-			L.RaiseError(err.Error())
+			L.RaiseError("%s", err.Error())
 		}
 		L.Get(UpvalueIndex(1)).(*LTable).RawSetInt(fileDefOutIndex, file)
 		L.Push(file)
@@ -0,0 +1,46 @@
+package lua
+
+import (
+	"iter"
+)
+
+// NewIterator returns a function that can be used as an iterator of the generic for statement. It returns the
+// values of seq converted by LState.ToLValue one by one, and then nil.
+//
+//	L.SetGlobal("lines", lua.NewIterator(L, slices.Values([]string{"a", "b"})))
+//	// for line in lines do print(line) end
+//
+// Note that the loop also ends at a value that is converted to nil. The sequence is stopped when it is exhausted,
+// or when the function is collected or the LState is closed if the loop breaks early.
+func NewIterator[V any](L *LState, seq iter.Seq[V]) *LFunction {
+	next, stop := iter.Pull(seq)
+	return L.NewClosure(func(L *LState) int {
+		v, ok := next()
+		if !ok {
+			stop()
+			L.Push(LNil)
+			return 1
+		}
+		L.Push(L.ToLValue(v))
+		return 1
+	}, L.NewUserDataWithFinalizer(func(*LUserData) { stop() }))
+}
+
+// NewIterator2 is like NewIterator, but returns the pairs of seq. The loop ends at a key that is converted to nil.
+//
+//	L.SetGlobal("items", lua.NewIterator2(L, maps.All(map[string]int{"a": 1})))
+//	// for k, v in items do print(k, v) end
+func NewIterator2[K, V any](L *LState, seq iter.Seq2[K, V]) *LFunction {
+	next, stop := iter.Pull2(seq)
+	return L.NewClosure(func(L *LState) int {
+		k, v, ok := next()
+		if !ok {
+			stop()
+			L.Push(LNil)
+			return 1
+		}
+		L.Push(L.ToLValue(k))
+		L.Push(L.ToLValue(v))
+		return 2
+	}, L.NewUserDataWithFinalizer(func(*LUserData) { stop() }))
+}
@@ -0,0 +1,57 @@
+package lua
+
+import (
+	"maps"
+	"slices"
+	"testing"
+)
+
+func TestNewIterator(t *testing.T) {
+	L := NewState()
+	defer L.Close()
+	L.SetGlobal("values", L.NewFunction(func(L *LState) int {
+		L.Push(NewIterator(L, slices.Values([]string{"a", "b", "c"})))
+		return 1
+	}))
+	L.SetGlobal("items", L.NewFunction(func(L *LState) int {
+		L.Push(NewIterator2(L, maps.All(map[string]int{"a": 1, "b": 2})))
+		return 1
+	}))
+	errorIfScriptFail(t, L, `
+	  local s = ""
+	  for v in values() do s = s .. v end
+	  assert(s == "abc")
+	  local sum = 0
+	  for k, v in items() do
+	    assert(k == "a" and v == 1 or k == "b" and v == 2)
+	    sum = sum + v
+	  end
+	  assert(sum == 3)
+	  local it = values()
+	  assert(it() == "a" and it() == "b" and it() == "c" and it() == nil and it() == nil)
+	`)
+}
+
+func TestNewIteratorStop(t *testing.T) {
+	L := NewState()
+	stopped := 0
+	seq := func(yield func(int) bool) {
+		defer func() { stopped++ }()
+		for i := 1; yield(i); i++ {
+		}
+	}
+	L.SetGlobal("numbers", L.NewFunction(func(L *LState) int {
+		L.Push(NewIterator(L, seq))
+		return 1
+	}))
+	errorIfScriptFail(t, L, `
+	  for i in numbers() do
+	    if i == 3 then break end
+	  end
+	  collectgarbage()
+	`)
+	errorIfNotEqual(t, 1, stopped)
+	errorIfScriptFail(t, L, `it = numbers(); assert(it() == 1)`)
+	L.Close()
+	errorIfNotEqual(t, 2, stopped)
+}
@@ -21,6 +21,13 @@ const (
 	ChannelLibName = "channel"
 	// CoroutineLibName is the name of the coroutine Library.
 	CoroutineLibName = "coroutine"
+	// Bit32LibName is the name of the bit32 Library.
+	Bit32LibName = "bit32"
+	// Utf8LibName is the name of the utf8 Library.
+	Utf8LibName = "utf8"
+	// RegexpLibName is the name of the regexp Library. This library is not opened by OpenLibs, use
+	// PreloadModule(RegexpLibName, OpenRegexp) to make it available to require.
+	RegexpLibName = "regexp"
 )
 
 type luaLib struct {
@@ -39,6 +46,8 @@ var luaLibs = []luaLib{
 	luaLib{DebugLibName, OpenDebug},
 	luaLib{ChannelLibName, OpenChannel},
 	luaLib{CoroutineLibName, OpenCoroutine},
+	luaLib{Bit32LibName, OpenBit32},
+	luaLib{Utf8LibName, OpenUtf8},
 }
 
 // OpenLibs loads the built-in libraries. It is equivalent to running OpenLoad,
@@ -9,6 +9,12 @@ func OpenMath(L *LState) int {
 	mod := L.RegisterModule(MathLibName, mathFuncs).(*LTable)
 	mod.RawSetString("pi", LNumber(math.Pi))
 	mod.RawSetString("huge", LNumber(math.MaxFloat64))
+	if L.Options.IntegerSubtype {
+		mod.RawSetString("type", L.NewFunction(mathType))
+		mod.RawSetString("tointeger", L.NewFunction(mathToInteger))
+		mod.RawSetString("maxinteger", LInteger(math.MaxInt64))
+		mod.RawSetString("mininteger", LInteger(math.MinInt64))
+	}
 	L.Push(mod)
 	return 1
 }
@@ -46,6 +52,13 @@ var mathFuncs = map[string]LGFunction{
 }
 
 func mathAbs(L *LState) int {
+	if iv, ok := L.checkNumberValue(1).(LInteger); ok {
+		if iv < 0 {
+			iv = -iv
+		}
+		L.Push(iv)
+		return 1
+	}
 	L.Push(LNumber(math.Abs(float64(L.CheckNumber(1)))))
 	return 1
 }
@@ -71,7 +84,11 @@ func mathAtan2(L *LState) int {
 }
 
 func mathCeil(L *LState) int {
-	L.Push(LNumber(math.Ceil(float64(L.CheckNumber(1)))))
+	if iv, ok := L.checkNumberValue(1).(LInteger); ok {
+		L.Push(iv)
+		return 1
+	}
+	L.Push(integralValue(L, LNumber(math.Ceil(float64(L.CheckNumber(1))))))
 	return 1
 }
 
@@ -96,7 +113,11 @@ func mathExp(L *LState) int {
 }
 
 func mathFloor(L *LState) int {
-	L.Push(LNumber(math.Floor(float64(L.CheckNumber(1)))))
+	if iv, ok := L.checkNumberValue(1).(LInteger); ok {
+		L.Push(iv)
+		return 1
+	}
+	L.Push(integralValue(L, LNumber(math.Floor(float64(L.CheckNumber(1))))))
 	return 1
 }
 
@@ -131,6 +152,10 @@ func mathMax(L *LState) int {
 	if L.GetTop() == 0 {
 		L.RaiseError("wrong number of arguments")
 	}
+	if L.Options.IntegerSubtype {
+		L.Push(numberExtremum(L, true))
+		return 1
+	}
 	max := L.CheckNumber(1)
 	top := L.GetTop()
 	for i := 2; i <= top; i++ {
@@ -147,6 +172,10 @@ func mathMin(L *LState) int {
 	if L.GetTop() == 0 {
 		L.RaiseError("wrong number of arguments")
 	}
+	if L.Options.IntegerSubtype {
+		L.Push(numberExtremum(L, false))
+		return 1
+	}
 	min := L.CheckNumber(1)
 	top := L.GetTop()
 	for i := 2; i <= top; i++ {
@@ -228,4 +257,56 @@ func mathTanh(L *LState) int {
 	return 1
 }
 
+// integralValue returns an integral float as an LInteger if the integer subtype is enabled and it fits in one.
+func integralValue(L *LState, v LNumber) LValue {
+	if L.Options.IntegerSubtype {
+		if iv, ok := floatToInteger(v); ok {
+			return iv
+		}
+	}
+	return v
+}
+
+// numberExtremum returns the largest argument if max is true or the smallest one otherwise. LIntegers are returned
+// without converting them to LNumbers.
+func numberExtremum(L *LState, max bool) LValue {
+	ret := L.checkNumberValue(1)
+	top := L.GetTop()
+	for i := 2; i <= top; i++ {
+		v := L.checkNumberValue(i)
+		if max && numberLess(ret, v) || !max && numberLess(v, ret) {
+			ret = v
+		}
+	}
+	return ret
+}
+
+func mathType(L *LState) int {
+	switch L.CheckAny(1).(type) {
+	case LInteger:
+		L.Push(LString("integer"))
+	case LNumber:
+		L.Push(LString("float"))
+	default:
+		L.Push(LNil)
+	}
+	return 1
+}
+
+func mathToInteger(L *LState) int {
+	switch lv := L.CheckAny(1).(type) {
+	case LInteger:
+		L.Push(lv)
+	case LNumber:
+		if iv, ok := floatToInteger(lv); ok {
+			L.Push(iv)
+		} else {
+			L.Push(LNil)
+		}
+	default:
+		L.Push(LNil)
+	}
+	return 1
+}
+
 //
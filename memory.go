@@ -0,0 +1,236 @@
+package lua
+
+import (
+	"unsafe"
+)
+
+const (
+	valueSize     = int(unsafe.Sizeof(LValue(nil)))
+	tableSize     = int(unsafe.Sizeof(LTable{}))
+	functionSize  = int(unsafe.Sizeof(LFunction{}))
+	upvalueSize   = int(unsafe.Sizeof(Upvalue{}))
+	userDataSize  = int(unsafe.Sizeof(LUserData{}))
+	stateSize     = int(unsafe.Sizeof(LState{}))
+	callFrameSize = int(unsafe.Sizeof(callFrame{}))
+	stringSize    = int(unsafe.Sizeof(""))
+	// hashEntrySize is an approximate size of a hash part entry of LTable(the value in the map, the key in
+	// `keys` and the index in `k2i`).
+	hashEntrySize = 4 * valueSize
+	// memoryMeasureStep is the divisor of the memory limit that gives the minimum number of bytes allocated
+	// between two measurements of the reachable memory.
+	memoryMeasureStep = 8
+)
+
+// memoryAccount tracks the approximate number of bytes used by an LState and the threads created from it.
+type memoryAccount struct {
+	// used is the number of bytes measured by the last measureMemory call plus
+	// the number of bytes allocated after that.
+	used  int64
+	limit int64
+	// next is the value of used at which the reachable memory is measured again.
+	next int64
+}
+
+func (m *memoryAccount) add(n int) {
+	if m != nil {
+		m.used += int64(n)
+	}
+}
+
+func (m *memoryAccount) exceeded() bool {
+	return m.limit > 0 && m.used > m.next
+}
+
+// setLimit sets the memory limit. The memory is measured when the allocated bytes exceed the limit.
+func (m *memoryAccount) setLimit(limit int64) {
+	m.limit = limit
+	m.next = limit
+}
+
+// measured sets the number of bytes that were measured. Measuring walks all reachable values, so
+// the next measurement is deferred until at least limit/memoryMeasureStep bytes have been allocated, like
+// the pause of a garbage collector. Thus the memory limit may be exceeded by this amount.
+func (m *memoryAccount) measured(used int64) {
+	m.used = used
+	m.next = max(m.limit, used+m.limit/memoryMeasureStep)
+}
+
+func stringMemorySize(s string) int {
+	return stringSize + len(s)
+}
+
+func tableMemorySize(acap, hcap int) int {
+	return tableSize + acap*valueSize + hcap*hashEntrySize
+}
+
+// newAccountedLTable creates a new LTable that charges its allocations to the given memory account.
+func newAccountedLTable(acap int, hcap int, mem *memoryAccount) *LTable {
+	tb := newLTable(acap, hcap)
+	tb.mem = mem
+	mem.add(tableMemorySize(acap, hcap))
+	return tb
+}
+
+// newTable returns a new LTable that charges its allocations to the memory account of this LState.
+func (ls *LState) newTable(acap int, hcap int) *LTable {
+	tb := newAccountedLTable(acap, hcap, &ls.G.mem)
+	tb.intKeys = ls.Options.IntegerSubtype
+	return tb
+}
+
+// allocMemory charges n bytes that are about to be allocated and raises
+// a "not enough memory" error if the memory limit would be exceeded.
+func (ls *LState) allocMemory(n int) {
+	m := &ls.G.mem
+	m.add(n)
+	if m.exceeded() {
+		ls.checkMemory(n)
+	}
+}
+
+// allocString charges a string of n bytes that is about to be allocated like allocMemory.
+func (ls *LState) allocString(n int) {
+	ls.allocMemory(stringSize + n)
+}
+
+// checkMemory measures the memory that is actually reachable from this LState and raises
+// a "not enough memory" error if it exceeds the memory limit. pending is the number of bytes that
+// have been charged, but not allocated yet.
+func (ls *LState) checkMemory(pending int) {
+	m := &ls.G.mem
+	used := ls.measureMemory()
+	if m.limit > 0 && used+int64(pending) > m.limit {
+		m.measured(used)
+		ls.raiseError(0, "not enough memory")
+	}
+	m.measured(used + int64(pending))
+}
+
+// measureMemory returns an approximate number of bytes reachable from the global state.
+func (ls *LState) measureMemory() int64 {
+	mm := &memoryMeasure{
+		visited: make(map[unsafe.Pointer]struct{}),
+	}
+	g := ls.G
+	mm.value(g.Registry)
+	mm.value(g.Global)
+	for _, mt := range g.builtinMts {
+		mm.value(mt)
+	}
+	if g.MainThread != nil {
+		mm.value(g.MainThread)
+	}
+	if g.CurrentThread != nil {
+		mm.value(g.CurrentThread)
+	}
+	mm.value(ls)
+	return mm.size
+}
+
+type memoryMeasure struct {
+	visited map[unsafe.Pointer]struct{}
+	size    int64
+}
+
+func (mm *memoryMeasure) visit(p unsafe.Pointer) bool {
+	if _, ok := mm.visited[p]; ok {
+		return false
+	}
+	mm.visited[p] = struct{}{}
+	return true
+}
+
+func (mm *memoryMeasure) add(n int) {
+	mm.size += int64(n)
+}
+
+func (mm *memoryMeasure) value(lv LValue) {
+	switch v := lv.(type) {
+	case LString:
+		// strings are immutable, so the same string data may be shared by many values
+		if len(v) == 0 || mm.visit(unsafe.Pointer(unsafe.StringData(string(v)))) {
+			mm.add(stringMemorySize(string(v)))
+		}
+	case *LTable:
+		mm.table(v)
+	case *LFunction:
+		mm.function(v)
+	case *LUserData:
+		if v != nil && mm.visit(unsafe.Pointer(v)) {
+			mm.add(userDataSize)
+			mm.value(v.Env)
+			mm.value(v.Metatable)
+		}
+	case *LState:
+		mm.thread(v)
+	}
+}
+
+func (mm *memoryMeasure) table(tb *LTable) {
+	if tb == nil || !mm.visit(unsafe.Pointer(tb)) {
+		return
+	}
+	mm.add(tableMemorySize(cap(tb.array), len(tb.keys)))
+	mm.value(tb.Metatable)
+	for _, v := range tb.array {
+		mm.value(v)
+	}
+	for k, v := range tb.strdict {
+		mm.add(len(k))
+		mm.value(v)
+	}
+	for k, v := range tb.dict {
+		mm.value(k)
+		mm.value(v)
+	}
+}
+
+func (mm *memoryMeasure) function(fn *LFunction) {
+	if fn == nil || !mm.visit(unsafe.Pointer(fn)) {
+		return
+	}
+	mm.add(functionSize + len(fn.Upvalues)*(upvalueSize+8))
+	mm.table(fn.Env)
+	for _, uv := range fn.Upvalues {
+		if uv != nil {
+			mm.value(uv.Value())
+		}
+	}
+	if fn.Proto != nil {
+		mm.proto(fn.Proto)
+	}
+}
+
+func (mm *memoryMeasure) proto(proto *FunctionProto) {
+	if !mm.visit(unsafe.Pointer(proto)) {
+		return
+	}
+	mm.add(len(proto.Code)*4 + len(proto.DbgSourcePositions)*8)
+	for _, c := range proto.Constants {
+		mm.value(c)
+	}
+	for _, p := range proto.FunctionPrototypes {
+		mm.proto(p)
+	}
+}
+
+func (mm *memoryMeasure) thread(L *LState) {
+	if L == nil || !mm.visit(unsafe.Pointer(L)) {
+		return
+	}
+	mm.add(stateSize)
+	mm.table(L.Env)
+	if L.reg != nil {
+		mm.add(len(L.reg.array) * valueSize)
+		for i := 0; i < L.reg.top; i++ {
+			mm.value(L.reg.array[i])
+		}
+	}
+	if L.stack != nil {
+		sp := L.stack.Sp()
+		mm.add(sp * callFrameSize)
+		for i := 0; i < sp; i++ {
+			mm.function(L.stack.At(i).Fn)
+		}
+	}
+}
@@ -18,6 +18,8 @@ func getIntField(L *LState, tb *LTable, key string, v int) int {
 	switch lv := ret.(type) {
 	case LNumber:
 		return int(lv)
+	case LInteger:
+		return int(lv)
 	case LString:
 		slv := string(lv)
 		slv = strings.TrimLeft(slv, " ")
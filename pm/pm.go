@@ -2,13 +2,14 @@
 package pm
 
 import (
+	"container/list"
 	"fmt"
+	"sync"
 )
 
 const (
-	EOS               = -1
-	_UNKNOWN          = -2
-	maxRecursionLevel = 1000000
+	EOS      = -1
+	_UNKNOWN = -2
 )
 
 /* Error {{{ */
@@ -170,6 +171,7 @@ const (
 	opPSave
 	opBrace
 	opNumber
+	opFrontier
 )
 
 type inst struct {
@@ -303,6 +305,10 @@ type bracePattern struct {
 	End   int
 }
 
+type frontierPattern struct {
+	Class class
+}
+
 // }}}
 
 /* parse {{{ */
@@ -399,6 +405,12 @@ func parsePattern(sc *scanner, toplevel bool) *seqPattern {
 			case 'b':
 				sc.Next()
 				pat.Patterns = append(pat.Patterns, &bracePattern{sc.Next(), sc.Next()})
+			case 'f':
+				sc.Next()
+				if sc.Peek() != '[' {
+					panic(newError(sc.CurrentPos(), "missing '[' after '%%%c' in pattern", 'f'))
+				}
+				pat.Patterns = append(pat.Patterns, &frontierPattern{parseClass(sc, true)})
 			default:
 				sc.Restore()
 				pat.Patterns = append(pat.Patterns, &singlePattern{parseClass(sc, true)})
@@ -511,6 +523,8 @@ func compilePattern(p pattern, ps ...*iptr) []inst {
 		ptr.insts = append(ptr.insts, inst{opBrace, nil, pat.Begin, pat.End})
 	case *numberPattern:
 		ptr.insts = append(ptr.insts, inst{opNumber, nil, pat.N, -1})
+	case *frontierPattern:
+		ptr.insts = append(ptr.insts, inst{opFrontier, pat.Class, -1, -1})
 	}
 	if toplevel {
 		if p.(*seqPattern).MustTail {
@@ -525,95 +539,223 @@ func compilePattern(p pattern, ps ...*iptr) []inst {
 
 /* VM {{{ */
 
-// Simple recursive virtual machine based on the
-// "Regular Expression Matching: the Virtual Machine Approach" (https://swtch.com/~rsc/regexp/regexp2.html)
-func recursiveVM(src []byte, insts []inst, pc, sp, recLevel int, ms ...*MatchData) (bool, int, *MatchData) {
-	recLevel++
-	if recLevel > maxRecursionLevel {
-		panic(newError(_UNKNOWN, "pattern/input too complex"))
-	}
-	var m *MatchData
-	if len(ms) == 0 {
-		m = newMatchState()
-	} else {
-		m = ms[0]
-	}
-redo:
-	inst := insts[pc]
-	switch inst.OpCode {
-	case opChar:
-		if sp >= len(src) || !inst.Class.Matches(int(src[sp])) {
-			return false, sp, m
-		}
-		pc++
-		sp++
-		goto redo
-	case opMatch:
-		return true, sp, m
-	case opTailMatch:
-		return sp >= len(src), sp, m
-	case opJmp:
-		pc = inst.Operand1
-		goto redo
-	case opSplit:
-		if ok, nsp, _ := recursiveVM(src, insts, inst.Operand1, sp, recLevel, m); ok {
-			return true, nsp, m
-		}
-		pc = inst.Operand2
-		goto redo
-	case opSave:
-		s := m.setCapture(inst.Operand1, sp)
-		if ok, nsp, _ := recursiveVM(src, insts, pc+1, sp, recLevel, m); ok {
-			return true, nsp, m
-		}
-		m.restoreCapture(inst.Operand1, s)
-		return false, sp, m
-	case opPSave:
-		m.addPosCapture(inst.Operand1, sp+1)
-		pc++
-		goto redo
-	case opBrace:
-		if sp >= len(src) || int(src[sp]) != inst.Operand1 {
-			return false, sp, m
+// backtrack is an entry of the backtracking stack. If capture is negative, the VM resumes the execution at pc and sp
+// when backtracking. Otherwise, the capture is restored to saved.
+type backtrack struct {
+	pc      int
+	sp      int
+	capture int
+	saved   uint32
+}
+
+// Backtracking virtual machine based on the
+// "Regular Expression Matching: the Virtual Machine Approach" (https://swtch.com/~rsc/regexp/regexp2.html).
+// Alternatives are kept in an explicit stack instead of recursive calls, so long subjects do not exhaust the Go stack.
+func (p *Pattern) match(src []byte, sp int, steps *int) (bool, int, *MatchData) {
+	insts := p.insts
+	m := newMatchState()
+	stack := make([]backtrack, 0, 16)
+	pc := 0
+	for {
+		if *steps > 0 {
+			*steps--
+			if *steps == 0 {
+				panic(newError(_UNKNOWN, "pattern/input too complex"))
+			}
 		}
-		count := 1
-		for sp = sp + 1; sp < len(src); sp++ {
-			if int(src[sp]) == inst.Operand2 {
-				count--
+		ok := true
+		inst := insts[pc]
+		switch inst.OpCode {
+		case opChar:
+			if sp >= len(src) || !inst.Class.Matches(int(src[sp])) {
+				ok = false
+				break
+			}
+			pc++
+			sp++
+		case opMatch:
+			return true, sp, m
+		case opTailMatch:
+			if sp >= len(src) {
+				return true, sp, m
 			}
-			if count == 0 {
+			ok = false
+		case opJmp:
+			pc = inst.Operand1
+		case opSplit:
+			stack = append(stack, backtrack{inst.Operand2, sp, -1, 0})
+			pc = inst.Operand1
+		case opSave:
+			s := m.setCapture(inst.Operand1, sp)
+			stack = append(stack, backtrack{-1, -1, inst.Operand1, s})
+			pc++
+		case opPSave:
+			m.addPosCapture(inst.Operand1, sp+1)
+			pc++
+		case opBrace:
+			if sp >= len(src) || int(src[sp]) != inst.Operand1 {
+				ok = false
+				break
+			}
+			ok = false
+			count := 1
+			for nsp := sp + 1; nsp < len(src); nsp++ {
+				if int(src[nsp]) == inst.Operand2 {
+					count--
+				}
+				if count == 0 {
+					pc++
+					sp = nsp + 1
+					ok = true
+					break
+				}
+				if int(src[nsp]) == inst.Operand1 {
+					count++
+				}
+			}
+		case opNumber:
+			idx := inst.Operand1 * 2
+			if idx >= m.CaptureLength()-1 {
+				panic(newError(_UNKNOWN, "invalid capture index"))
+			}
+			capture := src[m.Capture(idx):m.Capture(idx+1)]
+			for i := 0; i < len(capture); i++ {
+				if i+sp >= len(src) || capture[i] != src[i+sp] {
+					ok = false
+					break
+				}
+			}
+			if ok {
 				pc++
-				sp++
-				goto redo
+				sp += len(capture)
+			}
+		case opFrontier:
+			// the beginning and the end of the subject are treated as '\0'
+			prev, cur := 0, 0
+			if sp > 0 {
+				prev = int(src[sp-1])
 			}
-			if int(src[sp]) == inst.Operand1 {
-				count++
+			if sp < len(src) {
+				cur = int(src[sp])
 			}
+			if inst.Class.Matches(prev) || !inst.Class.Matches(cur) {
+				ok = false
+				break
+			}
+			pc++
+		default:
+			panic("should not reach here")
 		}
-		return false, sp, m
-	case opNumber:
-		idx := inst.Operand1 * 2
-		if idx >= m.CaptureLength()-1 {
-			panic(newError(_UNKNOWN, "invalid capture index"))
+		if ok {
+			continue
 		}
-		capture := src[m.Capture(idx):m.Capture(idx+1)]
-		for i := 0; i < len(capture); i++ {
-			if i+sp >= len(src) || capture[i] != src[i+sp] {
+		for {
+			if len(stack) == 0 {
 				return false, sp, m
 			}
+			bt := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			if bt.capture < 0 {
+				pc, sp = bt.pc, bt.sp
+				break
+			}
+			m.restoreCapture(bt.capture, bt.saved)
 		}
-		pc++
-		sp += len(capture)
-		goto redo
 	}
-	panic("should not reach here")
+}
+
+/* }}} */
+
+/* cache {{{ */
+
+// CacheSize is the maximum number of compiled patterns that are cached by Compile.
+const CacheSize = 256
+
+type cacheEntry struct {
+	key     string
+	pattern *Pattern
+}
+
+// patternCache is an LRU cache of compiled patterns.
+type patternCache struct {
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	lru     *list.List
+}
+
+var cache = &patternCache{entries: map[string]*list.Element{}, lru: list.New()}
+
+func (pc *patternCache) get(key string) *Pattern {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	if e, ok := pc.entries[key]; ok {
+		pc.lru.MoveToFront(e)
+		return e.Value.(*cacheEntry).pattern
+	}
+	return nil
+}
+
+func (pc *patternCache) put(key string, pattern *Pattern) {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	if e, ok := pc.entries[key]; ok {
+		pc.lru.MoveToFront(e)
+		return
+	}
+	pc.entries[key] = pc.lru.PushFront(&cacheEntry{key, pattern})
+	if pc.lru.Len() > CacheSize {
+		oldest := pc.lru.Back()
+		pc.lru.Remove(oldest)
+		delete(pc.entries, oldest.Value.(*cacheEntry).key)
+	}
 }
 
 /* }}} */
 
 /* API {{{ */
 
-func Find(p string, src []byte, offset, limit int) (matches []*MatchData, err error) {
+// DefaultStepLimit is the maximum number of VM steps a match attempt at one position of a Find call can execute.
+// Patterns that exceed the limit fail with a "pattern/input too complex" error instead of hanging.
+const DefaultStepLimit = 100000000
+
+// Pattern is a compiled Lua pattern. A Pattern is immutable and can be used by multiple goroutines.
+type Pattern struct {
+	source   string
+	insts    []inst
+	mustHead bool
+}
+
+// Compile compiles a Lua pattern. Compiled patterns are cached, so compiling the same pattern again is cheap.
+func Compile(p string) (pat *Pattern, err error) {
+	if pat := cache.get(p); pat != nil {
+		return pat, nil
+	}
+	defer func() {
+		if v := recover(); v != nil {
+			if perr, ok := v.(*Error); ok {
+				err = perr
+			} else {
+				panic(v)
+			}
+		}
+	}()
+	seq := parsePattern(newScanner([]byte(p)), true)
+	pat = &Pattern{source: p, insts: compilePattern(seq), mustHead: seq.MustHead}
+	cache.put(p, pat)
+	return pat, nil
+}
+
+// String returns the source of the pattern.
+func (p *Pattern) String() string { return p.source }
+
+// Find finds at most limit matches of the pattern in src from offset. A negative limit means no limit.
+func (p *Pattern) Find(src []byte, offset, limit int) ([]*MatchData, error) {
+	return p.FindWithStepLimit(src, offset, limit, DefaultStepLimit)
+}
+
+// FindWithStepLimit is the same as Find, but the VM can execute at most steps steps for each position at which
+// a match is attempted. A steps value of 0 or less means no limit.
+func (p *Pattern) FindWithStepLimit(src []byte, offset, limit, steps int) (matches []*MatchData, err error) {
 	defer func() {
 		if v := recover(); v != nil {
 			if perr, ok := v.(*Error); ok {
@@ -623,11 +765,14 @@ func Find(p string, src []byte, offset, limit int) (matches []*MatchData, err er
 			}
 		}
 	}()
-	pat := parsePattern(newScanner([]byte(p)), true)
-	insts := compilePattern(pat)
+	if steps > 0 {
+		// match stops when the counter reaches 0
+		steps++
+	}
 	matches = []*MatchData{}
 	for sp := offset; sp <= len(src); {
-		ok, nsp, ms := recursiveVM(src, insts, 0, sp, 0)
+		budget := steps
+		ok, nsp, ms := p.match(src, sp, &budget)
 		sp++
 		if ok {
 			if sp < nsp {
@@ -635,11 +780,20 @@ func Find(p string, src []byte, offset, limit int) (matches []*MatchData, err er
 			}
 			matches = append(matches, ms)
 		}
-		if len(matches) == limit || pat.MustHead {
+		if len(matches) == limit || p.mustHead {
 			break
 		}
 	}
 	return
 }
 
+// Find compiles the pattern p and finds at most limit matches of it in src from offset.
+func Find(p string, src []byte, offset, limit int) ([]*MatchData, error) {
+	pat, err := Compile(p)
+	if err != nil {
+		return nil, err
+	}
+	return pat.Find(src, offset, limit)
+}
+
 /* }}} */
@@ -0,0 +1,142 @@
+package pm
+
+import (
+	"strings"
+	"testing"
+)
+
+func findAll(t *testing.T, pattern, src string) []string {
+	t.Helper()
+	matches, err := Find(pattern, []byte(src), 0, -1)
+	if err != nil {
+		t.Fatalf("%q: unexpected error: %v", pattern, err)
+	}
+	ret := make([]string, 0, len(matches))
+	for _, m := range matches {
+		ret = append(ret, src[m.Capture(0):m.Capture(1)])
+	}
+	return ret
+}
+
+func TestFrontierPattern(t *testing.T) {
+	cases := []struct {
+		pattern string
+		src     string
+		// expected matches joined with '|', as string.gmatch of the reference implementation returns
+		expected string
+	}{
+		{"%f[%a]%a+", "THE (quick) fox", "THE|quick|fox"},
+		{"%f[%l]%a+", "THE (quick) fox", "quick|fox"},
+		{"%f[%a]", "ab cd", "|"},
+		{"%a+%f[%A]", "THE (quick) fox", "THE|quick|fox"},
+		{"%f[%w]%w+%f[%W]", "hello, world!", "hello|world"},
+		{"%f[%z]", "foo", ""},
+		{"%f[^%z]", "foo", ""},
+		{"%f[a]", "aaa", ""},
+		{"%f[%d]%d", "a1b22", "1|2"},
+		{"^%f[%a]", "x", ""},
+	}
+	for _, c := range cases {
+		actual := strings.Join(findAll(t, c.pattern, c.src), "|")
+		if actual != c.expected {
+			t.Errorf("%q in %q: expected %q, but got %q", c.pattern, c.src, c.expected, actual)
+		}
+	}
+
+	matches, _ := Find("%f[%z]", []byte("foo"), 0, -1)
+	if len(matches) != 1 || matches[0].Capture(0) != 3 {
+		t.Errorf("%%f[%%z] must match at the end of the subject")
+	}
+	matches, _ = Find("%f[^%z]", []byte("foo"), 0, -1)
+	if len(matches) != 1 || matches[0].Capture(0) != 0 {
+		t.Errorf("%%f[^%%z] must match at the beginning of the subject")
+	}
+
+	for _, pattern := range []string{"%f", "%fa", "%f[a"} {
+		if _, err := Find(pattern, []byte("abc"), 0, -1); err == nil {
+			t.Errorf("%q: error expected", pattern)
+		}
+	}
+	_, err := Find("%fa", []byte("abc"), 0, -1)
+	if err == nil || !strings.Contains(err.Error(), "missing '[' after '%f' in pattern") {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestCompile(t *testing.T) {
+	p1, err := Compile("(%a+)=(%d+)")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	p2, _ := Compile("(%a+)=(%d+)")
+	if p1 != p2 {
+		t.Errorf("compiled patterns must be cached")
+	}
+	if p1.String() != "(%a+)=(%d+)" {
+		t.Errorf("unexpected source: %q", p1.String())
+	}
+	src := []byte("a=1, bb=22")
+	matches, err := p1.Find(src, 0, -1)
+	if err != nil || len(matches) != 2 {
+		t.Fatalf("unexpected result: %v, %v", matches, err)
+	}
+	m := matches[1]
+	if string(src[m.Capture(2):m.Capture(3)]) != "bb" || string(src[m.Capture(4):m.Capture(5)]) != "22" {
+		t.Errorf("unexpected captures: %v", m.captures)
+	}
+
+	if _, err := Compile("(%a+"); err == nil {
+		t.Errorf("error expected")
+	}
+
+	// least recently used patterns are evicted
+	for i := 0; i < CacheSize+1; i++ {
+		Compile(strings.Repeat("a", i+1))
+	}
+	if _, ok := cache.entries["a"]; ok {
+		t.Errorf("the oldest pattern must be evicted")
+	}
+	if len(cache.entries) != CacheSize || cache.lru.Len() != CacheSize {
+		t.Errorf("the cache must not exceed CacheSize")
+	}
+}
+
+func TestStepLimit(t *testing.T) {
+	src := []byte(strings.Repeat("a", 30))
+	p, _ := Compile(strings.Repeat("a*", 30) + "b")
+	_, err := p.FindWithStepLimit(src, 0, 1, 100000)
+	if err == nil || !strings.Contains(err.Error(), "pattern/input too complex") {
+		t.Errorf("step limit error expected, but got %v", err)
+	}
+
+	p, _ = Compile("a*b")
+	matches, err := p.FindWithStepLimit([]byte("aaab"), 0, 1, 100)
+	if err != nil || len(matches) != 1 {
+		t.Errorf("unexpected result: %v, %v", matches, err)
+	}
+
+	// the limit applies to each match attempt, not to the whole input
+	src = []byte(strings.Repeat("ab ", 10000))
+	p, _ = Compile("[%w_]+")
+	matches, err = p.FindWithStepLimit(src, 0, -1, 100)
+	if err != nil || len(matches) != 10000 {
+		t.Errorf("unexpected result: %v, %v", len(matches), err)
+	}
+	p, _ = Compile("c")
+	matches, err = p.FindWithStepLimit(src, 0, 1, 100)
+	if err != nil || len(matches) != 0 {
+		t.Errorf("unexpected result: %v, %v", len(matches), err)
+	}
+}
+
+func TestLongSubject(t *testing.T) {
+	src := []byte(strings.Repeat("ab", 1000000) + "c")
+	matches, err := Find("^(.-)c$", src, 0, 1)
+	if err != nil || len(matches) != 1 || matches[0].Capture(3) != len(src)-1 {
+		t.Errorf("unexpected result: %v", err)
+	}
+	matches, err = Find("[ab]*", src, 0, 1)
+	if err != nil || len(matches) != 1 || matches[0].Capture(1) != len(src)-1 {
+		t.Errorf("unexpected result: %v", err)
+	}
+}
@@ -0,0 +1,270 @@
+package lua
+
+import (
+	"regexp"
+)
+
+const lRegexpClass = "regexp*"
+
+// OpenRegexp is the loader of the regexp module, which provides regular expressions of Go's regexp package.
+// The module is not opened by OpenLibs. Hosts that need it can register it with
+//
+//	L.PreloadModule(lua.RegexpLibName, lua.OpenRegexp)
+//
+// and scripts load it with require("regexp").
+func OpenRegexp(L *LState) int {
+	mt := L.NewTypeMetatable(lRegexpClass)
+	mt.RawSetString("__index", mt)
+	L.SetFuncs(mt, regexpMethods)
+	mod := L.SetFuncs(L.NewTable(), regexpFuncs)
+	L.Push(mod)
+	return 1
+}
+
+var regexpFuncs = map[string]LGFunction{
+	"compile":  regexpCompile,
+	"quote":    regexpQuote,
+	"match":    regexpMatch,
+	"find":     regexpFind,
+	"gmatch":   regexpGmatch,
+	"gsub":     regexpGsub,
+	"split":    regexpSplit,
+	"captures": regexpCaptures,
+}
+
+var regexpMethods = map[string]LGFunction{
+	"__tostring": regexpToString,
+	"match":      regexpMatch,
+	"find":       regexpFind,
+	"gmatch":     regexpGmatch,
+	"gsub":       regexpGsub,
+	"split":      regexpSplit,
+	"captures":   regexpCaptures,
+	"names":      regexpNames,
+}
+
+func newRegexp(L *LState, re *regexp.Regexp) *LUserData {
+	ud := L.NewUserData()
+	ud.Value = re
+	L.SetMetatable(ud, L.GetTypeMetatable(lRegexpClass))
+	return ud
+}
+
+// checkRegexp returns the n-th argument as a compiled regular expression. Strings are compiled.
+func checkRegexp(L *LState, n int) *regexp.Regexp {
+	switch lv := L.Get(n).(type) {
+	case *LUserData:
+		if re, ok := lv.Value.(*regexp.Regexp); ok {
+			return re
+		}
+	case LString:
+		re, err := regexp.Compile(string(lv))
+		if err != nil {
+			L.RaiseError("%s", err.Error())
+		}
+		return re
+	}
+	L.ArgError(n, "regexp expected")
+	return nil
+}
+
+// regexpInit converts the optional init argument to an offset into str, like string.find. It returns false if
+// init is beyond the end of str.
+func regexpInit(L *LState, n int, str string) (int, bool) {
+	init := L.OptInt(n, 1)
+	if init < 0 {
+		init = intMax(len(str)+init+1, 1)
+	} else if init == 0 {
+		init = 1
+	}
+	if init > len(str)+1 {
+		return 0, false
+	}
+	return init - 1, true
+}
+
+// pushSubmatches pushes the captured strings of a match, or the whole match if the regexp has no groups.
+func pushSubmatches(L *LState, str string, loc []int) int {
+	if len(loc) == 2 {
+		L.Push(LString(str[loc[0]:loc[1]]))
+		return 1
+	}
+	for i := 2; i < len(loc); i += 2 {
+		if loc[i] < 0 {
+			L.Push(LNil)
+		} else {
+			L.Push(LString(str[loc[i]:loc[i+1]]))
+		}
+	}
+	return len(loc)/2 - 1
+}
+
+func regexpCompile(L *LState) int {
+	re, err := regexp.Compile(L.CheckString(1))
+	if err != nil {
+		L.Push(LNil)
+		L.Push(LString(err.Error()))
+		return 2
+	}
+	L.Push(newRegexp(L, re))
+	return 1
+}
+
+func regexpQuote(L *LState) int {
+	L.Push(LString(regexp.QuoteMeta(L.CheckString(1))))
+	return 1
+}
+
+func regexpToString(L *LState) int {
+	L.Push(LString(checkRegexp(L, 1).String()))
+	return 1
+}
+
+func regexpMatch(L *LState) int {
+	re := checkRegexp(L, 1)
+	str := L.CheckString(2)
+	offset, ok := regexpInit(L, 3, str)
+	if !ok {
+		L.Push(LNil)
+		return 1
+	}
+	loc := re.FindStringSubmatchIndex(str[offset:])
+	if loc == nil {
+		L.Push(LNil)
+		return 1
+	}
+	return pushSubmatches(L, str[offset:], loc)
+}
+
+func regexpFind(L *LState) int {
+	re := checkRegexp(L, 1)
+	str := L.CheckString(2)
+	offset, ok := regexpInit(L, 3, str)
+	if !ok {
+		L.Push(LNil)
+		return 1
+	}
+	loc := re.FindStringSubmatchIndex(str[offset:])
+	if loc == nil {
+		L.Push(LNil)
+		return 1
+	}
+	L.Push(LNumber(offset + loc[0] + 1))
+	L.Push(LNumber(offset + loc[1]))
+	if len(loc) == 2 {
+		return 2
+	}
+	return pushSubmatches(L, str[offset:], loc) + 2
+}
+
+func regexpGmatch(L *LState) int {
+	re := checkRegexp(L, 1)
+	str := L.CheckString(2)
+	locs := re.FindAllStringSubmatchIndex(str, -1)
+	i := 0
+	L.Push(L.NewFunction(func(L *LState) int {
+		if i >= len(locs) {
+			return 0
+		}
+		i++
+		return pushSubmatches(L, str, locs[i-1])
+	}))
+	return 1
+}
+
+func regexpGsub(L *LState) int {
+	re := checkRegexp(L, 1)
+	str := L.CheckString(2)
+	L.CheckTypes(3, LTString, LTTable, LTFunction)
+	repl := L.CheckAny(3)
+	locs := re.FindAllStringSubmatchIndex(str, L.OptInt(4, -1))
+	buf := make([]byte, 0, len(str))
+	last := 0
+	for _, loc := range locs {
+		buf = append(buf, str[last:loc[0]]...)
+		last = loc[1]
+		var value LValue
+		switch lv := repl.(type) {
+		case LString:
+			// $1, ${1} and ${name} are replaced with the captured strings
+			buf = re.ExpandString(buf, string(lv), str, loc)
+			continue
+		case *LTable:
+			key := str[loc[0]:loc[1]]
+			if len(loc) > 2 && loc[2] >= 0 {
+				key = str[loc[2]:loc[3]]
+			}
+			value = L.GetField(lv, key)
+		case *LFunction:
+			L.Push(lv)
+			n := pushSubmatches(L, str, loc)
+			L.Call(n, 1)
+			value = L.reg.Pop()
+		}
+		switch {
+		case LVIsFalse(value):
+			buf = append(buf, str[loc[0]:loc[1]]...)
+		case LVCanConvToString(value):
+			buf = append(buf, LVAsString(value)...)
+		default:
+			L.RaiseError("invalid replacement value (a %s)", value.Type().String())
+		}
+	}
+	buf = append(buf, str[last:]...)
+	L.Push(LString(buf))
+	L.Push(LNumber(len(locs)))
+	return 2
+}
+
+func regexpSplit(L *LState) int {
+	re := checkRegexp(L, 1)
+	parts := re.Split(L.CheckString(2), L.OptInt(3, -1))
+	tb := L.CreateTable(len(parts), 0)
+	for _, part := range parts {
+		tb.Append(LString(part))
+	}
+	L.Push(tb)
+	return 1
+}
+
+// regexpCaptures returns a table of the captured strings of the first match. Each group is stored at its index,
+// and named groups are also stored by their names.
+func regexpCaptures(L *LState) int {
+	re := checkRegexp(L, 1)
+	str := L.CheckString(2)
+	offset, ok := regexpInit(L, 3, str)
+	if !ok {
+		L.Push(LNil)
+		return 1
+	}
+	loc := re.FindStringSubmatchIndex(str[offset:])
+	if loc == nil {
+		L.Push(LNil)
+		return 1
+	}
+	str = str[offset:]
+	tb := L.CreateTable(len(loc)/2-1, 0)
+	for i, name := range re.SubexpNames() {
+		if i == 0 || loc[2*i] < 0 {
+			continue
+		}
+		value := LString(str[loc[2*i]:loc[2*i+1]])
+		tb.RawSetInt(i, value)
+		if name != "" {
+			tb.RawSetString(name, value)
+		}
+	}
+	L.Push(tb)
+	return 1
+}
+
+// regexpNames returns the names of the groups. Unnamed groups have empty names.
+func regexpNames(L *LState) int {
+	names := checkRegexp(L, 1).SubexpNames()[1:]
+	tb := L.CreateTable(len(names), 0)
+	for _, name := range names {
+		tb.Append(LString(name))
+	}
+	L.Push(tb)
+	return 1
+}
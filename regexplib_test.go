@@ -0,0 +1,98 @@
+package lua
+
+import (
+	"testing"
+)
+
+func TestRegexpNotOpenedByDefault(t *testing.T) {
+	L := NewState()
+	defer L.Close()
+	errorIfScriptFail(t, L, `assert(regexp == nil)`)
+	errorIfScriptNotFail(t, L, `require("regexp")`, "module regexp not found")
+}
+
+func TestRegexpCompile(t *testing.T) {
+	L := NewState()
+	defer L.Close()
+	L.PreloadModule(RegexpLibName, OpenRegexp)
+	errorIfScriptFail(t, L, `
+	  local regexp = require("regexp")
+	  local re = assert(regexp.compile("(\\w+)@(\\w+)\\.com"))
+	  assert(tostring(re) == "(\\w+)@(\\w+)\\.com")
+	  local re2, err = regexp.compile("(a")
+	  assert(re2 == nil and string.find(err, "missing closing %)"))
+	  assert(regexp.quote("a.b*c") == "a\\.b\\*c")
+	`)
+	errorIfScriptNotFail(t, L, `require("regexp").match("(a", "a")`, "missing closing")
+	errorIfScriptNotFail(t, L, `require("regexp").match(1, "a")`, "regexp expected")
+}
+
+func TestRegexpMatchAndFind(t *testing.T) {
+	L := NewState()
+	defer L.Close()
+	L.PreloadModule(RegexpLibName, OpenRegexp)
+	errorIfScriptFail(t, L, `
+	  local regexp = require("regexp")
+	  local re = regexp.compile("(\\w+)@(\\w+)\\.com")
+	  local user, domain = re:match("mail: foo@example.com")
+	  assert(user == "foo" and domain == "example")
+	  assert(re:match("no mail") == nil)
+	  assert(regexp.match("cat|dog", "hotdog") == "dog")
+	  assert(regexp.match("a(x)?b", "ab") == nil)
+	  assert(select("#", regexp.match("a(x)?b", "ab")) == 1)
+
+	  local s, e, u, d = re:find("mail: foo@example.com")
+	  assert(s == 7 and e == 21 and u == "foo" and d == "example")
+	  s, e = regexp.find("o", "foo", 3)
+	  assert(s == 3 and e == 3)
+	  s, e = regexp.find("o", "foo", -1)
+	  assert(s == 3 and e == 3)
+	  assert(regexp.find("o", "foo", 5) == nil)
+	  assert(regexp.find("^o", "foo", 2) == 2)
+
+	  local words = {}
+	  for w in regexp.gmatch("\\w+", "one two  three") do table.insert(words, w) end
+	  assert(table.concat(words, ",") == "one,two,three")
+	`)
+}
+
+func TestRegexpGsubAndSplit(t *testing.T) {
+	L := NewState()
+	defer L.Close()
+	L.PreloadModule(RegexpLibName, OpenRegexp)
+	errorIfScriptFail(t, L, `
+	  local regexp = require("regexp")
+	  local s, n = regexp.gsub("(\\w+)=(\\w+)", "a=1, b=2", "$2=$1")
+	  assert(s == "1=a, 2=b" and n == 2)
+	  s, n = regexp.gsub("(?P<key>\\w+)=\\w+", "a=1, b=2", "${key}", 1)
+	  assert(s == "a, b=2" and n == 1)
+	  s = regexp.gsub("\\w+", "hello world", {hello = "HELLO"})
+	  assert(s == "HELLO world")
+	  s = regexp.gsub("\\d+", "1 2 3", function(d) return tonumber(d) * 2 end)
+	  assert(s == "2 4 6")
+	  s = regexp.gsub("\\d+", "1 2 3", function(d) if d == "2" then return false end return "x" end)
+	  assert(s == "x 2 x")
+	  local ok, msg = pcall(regexp.gsub, "\\d", "1", function() return {} end)
+	  assert(not ok and string.find(msg, "invalid replacement value %(a table%)"))
+
+	  local parts = regexp.split("\\s*,\\s*", "a , b,c")
+	  assert(#parts == 3 and parts[1] == "a" and parts[2] == "b" and parts[3] == "c")
+	  parts = regexp.compile(","):split("a,b,c", 2)
+	  assert(#parts == 2 and parts[2] == "b,c")
+	`)
+}
+
+func TestRegexpNamedCaptures(t *testing.T) {
+	L := NewState()
+	defer L.Close()
+	L.PreloadModule(RegexpLibName, OpenRegexp)
+	errorIfScriptFail(t, L, `
+	  local regexp = require("regexp")
+	  local re = regexp.compile("(?P<year>\\d{4})-(?P<month>\\d{2})(-(\\d{2}))?")
+	  local c = re:captures("date: 2024-05")
+	  assert(c.year == "2024" and c.month == "05" and c[1] == "2024" and c[2] == "05" and c[3] == nil)
+	  assert(re:captures("no date") == nil)
+	  local names = re:names()
+	  assert(#names == 4 and names[1] == "year" and names[2] == "month" and names[3] == "")
+	`)
+}
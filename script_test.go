@@ -1,8 +1,10 @@
 package lua
 
 import (
+	"bytes"
 	"fmt"
 	"os"
+	"path/filepath"
 	"runtime"
 	"sync/atomic"
 	"testing"
@@ -17,6 +19,7 @@ var gluaTests []string = []string{
 	"base.lua",
 	"coroutine.lua",
 	"db.lua",
+	"gc.lua",
 	"issues.lua",
 	"os.lua",
 	"table.lua",
@@ -24,6 +27,8 @@ var gluaTests []string = []string{
 	"math.lua",
 	"strings.lua",
 	"goto.lua",
+	"bit32.lua",
+	"utf8.lua",
 }
 
 var luaTests []string = []string{
@@ -61,6 +66,9 @@ func testScriptCompile(t *testing.T, script string) {
 	}
 	nop := func(s string) {}
 	nop(proto.String())
+	if err := VerifyProto(proto); err != nil {
+		t.Fatal(err)
+	}
 }
 
 func testScriptDir(t *testing.T, tests []string, directory string) {
@@ -147,6 +155,32 @@ func TestLua(t *testing.T) {
 	testScriptDir(t, luaTests, "_lua5.1-tests")
 }
 
+// TestGluaBinaryChunk verifies that precompiled chunks of all the test scripts pass VerifyProto.
+func TestGluaBinaryChunk(t *testing.T) {
+	scripts, err := filepath.Glob(filepath.Join("_glua-tests", "*.lua"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	L := NewState()
+	defer L.Close()
+	for _, script := range scripts {
+		fn, err := L.LoadFile(script)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := fn.Proto.MarshalBinary()
+		if err != nil {
+			t.Fatal(err)
+		}
+		loaded, err := L.Load(bytes.NewReader(data), script)
+		if err != nil {
+			t.Errorf("%s: %v", script, err)
+			continue
+		}
+		errorIfNotEqual(t, fn.Proto.String(), loaded.Proto.String())
+	}
+}
+
 func TestMergingLoadNilBug2(t *testing.T) {
 	// there was a bug where the LOADNIL merging optimisation would merge LOADNILs that were the targets of
 	// JMP instructions, causing the JMP to jump to the wrong location and breaking the logic and resulting in
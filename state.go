@@ -5,6 +5,7 @@ package lua
 ////////////////////////////////////////////////////////
 
 import (
+	"bufio"
 	"context"
 	"fmt"
 	"io"
@@ -14,7 +15,6 @@ import (
 	"strings"
 	"sync"
 	"sync/atomic"
-	"time"
 
 	"github.com/yuin/gopher-lua/parse"
 )
@@ -61,6 +61,7 @@ const (
 	ApiErrorRun
 	ApiErrorError
 	ApiErrorPanic
+	ApiErrorInstructionLimit
 )
 
 /* }}} */
@@ -109,6 +110,19 @@ type Options struct {
 	// If `MinimizeStackMemory` is set, the call stack will be automatically grown or shrank up to a limit of
 	// `CallStackSize` in order to minimize memory usage. This does incur a slight performance penalty.
 	MinimizeStackMemory bool
+	// Maximum number of VM instructions this LState(and threads created from it) can execute.
+	// A value of 0 means no limit. See also `LState.SetInstructionLimit`.
+	MaxInstructions int
+	// Maximum number of bytes this LState(and threads created from it) can use. Memory usage is estimated from
+	// Lua values, so this does not limit the memory used by Go values that are held by userdata.
+	// A value of 0 means no limit. See also `LState.SetMemoryLimit`.
+	MemoryLimit int
+	// If `IntegerSubtype` is set, integer literals, integer strings converted to numbers and lengths are represented
+	// as LInteger, and arithmetic on LIntegers is performed with 64-bit integers like Lua 5.3.
+	IntegerSubtype bool
+	// `Dialect` is the language version that chunks loaded by `LState.Load` are compiled as. This defaults to
+	// `lua.Lua51`. With `lua.Lua52`, global variables are resolved through the lexical `_ENV` variable.
+	Dialect Dialect
 }
 
 /* }}} */
@@ -117,6 +131,7 @@ type Options struct {
 
 type Debug struct {
 	frame           *callFrame
+	Event           string
 	Name            string
 	What            string
 	Source          string
@@ -128,6 +143,42 @@ type Debug struct {
 
 /* }}} */
 
+/* Hook {{{ */
+
+// HookMask specifies which events a debug hook is called for.
+type HookMask int
+
+const (
+	// HookCall calls the hook when a function is called.
+	HookCall HookMask = 1 << iota
+	// HookReturn calls the hook when a function returns.
+	HookReturn
+	// HookLine calls the hook when the interpreter starts the execution of a new line of code.
+	HookLine
+	// HookCount calls the hook after every `count` instructions.
+	HookCount
+)
+
+// Hook is a debug hook function. The Debug object has the Event field set to one of
+// "call", "return", "line" and "count" and can be passed to GetInfo.
+type Hook func(L *LState, dbg *Debug)
+
+type hookState struct {
+	fn      Hook
+	luafn   LValue
+	mask    HookMask
+	count   int
+	counter int
+	running bool
+
+	lastFrame *callFrame
+	lastPc    int
+	lastLine  int
+	lastOp    int
+}
+
+/* }}} */
+
 /* callFrame {{{ */
 
 type callFrame struct {
@@ -643,6 +694,7 @@ func newGlobal() *Global {
 		Global:     newLTable(0, 64),
 		builtinMts: make(map[int]LValue),
 		tempFiles:  make([]*os.File, 0, 10),
+		gc:         gcState{pause: defaultGCPause, stepmul: defaultGCStepMul},
 	}
 }
 
@@ -651,13 +703,13 @@ func newGlobal() *Global {
 /* package local methods {{{ */
 
 func panicWithTraceback(L *LState) {
-	err := newApiError(ApiErrorRun, L.Get(-1))
+	err := newApiError(L.takeErrorType(), L.Get(-1))
 	err.StackTrace = L.stackTrace(0)
 	panic(err)
 }
 
 func panicWithoutTraceback(L *LState) {
-	err := newApiError(ApiErrorRun, L.Get(-1))
+	err := newApiError(L.takeErrorType(), L.Get(-1))
 	panic(err)
 }
 
@@ -677,6 +729,7 @@ func newLState(options Options) *LState {
 		uvcache:      nil,
 		hasErrorFunc: false,
 		mainLoop:     mainLoop,
+		errType:      ApiErrorRun,
 		ctx:          nil,
 	}
 	if options.MinimizeStackMemory {
@@ -686,6 +739,9 @@ func newLState(options Options) *LState {
 	}
 	ls.reg = newRegistry(ls, options.RegistrySize, options.RegistryGrowStep, options.RegistryMaxSize, al)
 	ls.Env = ls.G.Global
+	ls.G.Global.intKeys = options.IntegerSubtype
+	ls.G.Registry.intKeys = options.IntegerSubtype
+	al.mem = &ls.G.mem
 	return ls
 }
 
@@ -751,6 +807,26 @@ func (ls *LState) raiseError(level int, format string, args ...interface{}) {
 	ls.Panic(ls)
 }
 
+// raiseErrorType raises an error like raiseError, but the error is reported as an ApiError of the given type.
+func (ls *LState) raiseErrorType(typ ApiErrorType, level int, format string, args ...interface{}) {
+	ls.errType = typ
+	ls.raiseError(level, format, args...)
+}
+
+func (ls *LState) takeErrorType() ApiErrorType {
+	typ := ls.errType
+	ls.errType = ApiErrorRun
+	return typ
+}
+
+func (ls *LState) countInstruction() {
+	g := ls.G
+	g.instCount++
+	if g.instCount > g.instLimit {
+		ls.raiseErrorType(ApiErrorInstructionLimit, 1, "instruction limit exceeded")
+	}
+}
+
 func (ls *LState) findLocal(frame *callFrame, no int) string {
 	fn := frame.Fn
 	if !fn.IsG {
@@ -875,6 +951,29 @@ func (ls *LState) kill() {
 	}
 }
 
+// needsCheckedLoop reports whether the VM has to run mainLoopWithHook.
+func (ls *LState) needsCheckedLoop() bool {
+	return ls.hook != nil || ls.G.instLimit > 0 || ls.G.mem.limit > 0
+}
+
+func (ls *LState) updateMainLoop() {
+	switch {
+	case ls.needsCheckedLoop():
+		ls.mainLoop = mainLoopWithHook
+	case ls.ctx != nil:
+		ls.mainLoop = mainLoopWithContext
+	default:
+		ls.mainLoop = mainLoop
+	}
+}
+
+func (ls *LState) callHook(event string, line int) {
+	h := ls.hook
+	h.running = true
+	defer func() { h.running = false }()
+	h.fn(ls, &Debug{frame: ls.currentFrame, Event: event, CurrentLine: line})
+}
+
 func (ls *LState) indexToReg(idx int) int {
 	base := ls.currentLocalBase()
 	if idx > 0 {
@@ -925,7 +1024,13 @@ func (ls *LState) rkString(idx int) string {
 	if (idx & opBitRk) != 0 {
 		return ls.currentFrame.Fn.Proto.stringConstants[idx & ^opBitRk]
 	}
-	return string(ls.reg.array[ls.currentFrame.LocalBase+idx].(LString))
+	// string keys can be loaded into registers, e.g. in table constructors
+	lv := ls.reg.array[ls.currentFrame.LocalBase+idx]
+	if s, ok := lv.(LString); ok {
+		return string(s)
+	}
+	ls.RaiseError("string key expected, got %v", lv.Type().String())
+	return ""
 }
 
 func (ls *LState) closeUpvalues(idx int) { // +inline-start
@@ -1111,7 +1216,7 @@ func (ls *LState) initCallFrame(cf *callFrame) { // +inline-start
 			if CompatVarArg {
 				ls.reg.SetTop(cf.LocalBase + nargs + np + 1)
 				if (proto.IsVarArg & VarArgNeedsArg) != 0 {
-					argtb := newLTable(nvarargs, 0)
+					argtb := ls.newTable(nvarargs, 0)
 					for i := 0; i < nvarargs; i++ {
 						argtb.RawSetInt(i+1, ls.reg.Get(cf.LocalBase+np+i))
 					}
@@ -1223,7 +1328,7 @@ func (ls *LState) pushCallFrame(cf callFrame, fn LValue, meta bool) { // +inline
 				if CompatVarArg {
 					ls.reg.SetTop(cf.LocalBase + nargs + np + 1)
 					if (proto.IsVarArg & VarArgNeedsArg) != 0 {
-						argtb := newLTable(nvarargs, 0)
+						argtb := ls.newTable(nvarargs, 0)
 						for i := 0; i < nvarargs; i++ {
 							argtb.RawSetInt(i+1, ls.reg.Get(cf.LocalBase+np+i))
 						}
@@ -1244,6 +1349,9 @@ func (ls *LState) pushCallFrame(cf callFrame, fn LValue, meta bool) { // +inline
 } // +inline-end
 
 func (ls *LState) callR(nargs, nret, rbase int) {
+	if ls.stack.IsEmpty() && ls.G.finalizers.pending.Load() != 0 && !ls.G.gc.stopped {
+		ls.runFinalizers(ls.G.finalizers.take(), false)
+	}
 	base := ls.reg.Top() - nargs - 1
 	if rbase < 0 {
 		rbase = base
@@ -1430,6 +1538,12 @@ func NewState(opts ...Options) *LState {
 		if !opts[0].SkipOpenLibs {
 			ls.OpenLibs()
 		}
+		if opts[0].MaxInstructions > 0 {
+			ls.SetInstructionLimit(opts[0].MaxInstructions)
+		}
+		if opts[0].MemoryLimit > 0 {
+			ls.SetMemoryLimit(opts[0].MemoryLimit)
+		}
 	}
 	return ls
 }
@@ -1439,6 +1553,9 @@ func (ls *LState) IsClosed() bool {
 }
 
 func (ls *LState) Close() {
+	if ls.G.MainThread == nil || ls.G.MainThread == ls {
+		ls.closeFinalizers()
+	}
 	atomic.AddInt32(&ls.stop, 1)
 	for _, file := range ls.G.tempFiles {
 		// ignore errors in these operations
@@ -1602,11 +1719,11 @@ func (ls *LState) Remove(index int) {
 /* object allocation {{{ */
 
 func (ls *LState) NewTable() *LTable {
-	return newLTable(defaultArrayCap, defaultHashCap)
+	return ls.newTable(defaultArrayCap, defaultHashCap)
 }
 
 func (ls *LState) CreateTable(acap, hcap int) *LTable {
-	return newLTable(acap, hcap)
+	return ls.newTable(acap, hcap)
 }
 
 // NewThread returns a new LState that shares with the original state all global objects.
@@ -1615,17 +1732,28 @@ func (ls *LState) NewThread() (*LState, context.CancelFunc) {
 	thread := newLState(ls.Options)
 	thread.G = ls.G
 	thread.Env = ls.Env
+	thread.alloc.mem = &ls.G.mem
 	var f context.CancelFunc = nil
 	if ls.ctx != nil {
-		thread.mainLoop = mainLoopWithContext
 		thread.ctx, f = context.WithCancel(ls.ctx)
 		thread.ctxCancelFn = f
 	}
+	if ls.hook != nil {
+		thread.hook = &hookState{fn: ls.hook.fn, luafn: ls.hook.luafn, mask: ls.hook.mask, count: ls.hook.count, counter: ls.hook.count}
+	}
+	thread.updateMainLoop()
 	return thread, f
 }
 
+// NewFunctionFromProto creates a new Lua function from the given prototype.
+// Prototypes that are not created by the compiler are verified by VerifyProto, and an error is raised
+// if the prototype is malformed. The _ENV upvalue of a prototype compiled with the Lua52 dialect is set to the
+// global table.
 func (ls *LState) NewFunctionFromProto(proto *FunctionProto) *LFunction {
-	return newLFunctionL(proto, ls.Env, int(proto.NumUpvalues))
+	if err := ensureVerified(proto); err != nil {
+		ls.RaiseError("%s", err.Error())
+	}
+	return newChunkFunction(proto, ls.Env)
 }
 
 func (ls *LState) NewUserData() *LUserData {
@@ -1635,6 +1763,18 @@ func (ls *LState) NewUserData() *LUserData {
 	}
 }
 
+// NewUserDataWithFinalizer returns a new LUserData like NewUserData. The finalizer fn is called with the
+// userdata before its __gc metamethod when the userdata is collected or this LState is closed.
+// Finalizers run in this LState: a finalizer of a collected userdata is called at a safe point, that is when
+// a Lua function is called from Go, collectgarbage is called, or this LState is closed.
+// A userdata that can be reached from its own Value, Env or metatable, e.g. through a Go value that refers back to
+// the userdata, is never collected by the Go garbage collector. Its finalizer is called when this LState is closed.
+func (ls *LState) NewUserDataWithFinalizer(fn func(*LUserData)) *LUserData {
+	ud := ls.NewUserData()
+	ls.setFinalizer(ud, fn)
+	return ud
+}
+
 func (ls *LState) NewFunction(fn LGFunction) *LFunction {
 	return newLFunctionG(fn, ls.currentEnv(), 0)
 }
@@ -1661,6 +1801,9 @@ func (ls *LState) ToInt(n int) int {
 	if lv, ok := ls.Get(n).(LNumber); ok {
 		return int(lv)
 	}
+	if lv, ok := ls.Get(n).(LInteger); ok {
+		return int(lv)
+	}
 	if lv, ok := ls.Get(n).(LString); ok {
 		if num, err := parseNumber(string(lv)); err == nil {
 			return int(num)
@@ -1673,7 +1816,13 @@ func (ls *LState) ToInt64(n int) int64 {
 	if lv, ok := ls.Get(n).(LNumber); ok {
 		return int64(lv)
 	}
+	if lv, ok := ls.Get(n).(LInteger); ok {
+		return int64(lv)
+	}
 	if lv, ok := ls.Get(n).(LString); ok {
+		if num, ok := parseInteger(string(lv)); ok {
+			return int64(num)
+		}
 		if num, err := parseNumber(string(lv)); err == nil {
 			return int64(num)
 		}
@@ -1733,7 +1882,7 @@ func (ls *LState) RaiseError(format string, args ...interface{}) {
 // This function is equivalent to lua_error( http://www.lua.org/manual/5.1/manual.html#lua_error ).
 func (ls *LState) Error(lv LValue, level int) {
 	if str, ok := lv.(LString); ok {
-		ls.raiseError(level, string(str))
+		ls.raiseError(level, "%s", string(str))
 	} else {
 		if !ls.hasErrorFunc {
 			ls.closeAllUpvalues()
@@ -1817,6 +1966,36 @@ func (ls *LState) GetStack(level int) (*Debug, bool) {
 	return &Debug{}, false
 }
 
+// SetHook sets a debug hook function for this thread. mask specifies on which events the hook will be
+// called and count is used by HookCount. A nil fn or a zero mask turns off the hook.
+// This function is equivalent to lua_sethook ( http://www.lua.org/manual/5.1/manual.html#lua_sethook ).
+func (ls *LState) SetHook(fn Hook, mask HookMask, count int) {
+	ls.setHook(fn, LNil, mask, count)
+}
+
+// GetHook returns the current hook function, mask and count of this thread.
+func (ls *LState) GetHook() (Hook, HookMask, int) {
+	if ls.hook == nil {
+		return nil, 0, 0
+	}
+	return ls.hook.fn, ls.hook.mask, ls.hook.count
+}
+
+func (ls *LState) setHook(fn Hook, luafn LValue, mask HookMask, count int) {
+	if count <= 0 {
+		mask &^= HookCount
+		count = 0
+	} else {
+		mask |= HookCount
+	}
+	if fn == nil || mask == 0 {
+		ls.hook = nil
+	} else {
+		ls.hook = &hookState{fn: fn, luafn: luafn, mask: mask, count: count, counter: count}
+	}
+	ls.updateMainLoop()
+}
+
 func (ls *LState) GetLocal(dbg *Debug, no int) (string, LValue) {
 	frame := dbg.frame
 	if name := ls.findLocal(frame, no); len(name) > 0 {
@@ -1964,7 +2143,7 @@ func (ls *LState) ObjLen(v1 LValue) int {
 		ls.Call(1, 1)
 		ret := ls.reg.Pop()
 		if ret.Type() == LTNumber {
-			return int(ret.(LNumber))
+			return int(LVAsNumber(ret))
 		}
 	} else if v1.Type() == LTTable {
 		return v1.(*LTable).Len()
@@ -2010,22 +2189,40 @@ func (ls *LState) Register(name string, fn LGFunction) {
 
 /* load and function call operations {{{ */
 
+// Load loads a chunk from reader. The chunk can be a source or a precompiled chunk created by string.dump or
+// FunctionProto.MarshalBinary.
 func (ls *LState) Load(reader io.Reader, name string) (*LFunction, error) {
-	chunk, err := parse.Parse(reader, name)
+	return ls.LoadWithOptions(reader, name, CompileOptions{IntegerSubtype: ls.Options.IntegerSubtype, Dialect: ls.Options.Dialect})
+}
+
+// LoadWithOptions loads a chunk like Load, but source chunks are compiled with the given options. This can be used
+// to select the dialect per chunk. IntegerSubtype should be the same as the IntegerSubtype of the state options.
+func (ls *LState) LoadWithOptions(reader io.Reader, name string, opts CompileOptions) (*LFunction, error) {
+	br, ok := reader.(*bufio.Reader)
+	if !ok {
+		br = bufio.NewReader(reader)
+	}
+	if isBinaryChunk(br) {
+		return ls.loadBinary(br, name)
+	}
+	chunk, err := parse.Parse(br, name)
 	if err != nil {
 		return nil, newApiErrorE(ApiErrorSyntax, err)
 	}
-	proto, err := Compile(chunk, name)
+	proto, err := CompileWithOptions(chunk, name, opts)
 	if err != nil {
 		return nil, newApiErrorE(ApiErrorSyntax, err)
 	}
-	return newLFunctionL(proto, ls.currentEnv(), 0), nil
+	return newChunkFunction(proto, ls.currentEnv()), nil
 }
 
 func (ls *LState) Call(nargs, nret int) {
 	ls.callR(nargs, nret, -1)
 }
 
+// PCall calls a function in protected mode. If errfunc is not nil, it is called with the original error object,
+// which may be any Lua value, before the call stack is unwound, so it can inspect the stack with debug.traceback
+// or LState.GetStack. The value errfunc returns becomes the Object of the returned ApiError.
 func (ls *LState) PCall(nargs, nret int, errfunc *LFunction) (err error) {
 	err = nil
 	sp := ls.stack.Sp()
@@ -2121,6 +2318,8 @@ func (ls *LState) GetMetatable(obj LValue) LValue {
 	return ls.metatable(obj, false)
 }
 
+// SetMetatable sets the metatable of obj. For tables, the `__mode` field of mt is read at this time, so
+// changing `__mode` of mt later does not make the table weak or strong until the metatable is set again.
 func (ls *LState) SetMetatable(obj LValue, mt LValue) {
 	switch mt.(type) {
 	case *LNilType, *LTable:
@@ -2131,8 +2330,16 @@ func (ls *LState) SetMetatable(obj LValue, mt LValue) {
 	switch v := obj.(type) {
 	case *LTable:
 		v.Metatable = mt
+		mode := weakModeOf(mt)
+		if v.weak == 0 && mode != 0 {
+			ls.G.gc.registerWeakTable(v)
+		}
+		v.setWeakMode(mode)
 	case *LUserData:
 		v.Metatable = mt
+		if ls.metaOp1(v, "__gc") != LNil {
+			ls.setFinalizer(v, nil)
+		}
 	default:
 		ls.G.builtinMts[int(obj.Type())] = mt
 	}
@@ -2207,7 +2414,7 @@ func (ls *LState) Resume(th *LState, fn *LFunction, args ...LValue) (ResumeState
 	ls.SetTop(top)
 
 	if haserror {
-		return ResumeError, newApiError(ApiErrorRun, ret[0]), nil
+		return ResumeError, newApiError(th.takeErrorType(), ret[0]), nil
 	} else if th.stack.IsEmpty() {
 		return ResumeOK, nil, ret
 	}
@@ -2238,29 +2445,34 @@ func (ls *LState) XMoveTo(other *LState, n int) {
 
 /* GopherLua original APIs {{{ */
 
-// Set maximum memory size. This function can only be called from the main thread.
+// Set maximum memory size in megabytes. This function can only be called from the main thread.
+// See SetMemoryLimit.
 func (ls *LState) SetMx(mx int) {
 	if ls.Parent != nil {
 		ls.RaiseError("sub threads are not allowed to set a memory limit")
 	}
-	go func() {
-		limit := uint64(mx * 1024 * 1024) //MB
-		var s runtime.MemStats
-		for atomic.LoadInt32(&ls.stop) == 0 {
-			runtime.ReadMemStats(&s)
-			if s.Alloc >= limit {
-				fmt.Println("out of memory")
-				os.Exit(3)
-			}
-			time.Sleep(100 * time.Millisecond)
-		}
-	}()
+	ls.SetMemoryLimit(mx * 1024 * 1024)
+}
+
+// SetMemoryLimit sets the maximum number of bytes this LState and threads created from it can use.
+// When the limit is exceeded, the running script is interrupted with a "not enough memory" Lua error.
+// This error can be caught by pcall, and this LState can be used after the error was raised.
+// A limit of 0 removes the limit.
+func (ls *LState) SetMemoryLimit(limit int) {
+	ls.G.mem.setLimit(int64(limit))
+	ls.updateMainLoop()
+}
+
+// MemoryUsage returns an approximate number of bytes used by this LState and threads created from it.
+func (ls *LState) MemoryUsage() int {
+	ls.G.mem.measured(ls.measureMemory())
+	return int(ls.G.mem.used)
 }
 
 // SetContext set a context ctx to this LState. The provided ctx must be non-nil.
 func (ls *LState) SetContext(ctx context.Context) {
-	ls.mainLoop = mainLoopWithContext
 	ls.ctx = ctx
+	ls.updateMainLoop()
 }
 
 // Context returns the LState's context. To change the context, use WithContext.
@@ -2271,8 +2483,8 @@ func (ls *LState) Context() context.Context {
 // RemoveContext removes the context associated with this LState and returns this context.
 func (ls *LState) RemoveContext() context.Context {
 	oldctx := ls.ctx
-	ls.mainLoop = mainLoop
 	ls.ctx = nil
+	ls.updateMainLoop()
 	return oldctx
 }
 
@@ -2284,6 +2496,22 @@ func (ls *LState) ToChannel(n int) chan LValue {
 	return nil
 }
 
+// SetInstructionLimit sets the maximum number of VM instructions this LState and threads created from it can
+// execute and resets the instruction counter. When the limit is exceeded, the running script is
+// interrupted with a Lua error and the ApiError returned to Go has the type ApiErrorInstructionLimit.
+// Lua code can catch the error with pcall, but every following instruction fails again until
+// SetInstructionLimit is called again. A limit of 0 removes the limit.
+func (ls *LState) SetInstructionLimit(limit int) {
+	ls.G.instLimit = int64(limit)
+	ls.G.instCount = 0
+	ls.updateMainLoop()
+}
+
+// InstructionCount returns the number of VM instructions counted since the last call of SetInstructionLimit.
+func (ls *LState) InstructionCount() int {
+	return int(ls.G.instCount)
+}
+
 // RemoveCallerFrame removes the stack frame above the current stack frame. This is useful in tail calls. It returns
 // the new current frame.
 func (ls *LState) RemoveCallerFrame() *callFrame {
@@ -2,6 +2,7 @@ package lua
 
 import (
 	"context"
+	"runtime"
 	"strings"
 	"testing"
 	"time"
@@ -326,6 +327,41 @@ func TestPCall(t *testing.T) {
 	errorIfFalse(t, L.stack.Sp() == currentSp, "")
 }
 
+func TestPCallErrorObject(t *testing.T) {
+	L := NewState()
+	defer L.Close()
+	errorIfScriptFail(t, L, `
+      function f(code)
+        error({code = code})
+      end
+    `)
+	var received LValue
+	L.Push(L.GetGlobal("f"))
+	L.Push(LNumber(42))
+	err := L.PCall(1, 0, L.NewFunction(func(L *LState) int {
+		received = L.Get(1)
+		L.Push(L.GetField(received, "code"))
+		return 1
+	}))
+	tb, ok := received.(*LTable)
+	errorIfFalse(t, ok, "handler should receive the error table, got %v", received)
+	errorIfNotEqual(t, LNumber(42), tb.RawGetString("code"))
+	errorIfNotEqual(t, LNumber(42), err.(*ApiError).Object)
+
+	ud := L.NewUserData()
+	L.Push(L.NewFunction(func(L *LState) int {
+		L.Error(ud, 1)
+		return 0
+	}))
+	err = L.PCall(0, 0, L.NewFunction(func(L *LState) int {
+		received = L.Get(1)
+		L.Push(received)
+		return 1
+	}))
+	errorIfNotEqual(t, LValue(ud), received)
+	errorIfNotEqual(t, LValue(ud), err.(*ApiError).Object)
+}
+
 func TestCoroutineApi1(t *testing.T) {
 	L := NewState()
 	defer L.Close()
@@ -474,6 +510,46 @@ func TestContextWithCroutine(t *testing.T) {
 
 }
 
+func TestSetHook(t *testing.T) {
+	L := NewState()
+	defer L.Close()
+	events := []string{}
+	lines := []int{}
+	L.SetHook(func(L *LState, dbg *Debug) {
+		events = append(events, dbg.Event)
+		if dbg.Event == "line" {
+			lines = append(lines, dbg.CurrentLine)
+		}
+	}, HookCall|HookReturn|HookLine, 0)
+	fn, mask, count := L.GetHook()
+	errorIfNil(t, fn)
+	errorIfNotEqual(t, HookCall|HookReturn|HookLine, mask)
+	errorIfNotEqual(t, 0, count)
+	errorIfScriptFail(t, L, `local a = 1
+local b = 2`)
+	errorIfNotEqual(t, "call", events[0])
+	errorIfNotEqual(t, "return", events[len(events)-1])
+	errorIfFalse(t, len(lines) >= 2 && lines[0] == 1 && lines[1] == 2, "line hook must be called for each line")
+
+	L.SetHook(nil, 0, 0)
+	fn, _, _ = L.GetHook()
+	errorIfNotNil(t, fn)
+	events = events[:0]
+	errorIfScriptFail(t, L, `local a = 1`)
+	errorIfNotEqual(t, 0, len(events))
+}
+
+func TestSetHookCount(t *testing.T) {
+	L := NewState()
+	defer L.Close()
+	L.SetHook(func(L *LState, dbg *Debug) {
+		L.RaiseError("instruction count exceeded")
+	}, HookCount, 1000)
+	err := L.DoString(`while true do end`)
+	errorIfNil(t, err)
+	errorIfFalse(t, strings.Contains(err.Error(), "instruction count exceeded"), "execution must be interrupted by the hook")
+}
+
 func TestPCallAfterFail(t *testing.T) {
 	L := NewState()
 	defer L.Close()
@@ -510,7 +586,7 @@ func TestRegistryFixedOverflow(t *testing.T) {
 		rcv := recover()
 		if rcv != nil {
 			if expectedPanic {
-				errorIfFalse(t, rcv.(error).Error() != "registry overflow", "expected registry overflow exception, got "+rcv.(error).Error())
+				errorIfFalse(t, rcv.(error).Error() != "registry overflow", "expected registry overflow exception, got %v", rcv.(error).Error())
 			} else {
 				t.Errorf("did not expect registry overflow")
 			}
@@ -530,7 +606,7 @@ func TestRegistryAutoGrow(t *testing.T) {
 		rcv := recover()
 		if rcv != nil {
 			if expectedPanic {
-				errorIfFalse(t, rcv.(error).Error() != "registry overflow", "expected registry overflow exception, got "+rcv.(error).Error())
+				errorIfFalse(t, rcv.(error).Error() != "registry overflow", "expected registry overflow exception, got %v", rcv.(error).Error())
 			} else {
 				t.Errorf("did not expect registry overflow")
 			}
@@ -547,6 +623,19 @@ func TestRegistryAutoGrow(t *testing.T) {
 	reg.Push(test)
 }
 
+func TestErrorMessageIsNotFormatted(t *testing.T) {
+	L := NewState()
+	defer L.Close()
+	errorIfScriptNotFail(t, L, `error("100%d done")`, ":1: 100%d done\n")
+	errorIfScriptNotFail(t, L, `assert(false, "50%s")`, ":1: 50%s\n")
+	L.Push(L.NewFunction(func(L *LState) int {
+		L.Error(LString("%v%%"), 0)
+		return 0
+	}))
+	err := L.PCall(0, 0, nil)
+	errorIfNotEqual(t, LString("%v%%"), err.(*ApiError).Object)
+}
+
 // This test exposed a panic caused by accessing an unassigned var in the lua registry.
 // The panic was caused by initCallFrame. It was calling resize() on the registry after it had written some values
 // directly to the reg's array, but crucially, before it had updated "top". This meant when the resize occurred, the
@@ -760,3 +849,311 @@ func BenchmarkRegistrySetTop(t *testing.B) {
 		reg.SetTop(0)
 	}
 }
+
+func TestInstructionLimit(t *testing.T) {
+	L := NewState(Options{MaxInstructions: 1000})
+	defer L.Close()
+	err := L.DoString(`while true do end`)
+	errorIfNil(t, err)
+	aerr, ok := err.(*ApiError)
+	errorIfFalse(t, ok, "ApiError expected")
+	errorIfNotEqual(t, ApiErrorInstructionLimit, aerr.Type)
+	errorIfFalse(t, strings.Contains(err.Error(), "instruction limit exceeded"), "execution must be interrupted")
+
+	// catching the error in Lua does not allow to keep running
+	L.SetInstructionLimit(1000)
+	err = L.DoString(`
+	  local ok = pcall(function() while true do end end)
+	  ran_after_pcall = true
+	`)
+	errorIfNil(t, err)
+	errorIfNotEqual(t, ApiErrorInstructionLimit, err.(*ApiError).Type)
+	errorIfNotEqual(t, LNil, L.GetGlobal("ran_after_pcall"))
+
+	L.SetInstructionLimit(1000)
+	errorIfScriptFail(t, L, `local a = 0 for i = 1, 10 do a = a + i end`)
+	errorIfFalse(t, L.InstructionCount() > 0 && L.InstructionCount() < 1000, "instructions must be counted")
+
+	L.SetInstructionLimit(0)
+	errorIfScriptFail(t, L, `for i = 1, 10000 do end`)
+}
+
+func TestInstructionLimitWithCoroutine(t *testing.T) {
+	L := NewState()
+	defer L.Close()
+	errorIfScriptFail(t, L, `
+	  co = coroutine.wrap(function() while true do end end)
+	`)
+	L.SetInstructionLimit(1000)
+	err := L.DoString(`co()`)
+	errorIfNil(t, err)
+	errorIfFalse(t, strings.Contains(err.Error(), "instruction limit exceeded"), "coroutine execution must be interrupted")
+	errorIfNotEqual(t, ApiErrorInstructionLimit, err.(*ApiError).Type)
+
+	L.SetInstructionLimit(0)
+	errorIfScriptFail(t, L, `function loop() while true do end end`)
+	L.SetInstructionLimit(1000)
+	co, _ := L.NewThread()
+	_, err, _ = L.Resume(co, L.GetGlobal("loop").(*LFunction))
+	errorIfNil(t, err)
+	errorIfNotEqual(t, ApiErrorInstructionLimit, err.(*ApiError).Type)
+}
+
+func TestMemoryLimit(t *testing.T) {
+	L := NewState(Options{MemoryLimit: 1024 * 1024})
+	defer L.Close()
+	err := L.DoString(`
+	  local t = {}
+	  for i = 1, 10000000 do t[i] = {} end
+	`)
+	errorIfNil(t, err)
+	errorIfFalse(t, strings.Contains(err.Error(), "not enough memory"), "execution must be interrupted")
+	errorIfFalse(t, L.MemoryUsage() < 1024*1024, "memory must be released after the error")
+
+	// the error is recoverable
+	errorIfScriptFail(t, L, `
+	  local ok, msg = pcall(function()
+	    local s = "a"
+	    while true do s = s .. s end
+	  end)
+	  assert(not ok and string.find(msg, "not enough memory"))
+	  ok, msg = pcall(string.rep, "a", 1024 * 1024 * 2)
+	  assert(not ok and string.find(msg, "not enough memory"))
+	  x = {1, 2, 3}
+	`)
+	errorIfNotEqual(t, 3, L.GetGlobal("x").(*LTable).Len())
+
+	L.SetMemoryLimit(0)
+	errorIfScriptFail(t, L, `local s = string.rep("a", 1024 * 1024 * 2)`)
+	errorIfScriptNotFail(t, L, `string.rep("abc", 2^62)`, "resulting string too large")
+}
+
+func TestMemoryLimitStringFunctions(t *testing.T) {
+	L := NewState(Options{MemoryLimit: 1024 * 1024})
+	defer L.Close()
+	errorIfScriptFail(t, L, `s = string.rep("a", 1000)`)
+	for _, expr := range []string{
+		`string.format("%s%d", s, i)`,
+		`string.gsub(s, "^a", "b")`,
+		`string.upper(s .. i)`,
+		`string.reverse(s .. i)`,
+		`table.concat({s, i})`,
+	} {
+		errorIfScriptNotFail(t, L, `
+		  local t = {}
+		  for i = 1, 2000 do t[i] = `+expr+` end
+		`, "not enough memory")
+	}
+}
+
+func TestMemoryAccountMeasure(t *testing.T) {
+	m := &memoryAccount{}
+	m.setLimit(800)
+	m.add(700)
+	errorIfFalse(t, !m.exceeded(), "must not be exceeded")
+	m.add(200)
+	errorIfFalse(t, m.exceeded(), "must be measured at the limit")
+
+	// the next measurement is deferred while the measured usage is close to the limit
+	m.measured(790)
+	m.add(99)
+	errorIfFalse(t, !m.exceeded(), "must not be measured before limit/memoryMeasureStep bytes are allocated")
+	m.add(2)
+	errorIfFalse(t, m.exceeded(), "must be measured after limit/memoryMeasureStep bytes are allocated")
+
+	m.measured(100)
+	m.add(699)
+	errorIfFalse(t, !m.exceeded(), "must not be exceeded")
+}
+
+func TestMemoryUsage(t *testing.T) {
+	L := NewState()
+	defer L.Close()
+	before := L.MemoryUsage()
+	errorIfScriptFail(t, L, `
+	  t = {}
+	  for i = 1, 10000 do t[i] = tostring(i) end
+	`)
+	errorIfFalse(t, L.MemoryUsage() > before+10000*valueSize, "memory usage must increase")
+	errorIfScriptFail(t, L, `t = nil`)
+	errorIfFalse(t, L.MemoryUsage() < before+10000*valueSize, "memory usage must decrease")
+}
+
+func TestNewUserDataWithFinalizer(t *testing.T) {
+	L := NewState()
+	finalized := []string{}
+	for _, name := range []string{"a", "b"} {
+		ud := L.NewUserDataWithFinalizer(func(ud *LUserData) {
+			finalized = append(finalized, ud.Value.(string))
+		})
+		ud.Value = name
+		L.SetGlobal(name, ud)
+	}
+	errorIfScriptFail(t, L, `a = nil; collectgarbage()`)
+	errorIfNotEqual(t, "a", strings.Join(finalized, ","))
+
+	// remaining userdata are finalized in reverse order of creation on Close
+	ud := L.NewUserDataWithFinalizer(func(ud *LUserData) {
+		finalized = append(finalized, ud.Value.(string))
+	})
+	ud.Value = "c"
+	L.SetGlobal("c", ud)
+	L.Close()
+	errorIfNotEqual(t, "a,c,b", strings.Join(finalized, ","))
+
+	// userdata that refer to themselves are not collected until Close
+	L = NewState()
+	n := 0
+	ud = L.NewUserDataWithFinalizer(func(*LUserData) { n++ })
+	ud.Value = &struct{ ud *LUserData }{ud}
+	ud = nil
+	errorIfScriptFail(t, L, `collectgarbage()`)
+	errorIfNotEqual(t, 0, n)
+	L.Close()
+	errorIfNotEqual(t, 1, n)
+}
+
+func TestUserDataGcMetamethod(t *testing.T) {
+	L := NewState()
+	defer L.Close()
+	mt := L.NewTable()
+	L.SetField(mt, "__gc", L.NewFunction(func(L *LState) int {
+		L.SetGlobal("finalized", L.CheckUserData(1))
+		return 0
+	}))
+	ud := L.NewUserData()
+	L.SetMetatable(ud, mt)
+	ud = nil
+	errorIfScriptFail(t, L, `collectgarbage(); assert(type(finalized) == "userdata")`)
+}
+
+func TestCollectGarbageStop(t *testing.T) {
+	L := NewState()
+	defer L.Close()
+	n := 0
+	L.SetGlobal("newud", L.NewFunction(func(L *LState) int {
+		L.Push(L.NewUserDataWithFinalizer(func(*LUserData) { n++ }))
+		return 1
+	}))
+	errorIfScriptFail(t, L, `collectgarbage("stop"); newud()`)
+	runtime.GC()
+	time.Sleep(10 * time.Millisecond)
+	errorIfScriptFail(t, L, `assert(true)`)
+	errorIfNotEqual(t, 0, n)
+	errorIfScriptFail(t, L, `collectgarbage("restart"); collectgarbage()`)
+	errorIfNotEqual(t, 1, n)
+}
+
+func TestIntegerSubtype(t *testing.T) {
+	L := NewState(Options{IntegerSubtype: true})
+	defer L.Close()
+	errorIfScriptFail(t, L, `
+	  local n = 9007199254740993
+	  assert(math.type(n) == "integer" and math.type(1.0) == "float" and math.type("1") == nil)
+	  assert(tostring(n) == "9007199254740993")
+	  assert(tostring(n + 1) == "9007199254740994")
+	  assert(tostring(n * 2 - n) == "9007199254740993")
+	  assert(string.format("%d", n) == "9007199254740993")
+	  assert(tostring(-7 % 3) == "2" and 7 / 2 == 3.5 and math.type(2 ^ 2) == "float")
+	  assert(n > 9007199254740992.0 and 1 == 1.0 and 1 < 1.5)
+	  assert(math.maxinteger + 1 == math.mininteger)
+	  assert(math.tointeger(3.0) == 3 and math.type(math.tointeger(3.0)) == "integer")
+	  assert(math.tointeger(3.5) == nil)
+	  assert(math.type(tonumber("12")) == "integer" and math.type(tonumber("1.5")) == "float")
+	  assert(select(2, pcall(function() return 1 % 0 end)):find("attempt to perform 'n%0'", 1, true))
+	  assert(math.type(#"abc") == "integer" and math.type(#{1, 2}) == "integer")
+	  assert(math.type("10" + 1) == "integer" and math.type("1.5" + 1) == "float" and math.type(-"2") == "integer")
+	  assert(math.type(math.floor(3.7)) == "integer" and math.floor(3.7) == 3 and math.type(math.ceil(n)) == "integer")
+	  assert(math.type(math.floor(2^70)) == "float" and math.type(math.abs(-n)) == "integer" and math.abs(-n) == n)
+	  assert(math.max(1, n, 2.5) == n and math.type(math.max(1, 2.5)) == "float" and math.type(math.min(1, 2.5)) == "integer")
+	  assert(loadstring(string.dump(function() return 9007199254740993 end))() == n)
+	  assert(string.unpack("j", string.pack("j", n)) == n and math.type(string.unpack("B", "\1")) == "integer")
+	  assert(math.type(string.packsize("i4")) == "integer" and math.type(select(2, string.unpack("B", "\1"))) == "integer")
+	  assert(math.type(utf8.codepoint("a")) == "integer" and math.type(utf8.len("abc")) == "integer")
+	  assert(math.type(utf8.offset("abc", 2)) == "integer" and math.type(select(2, utf8.len("\255"))) == "integer")
+	  for p, c in utf8.codes("a") do assert(math.type(p) == "integer" and math.type(c) == "integer") end
+	  assert(not pcall(table.move, {}, math.mininteger, 1, 1))
+	  assert(table.move({"x"}, 1, 1, math.maxinteger)[math.maxinteger] == "x")
+
+	  local t = {}
+	  t[1] = "a"
+	  t[2.0] = "b"
+	  t[n] = "c"
+	  assert(t[1.0] == "a" and t[2] == "b" and #t == 2 and t[n] == "c" and t[n - 1] == nil)
+	  for i in ipairs(t) do assert(math.type(i) == "integer") end
+	  for k in pairs({"a", "b", x = 1}) do assert(k == "x" or math.type(k) == "integer") end
+	  assert(math.type(next({"a"})) == "integer" and math.type(next({"a", "b"}, 1)) == "integer")
+	  assert(math.type(next(setmetatable({"a"}, {__mode = "v"}))) == "integer")
+	  t = {[2^60] = "x", [100.0] = "y"}
+	  assert(t[1152921504606846976] == "x" and t[100] == "y")
+	  for k in pairs(t) do assert(math.type(k) == "integer") end
+
+	  local sum, last = 0, nil
+	  for i = 1, 10 do sum = sum + i; last = i end
+	  assert(sum == 55 and math.type(last) == "integer")
+	  for i = 1, 2, 0.5 do assert(math.type(i) == "float") end
+	  local count = 0
+	  for i = math.maxinteger - 2, math.maxinteger do count = count + 1 end
+	  assert(count == 3)
+	  assert(not pcall(function() for i = 1, 10, 0 do end end))
+	`)
+	L.Push(LString("9007199254740993"))
+	errorIfNotEqual(t, int64(9007199254740993), L.ToInt64(-1))
+	errorIfScriptFail(t, L, `x = 9007199254740993`)
+	errorIfNotEqual(t, LInteger(9007199254740993), L.GetGlobal("x"))
+
+	// integer literals are LNumbers by default
+	L2 := NewState()
+	defer L2.Close()
+	errorIfScriptFail(t, L2, `x = 9007199254740993; assert(math.type == nil)`)
+	errorIfNotEqual(t, LNumber(9007199254740992), L2.GetGlobal("x"))
+}
+
+func TestLua52Dialect(t *testing.T) {
+	L := NewState(Options{Dialect: Lua52})
+	defer L.Close()
+	errorIfScriptFail(t, L, `
+	  x = 1
+	  assert(_ENV.x == 1 and _ENV == _G)
+	  local function f() y = 2; return x end
+	  assert(f() == 1 and y == 2)
+	  do
+	    local _ENV = {assert = assert}
+	    z = 3
+	    assert(_ENV.z == 3)
+	  end
+	  assert(z == nil)
+
+	  local function sandbox(_ENV) return a + b end
+	  assert(sandbox({a = 1, b = 2}) == 3)
+
+	  local env = {}
+	  local chunk = load("v = 10; return v", "chunk", "t", env)
+	  assert(chunk() == 10 and env.v == 10 and v == nil)
+	  assert(not pcall(load("return w + 1", "chunk", "t", {})))
+	  local g = load(string.dump(function() return x end), "dumped", "b", {x = "dumped"})
+	  assert(g() == "dumped")
+	  local ok, msg = load("return 1", "chunk", "b")
+	  assert(not ok and string.find(msg, "attempt to load a text chunk"))
+	`)
+
+	// the dialect can be selected per chunk
+	L2 := NewState()
+	defer L2.Close()
+	fn, err := L2.LoadWithOptions(strings.NewReader(`local _ENV = {}; x = 1; return _ENV`), "<string>",
+		CompileOptions{Dialect: Lua52})
+	errorIfNotNil(t, err)
+	L2.Push(fn)
+	L2.Call(0, 1)
+	errorIfNotEqual(t, LNumber(1), L2.GetField(L2.Get(-1), "x"))
+	errorIfNotEqual(t, LNil, L2.GetGlobal("x"))
+	errorIfScriptFail(t, L2, `
+	  local _ENV = {}
+	  x = 1
+	  local env = {}
+	  local f = load("y = 2; return _ENV", "chunk", "t", env)
+	  assert(f() == nil and env.y == 2)
+	  assert(not pcall(load, "return 1", "chunk", "t", 1))
+	`)
+	errorIfNotEqual(t, LNumber(1), L2.GetGlobal("x"))
+}
@@ -2,6 +2,7 @@ package lua
 
 import (
 	"fmt"
+	"math"
 	"strings"
 
 	"github.com/yuin/gopher-lua/pm"
@@ -25,19 +26,22 @@ func OpenString(L *LState) int {
 }
 
 var strFuncs = map[string]LGFunction{
-	"byte":    strByte,
-	"char":    strChar,
-	"dump":    strDump,
-	"find":    strFind,
-	"format":  strFormat,
-	"gsub":    strGsub,
-	"len":     strLen,
-	"lower":   strLower,
-	"match":   strMatch,
-	"rep":     strRep,
-	"reverse": strReverse,
-	"sub":     strSub,
-	"upper":   strUpper,
+	"byte":     strByte,
+	"char":     strChar,
+	"dump":     strDump,
+	"find":     strFind,
+	"format":   strFormat,
+	"gsub":     strGsub,
+	"len":      strLen,
+	"lower":    strLower,
+	"match":    strMatch,
+	"pack":     strPack,
+	"packsize": strPackSize,
+	"rep":      strRep,
+	"reverse":  strReverse,
+	"sub":      strSub,
+	"unpack":   strUnpack,
+	"upper":    strUpper,
 }
 
 func strByte(L *LState) int {
@@ -78,13 +82,23 @@ func strChar(L *LState) int {
 	for i := 1; i <= top; i++ {
 		bytes[i-1] = uint8(L.CheckInt(i))
 	}
+	L.allocString(len(bytes))
 	L.Push(LString(string(bytes)))
 	return 1
 }
 
 func strDump(L *LState) int {
-	L.RaiseError("GopherLua does not support the string.dump")
-	return 0
+	fn := L.CheckFunction(1)
+	if fn.IsG {
+		L.RaiseError("unable to dump given function")
+	}
+	data, err := fn.Proto.MarshalBinary()
+	if err != nil {
+		L.RaiseError("%s", err.Error())
+	}
+	L.allocString(len(data))
+	L.Push(LString(data))
+	return 1
 }
 
 func strFind(L *LState) int {
@@ -114,7 +128,7 @@ func strFind(L *LState) int {
 
 	mds, err := pm.Find(pattern, unsafeFastStringToReadOnlyBytes(str), init, 1)
 	if err != nil {
-		L.RaiseError(err.Error())
+		L.RaiseError("%s", err.Error())
 	}
 	if len(mds) == 0 {
 		L.Push(LNil)
@@ -133,15 +147,180 @@ func strFind(L *LState) int {
 	return md.CaptureLength()/2 + 1
 }
 
+// strFormatFlags is the set of flags accepted by string.format.
+const strFormatFlags = "-+ #0"
+
+// strFormatSpec is a conversion specification of string.format without the conversion character.
+type strFormatSpec struct {
+	flags   string
+	width   int
+	prec    int
+	hasPrec bool
+	form    string
+}
+
+// strScanFormat scans the flags, the width and the precision of a conversion specification that starts at
+// str[i]. It returns the specification and the index of the conversion character.
+func strScanFormat(L *LState, str string, i int) (*strFormatSpec, int) {
+	start := i
+	spec := &strFormatSpec{}
+	for i < len(str) && strings.IndexByte(strFormatFlags, str[i]) >= 0 {
+		i++
+	}
+	if i-start > len(strFormatFlags) {
+		L.RaiseError("invalid format (repeated flags)")
+	}
+	spec.flags = str[start:i]
+	digits := func() int {
+		n := 0
+		for j := 0; j < 2 && i < len(str) && '0' <= str[i] && str[i] <= '9'; j++ {
+			n = n*10 + int(str[i]-'0')
+			i++
+		}
+		return n
+	}
+	spec.width = digits()
+	if i < len(str) && str[i] == '.' {
+		i++
+		spec.hasPrec = true
+		spec.prec = digits()
+	}
+	if i < len(str) && '0' <= str[i] && str[i] <= '9' {
+		L.RaiseError("invalid format (width or precision too long)")
+	}
+	spec.form = "%" + str[start:i]
+	return spec, i
+}
+
+// pad writes s to buf, padded with spaces to the width of the specification.
+func (spec *strFormatSpec) pad(buf *strings.Builder, s string) {
+	if len(s) >= spec.width {
+		buf.WriteString(s)
+		return
+	}
+	padding := strings.Repeat(" ", spec.width-len(s))
+	if strings.IndexByte(spec.flags, '-') >= 0 {
+		buf.WriteString(s)
+		buf.WriteString(padding)
+	} else {
+		buf.WriteString(padding)
+		buf.WriteString(s)
+	}
+}
+
+func strFormatInt(L *LState, n int) int64 {
+	if iv, ok := L.Get(n).(LInteger); ok {
+		return int64(iv)
+	}
+	return int64(L.CheckNumber(n))
+}
+
+func strFormatFloat(buf *strings.Builder, spec *strFormatSpec, verb byte, f float64) {
+	if math.IsInf(f, 0) || math.IsNaN(f) {
+		var s string
+		switch {
+		case math.IsNaN(f):
+			s = "nan"
+		case f < 0:
+			s = "-inf"
+		case strings.IndexByte(spec.flags, '+') >= 0:
+			s = "+inf"
+		case strings.IndexByte(spec.flags, ' ') >= 0:
+			s = " inf"
+		default:
+			s = "inf"
+		}
+		if verb == 'E' || verb == 'F' || verb == 'G' {
+			s = strings.ToUpper(s)
+		}
+		spec.pad(buf, s)
+		return
+	}
+	form := spec.form
+	if !spec.hasPrec {
+		// the default precision of C printf, which differs from the shortest representation of Go for %g
+		form += ".6"
+	}
+	fmt.Fprintf(buf, form+string(verb), f)
+}
+
+func strFormatQuoted(buf *strings.Builder, s string) {
+	buf.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '"', '\\', '\n':
+			buf.WriteByte('\\')
+			buf.WriteByte(c)
+		case '\r':
+			buf.WriteString("\\r")
+		case 0:
+			buf.WriteString("\\000")
+		default:
+			buf.WriteByte(c)
+		}
+	}
+	buf.WriteByte('"')
+}
+
 func strFormat(L *LState) int {
 	str := L.CheckString(1)
-	args := make([]interface{}, L.GetTop()-1)
 	top := L.GetTop()
-	for i := 2; i <= top; i++ {
-		args[i-2] = L.Get(i)
+	arg := 1
+	var buf strings.Builder
+	for i := 0; i < len(str); i++ {
+		if str[i] != '%' {
+			buf.WriteByte(str[i])
+			continue
+		}
+		i++
+		if i < len(str) && str[i] == '%' {
+			buf.WriteByte('%')
+			continue
+		}
+		arg++
+		if arg > top {
+			L.ArgError(arg, "no value")
+		}
+		spec, next := strScanFormat(L, str, i)
+		i = next
+		if i >= len(str) {
+			L.RaiseError("invalid option '%s' to 'format'", spec.form)
+		}
+		switch verb := str[i]; verb {
+		case 'c':
+			spec.pad(&buf, string([]byte{byte(strFormatInt(L, arg))}))
+		case 'd', 'i':
+			fmt.Fprintf(&buf, spec.form+"d", strFormatInt(L, arg))
+		case 'u':
+			fmt.Fprintf(&buf, spec.form+"d", uint64(strFormatInt(L, arg)))
+		case 'o', 'x', 'X':
+			fmt.Fprintf(&buf, spec.form+string(verb), uint64(strFormatInt(L, arg)))
+		case 'e', 'E', 'f', 'F', 'g', 'G':
+			strFormatFloat(&buf, spec, verb, float64(L.CheckNumber(arg)))
+		case 'q':
+			strFormatQuoted(&buf, L.CheckString(arg))
+		case 's':
+			s := L.ToStringMeta(L.Get(arg)).String()
+			switch {
+			case len(spec.form) == 1:
+				buf.WriteString(s)
+			case strings.IndexByte(s, 0) >= 0:
+				L.ArgError(arg, "string contains zeros")
+			case !spec.hasPrec && len(s) >= 100:
+				// no precision and the string is too long to be formatted
+				buf.WriteString(s)
+			default:
+				if spec.hasPrec && len(s) > spec.prec {
+					s = s[:spec.prec]
+				}
+				spec.pad(&buf, s)
+			}
+		default:
+			L.RaiseError("invalid option '%%%c' to 'format'", verb)
+		}
 	}
-	npat := strings.Count(str, "%") - strings.Count(str, "%%")
-	L.Push(LString(fmt.Sprintf(str, args[:intMin(npat, len(args))]...)))
+	L.allocString(buf.Len())
+	L.Push(LString(buf.String()))
 	return 1
 }
 
@@ -154,21 +333,24 @@ func strGsub(L *LState) int {
 
 	mds, err := pm.Find(pat, unsafeFastStringToReadOnlyBytes(str), 0, limit)
 	if err != nil {
-		L.RaiseError(err.Error())
+		L.RaiseError("%s", err.Error())
 	}
 	if len(mds) == 0 {
 		L.SetTop(1)
 		L.Push(LNumber(0))
 		return 2
 	}
+	var ret string
 	switch lv := repl.(type) {
 	case LString:
-		L.Push(LString(strGsubStr(L, str, string(lv), mds)))
+		ret = strGsubStr(L, str, string(lv), mds)
 	case *LTable:
-		L.Push(LString(strGsubTable(L, str, lv, mds)))
+		ret = strGsubTable(L, str, lv, mds)
 	case *LFunction:
-		L.Push(LString(strGsubFunc(L, str, lv, mds)))
+		ret = strGsubFunc(L, str, lv, mds)
 	}
+	L.allocString(len(ret))
+	L.Push(LString(ret))
 	L.Push(LNumber(len(mds)))
 	return 2
 }
@@ -329,7 +511,7 @@ func strGmatch(L *LState) int {
 	pattern := L.CheckString(2)
 	mds, err := pm.Find(pattern, []byte(str), 0, -1)
 	if err != nil {
-		L.RaiseError(err.Error())
+		L.RaiseError("%s", err.Error())
 	}
 	L.Push(L.Get(UpvalueIndex(1)))
 	ud := L.NewUserData()
@@ -346,6 +528,7 @@ func strLen(L *LState) int {
 
 func strLower(L *LState) int {
 	str := L.CheckString(1)
+	L.allocString(len(str))
 	L.Push(LString(strings.ToLower(str)))
 	return 1
 }
@@ -365,7 +548,7 @@ func strMatch(L *LState) int {
 
 	mds, err := pm.Find(pattern, unsafeFastStringToReadOnlyBytes(str), offset, 1)
 	if err != nil {
-		L.RaiseError(err.Error())
+		L.RaiseError("%s", err.Error())
 	}
 	if len(mds) == 0 {
 		L.Push(LNil)
@@ -395,6 +578,10 @@ func strRep(L *LState) int {
 	if n < 0 {
 		L.Push(emptyLString)
 	} else {
+		if len(str) > 0 && n > (math.MaxInt-stringSize)/len(str) {
+			L.RaiseError("resulting string too large")
+		}
+		L.allocString(len(str) * n)
 		L.Push(LString(strings.Repeat(str, n)))
 	}
 	return 1
@@ -402,6 +589,7 @@ func strRep(L *LState) int {
 
 func strReverse(L *LState) int {
 	str := L.CheckString(1)
+	L.allocString(len(str))
 	bts := []byte(str)
 	out := make([]byte, len(bts))
 	for i, j := 0, len(bts)-1; j >= 0; i, j = i+1, j-1 {
@@ -426,10 +614,352 @@ func strSub(L *LState) int {
 
 func strUpper(L *LState) int {
 	str := L.CheckString(1)
+	L.allocString(len(str))
 	L.Push(LString(strings.ToUpper(str)))
 	return 1
 }
 
+// packOption is the kind of an option of the format strings used by string.pack, string.unpack and
+// string.packsize.
+type packOption int
+
+const (
+	packInt       packOption = iota // signed integers
+	packUint                        // unsigned integers
+	packFloat                       // floating-point numbers
+	packChar                        // fixed-length strings
+	packString                      // strings with prefixed length
+	packZstr                        // zero-terminated strings
+	packPadding                     // padding
+	packPaddAlign                   // padding for alignment
+	packNop                         // no-op (configuration or spaces)
+)
+
+const (
+	// packMaxIntSize is the maximum size of integers in the format strings.
+	packMaxIntSize = 16
+	// packMaxAlign is the default maximum alignment for the '!' option.
+	packMaxAlign = 8
+	// packIntSize is the size of integers that can be represented by Lua values.
+	packIntSize = 8
+)
+
+// packFormat reads a format string of string.pack, string.unpack and string.packsize.
+type packFormat struct {
+	L         *LState
+	fmt       string
+	pos       int
+	isLittle  bool
+	maxAlign  int
+	totalSize int
+}
+
+func newPackFormat(L *LState, fmt string) *packFormat {
+	return &packFormat{L: L, fmt: fmt, isLittle: true, maxAlign: 1}
+}
+
+func (pf *packFormat) more() bool {
+	return pf.pos < len(pf.fmt)
+}
+
+func (pf *packFormat) digit() bool {
+	return pf.more() && '0' <= pf.fmt[pf.pos] && pf.fmt[pf.pos] <= '9'
+}
+
+func (pf *packFormat) num(df int) int {
+	if !pf.digit() {
+		return df
+	}
+	a := 0
+	for pf.digit() {
+		if a > (math.MaxInt32-9)/10 {
+			pf.L.RaiseError("integral size out of limits")
+		}
+		a = a*10 + int(pf.fmt[pf.pos]-'0')
+		pf.pos++
+	}
+	return a
+}
+
+func (pf *packFormat) numLimit(df int) int {
+	sz := pf.num(df)
+	if sz > packMaxIntSize || sz <= 0 {
+		pf.L.RaiseError("integral size (%d) out of limits [1,%d]", sz, packMaxIntSize)
+	}
+	return sz
+}
+
+// option reads an option and returns its kind and its size.
+func (pf *packFormat) option() (packOption, int) {
+	opt := pf.fmt[pf.pos]
+	pf.pos++
+	switch opt {
+	case 'b':
+		return packInt, 1
+	case 'B':
+		return packUint, 1
+	case 'h':
+		return packInt, 2
+	case 'H':
+		return packUint, 2
+	case 'l', 'j':
+		return packInt, 8
+	case 'L', 'J', 'T':
+		return packUint, 8
+	case 'f':
+		return packFloat, 4
+	case 'd', 'n':
+		return packFloat, 8
+	case 'i':
+		return packInt, pf.numLimit(4)
+	case 'I':
+		return packUint, pf.numLimit(4)
+	case 's':
+		return packString, pf.numLimit(8)
+	case 'c':
+		size := pf.num(-1)
+		if size == -1 {
+			pf.L.RaiseError("missing size for format option 'c'")
+		}
+		return packChar, size
+	case 'z':
+		return packZstr, 0
+	case 'x':
+		return packPadding, 1
+	case 'X':
+		return packPaddAlign, 0
+	case ' ':
+	case '<', '=':
+		pf.isLittle = true
+	case '>':
+		pf.isLittle = false
+	case '!':
+		pf.maxAlign = pf.numLimit(packMaxAlign)
+	default:
+		pf.L.RaiseError("invalid format option '%c'", opt)
+	}
+	return packNop, 0
+}
+
+// details reads an option and returns its kind, its size and the number of padding bytes needed to align it.
+func (pf *packFormat) details() (packOption, int, int) {
+	opt, size := pf.option()
+	align := size
+	if opt == packPaddAlign {
+		if !pf.more() {
+			pf.L.ArgError(1, "invalid next option for option 'X'")
+		}
+		var nextopt packOption
+		nextopt, align = pf.option()
+		if nextopt == packChar || align == 0 {
+			pf.L.ArgError(1, "invalid next option for option 'X'")
+		}
+	}
+	if align <= 1 || opt == packChar {
+		return opt, size, 0
+	}
+	if align > pf.maxAlign {
+		align = pf.maxAlign
+	}
+	if align&(align-1) != 0 {
+		pf.L.ArgError(1, "format asks for alignment not power of 2")
+	}
+	return opt, size, (align - pf.totalSize&(align-1)) & (align - 1)
+}
+
+func packInteger(buf []byte, n uint64, isLittle bool, size int, neg bool) []byte {
+	b := make([]byte, size)
+	for i := 0; i < size; i++ {
+		var c byte
+		switch {
+		case i < packIntSize:
+			c = byte(n >> uint(i*8))
+		case neg:
+			c = 0xff
+		}
+		if isLittle {
+			b[i] = c
+		} else {
+			b[size-1-i] = c
+		}
+	}
+	return append(buf, b...)
+}
+
+func unpackInteger(L *LState, data string, isLittle bool, size int, signed bool) int64 {
+	at := func(i int) byte {
+		if isLittle {
+			return data[i]
+		}
+		return data[size-1-i]
+	}
+	var res uint64
+	limit := intMin(size, packIntSize)
+	for i := limit - 1; i >= 0; i-- {
+		res = res<<8 | uint64(at(i))
+	}
+	if size < packIntSize {
+		if signed {
+			mask := uint64(1) << uint(size*8-1)
+			res = (res ^ mask) - mask
+		}
+	} else if size > packIntSize {
+		var mask byte
+		if signed && int64(res) < 0 {
+			mask = 0xff
+		}
+		for i := limit; i < size; i++ {
+			if at(i) != mask {
+				L.RaiseError("%d-byte integer does not fit into Lua Integer", size)
+			}
+		}
+	}
+	return int64(res)
+}
+
+func strPack(L *LState) int {
+	pf := newPackFormat(L, L.CheckString(1))
+	buf := make([]byte, 0, 32)
+	arg := 1
+	for pf.more() {
+		opt, size, ntoalign := pf.details()
+		pf.totalSize += ntoalign + size
+		for ; ntoalign > 0; ntoalign-- {
+			buf = append(buf, 0)
+		}
+		arg++
+		switch opt {
+		case packInt:
+			n := L.checkInteger(arg)
+			if size < packIntSize {
+				lim := int64(1) << uint(size*8-1)
+				if n < -lim || n >= lim {
+					L.ArgError(arg, "integer overflow")
+				}
+			}
+			buf = packInteger(buf, uint64(n), pf.isLittle, size, n < 0)
+		case packUint:
+			n := L.checkInteger(arg)
+			if size < packIntSize && uint64(n) >= uint64(1)<<uint(size*8) {
+				L.ArgError(arg, "unsigned overflow")
+			}
+			buf = packInteger(buf, uint64(n), pf.isLittle, size, false)
+		case packFloat:
+			n := L.CheckNumber(arg)
+			if size == 4 {
+				buf = packInteger(buf, uint64(math.Float32bits(float32(n))), pf.isLittle, size, false)
+			} else {
+				buf = packInteger(buf, math.Float64bits(float64(n)), pf.isLittle, size, false)
+			}
+		case packChar:
+			s := L.CheckString(arg)
+			if len(s) > size {
+				L.ArgError(arg, "string longer than given size")
+			}
+			buf = append(buf, s...)
+			for i := len(s); i < size; i++ {
+				buf = append(buf, 0)
+			}
+		case packString:
+			s := L.CheckString(arg)
+			if size < packIntSize && uint64(len(s)) >= uint64(1)<<uint(size*8) {
+				L.ArgError(arg, "string length does not fit in given size")
+			}
+			buf = packInteger(buf, uint64(len(s)), pf.isLittle, size, false)
+			buf = append(buf, s...)
+			pf.totalSize += len(s)
+		case packZstr:
+			s := L.CheckString(arg)
+			if strings.IndexByte(s, 0) >= 0 {
+				L.ArgError(arg, "string contains zeros")
+			}
+			buf = append(buf, s...)
+			buf = append(buf, 0)
+			pf.totalSize += len(s) + 1
+		case packPadding:
+			buf = append(buf, 0)
+			arg--
+		default:
+			arg--
+		}
+	}
+	L.allocString(len(buf))
+	L.Push(LString(buf))
+	return 1
+}
+
+func strPackSize(L *LState) int {
+	pf := newPackFormat(L, L.CheckString(1))
+	for pf.more() {
+		opt, size, ntoalign := pf.details()
+		size += ntoalign
+		if pf.totalSize > math.MaxInt32-size {
+			L.ArgError(1, "format result too large")
+		}
+		pf.totalSize += size
+		if opt == packString || opt == packZstr {
+			L.ArgError(1, "variable-length format")
+		}
+	}
+	L.Push(integerValue(L, int64(pf.totalSize)))
+	return 1
+}
+
+func strUnpack(L *LState) int {
+	pf := newPackFormat(L, L.CheckString(1))
+	data := L.CheckString(2)
+	pos := L.OptInt(3, 1)
+	if pos < 0 {
+		pos = intMax(len(data)+pos+1, 0)
+	}
+	pos--
+	if pos < 0 || pos > len(data) {
+		L.ArgError(3, "initial position out of string")
+	}
+	n := 0
+	for pf.more() {
+		pf.totalSize = pos
+		opt, size, ntoalign := pf.details()
+		if ntoalign+size > len(data)-pos {
+			L.ArgError(2, "data string too short")
+		}
+		pos += ntoalign
+		n++
+		switch opt {
+		case packInt, packUint:
+			L.Push(integerValue(L, unpackInteger(L, data[pos:], pf.isLittle, size, opt == packInt)))
+		case packFloat:
+			v := uint64(unpackInteger(L, data[pos:], pf.isLittle, size, false))
+			if size == 4 {
+				L.Push(LNumber(math.Float32frombits(uint32(v))))
+			} else {
+				L.Push(LNumber(math.Float64frombits(v)))
+			}
+		case packChar:
+			L.Push(LString(data[pos : pos+size]))
+		case packString:
+			length := uint64(unpackInteger(L, data[pos:], pf.isLittle, size, false))
+			if length > uint64(len(data)-pos-size) {
+				L.ArgError(2, "data string too short")
+			}
+			L.Push(LString(data[pos+size : pos+size+int(length)]))
+			pos += int(length)
+		case packZstr:
+			length := strings.IndexByte(data[pos:], 0)
+			if length < 0 {
+				L.ArgError(2, "unfinished string for format 'z'")
+			}
+			L.Push(LString(data[pos : pos+length]))
+			pos += length + 1
+		default:
+			n--
+		}
+		pos += size
+	}
+	L.Push(integerValue(L, int64(pos+1)))
+	return n + 1
+}
+
 func luaIndex2StringIndex(str string, i int, start bool) int {
 	if start && i != 0 {
 		i -= 1
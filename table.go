@@ -1,5 +1,9 @@
 package lua
 
+import (
+	"iter"
+)
+
 const defaultArrayCap = 32
 const defaultHashCap = 32
 
@@ -46,6 +50,26 @@ func newLTable(acap int, hcap int) *LTable {
 	return tb
 }
 
+// arrayKey returns the key of the i-th element of the array part.
+func (tb *LTable) arrayKey(i int) LValue {
+	if tb.intKeys {
+		return LInteger(i)
+	}
+	return LNumber(i)
+}
+
+// dictKey returns a key of the hash part. Integral LNumber keys are LIntegers in iterations if tb.intKeys is true.
+func (tb *LTable) dictKey(key LValue) LValue {
+	if tb.intKeys {
+		if v, ok := key.(LNumber); ok {
+			if iv, ok := floatToInteger(v); ok {
+				return iv
+			}
+		}
+	}
+	return key
+}
+
 // Len returns length of this LTable without using __len.
 func (tb *LTable) Len() int {
 	if tb.array == nil {
@@ -54,6 +78,9 @@ func (tb *LTable) Len() int {
 	var prev LValue = LNil
 	for i := len(tb.array) - 1; i >= 0; i-- {
 		v := tb.array[i]
+		if tb.weak != 0 {
+			v = strong(v)
+		}
 		if prev == LNil && v != LNil {
 			return i + 1
 		}
@@ -70,8 +97,10 @@ func (tb *LTable) Append(value LValue) {
 	if tb.array == nil {
 		tb.array = make([]LValue, 0, defaultArrayCap)
 	}
+	value = tb.weakValue(value)
 	if len(tb.array) == 0 || tb.array[len(tb.array)-1] != LNil {
 		tb.array = append(tb.array, value)
+		tb.mem.add(valueSize)
 	} else {
 		i := len(tb.array) - 2
 		for ; i >= 0; i-- {
@@ -98,8 +127,9 @@ func (tb *LTable) Insert(i int, value LValue) {
 	}
 	i -= 1
 	tb.array = append(tb.array, LNil)
+	tb.mem.add(valueSize)
 	copy(tb.array[i+1:], tb.array[i:])
-	tb.array[i] = value
+	tb.array[i] = tb.weakValue(value)
 }
 
 // MaxN returns a maximum number key that nil value does not exist before it.
@@ -108,7 +138,7 @@ func (tb *LTable) MaxN() int {
 		return 0
 	}
 	for i := len(tb.array) - 1; i >= 0; i-- {
-		if tb.array[i] != LNil {
+		if v := tb.array[i]; v != LNil && (tb.weak == 0 || strong(v) != LNil) {
 			return i + 1
 		}
 	}
@@ -138,6 +168,9 @@ func (tb *LTable) Remove(pos int) LValue {
 		tb.array[larray-1] = nil
 		tb.array = tb.array[:larray-1]
 	}
+	if tb.weak != 0 {
+		return strong(oldval)
+	}
 	return oldval
 }
 
@@ -151,16 +184,21 @@ func (tb *LTable) RawSet(key LValue, value LValue) {
 			if tb.array == nil {
 				tb.array = make([]LValue, 0, defaultArrayCap)
 			}
+			if tb.weak != 0 {
+				value = tb.weakValue(value)
+			}
 			index := int(v) - 1
 			alen := len(tb.array)
 			switch {
 			case index == alen:
 				tb.array = append(tb.array, value)
+				tb.mem.add(valueSize)
 			case index > alen:
 				for i := 0; i < (index - alen); i++ {
 					tb.array = append(tb.array, LNil)
 				}
 				tb.array = append(tb.array, value)
+				tb.mem.add((index - alen + 1) * valueSize)
 			case index < alen:
 				tb.array[index] = value
 			}
@@ -169,6 +207,11 @@ func (tb *LTable) RawSet(key LValue, value LValue) {
 	case LString:
 		tb.RawSetString(string(v), value)
 		return
+	case LInteger:
+		if nv, ok := normalizeKey(v).(LNumber); ok {
+			tb.RawSet(nv, value)
+			return
+		}
 	}
 
 	tb.RawSetH(key, value)
@@ -183,16 +226,21 @@ func (tb *LTable) RawSetInt(key int, value LValue) {
 	if tb.array == nil {
 		tb.array = make([]LValue, 0, 32)
 	}
+	if tb.weak != 0 {
+		value = tb.weakValue(value)
+	}
 	index := key - 1
 	alen := len(tb.array)
 	switch {
 	case index == alen:
 		tb.array = append(tb.array, value)
+		tb.mem.add(valueSize)
 	case index > alen:
 		for i := 0; i < (index - alen); i++ {
 			tb.array = append(tb.array, LNil)
 		}
 		tb.array = append(tb.array, value)
+		tb.mem.add((index - alen + 1) * valueSize)
 	case index < alen:
 		tb.array[index] = value
 	}
@@ -212,11 +260,18 @@ func (tb *LTable) RawSetString(key string, value LValue) {
 		// TODO tb.keys and tb.k2i should also be removed
 		delete(tb.strdict, key)
 	} else {
+		if tb.weak != 0 {
+			value = tb.weakValue(value)
+		}
 		tb.strdict[key] = value
 		lkey := LString(key)
 		if _, ok := tb.k2i[lkey]; !ok {
 			tb.k2i[lkey] = len(tb.keys)
 			tb.keys = append(tb.keys, lkey)
+			tb.mem.add(hashEntrySize + len(key))
+			if tb.needsSweep() {
+				tb.sweepWeak()
+			}
 		}
 	}
 }
@@ -227,6 +282,7 @@ func (tb *LTable) RawSetH(key LValue, value LValue) {
 		tb.RawSetString(string(s), value)
 		return
 	}
+	key = normalizeKey(key)
 	if tb.dict == nil {
 		tb.dict = make(map[LValue]LValue, len(tb.strdict))
 	}
@@ -235,20 +291,37 @@ func (tb *LTable) RawSetH(key LValue, value LValue) {
 		tb.k2i = map[LValue]int{}
 	}
 
+	if tb.weak != 0 {
+		key = tb.weakKey(key)
+	}
 	if value == LNil {
 		// TODO tb.keys and tb.k2i should also be removed
 		delete(tb.dict, key)
 	} else {
+		if tb.weak != 0 {
+			value = tb.weakValue(value)
+		}
 		tb.dict[key] = value
 		if _, ok := tb.k2i[key]; !ok {
 			tb.k2i[key] = len(tb.keys)
 			tb.keys = append(tb.keys, key)
+			tb.mem.add(hashEntrySize)
+			if tb.needsSweep() {
+				tb.sweepWeak()
+			}
 		}
 	}
 }
 
 // RawGet returns an LValue associated with a given key without __index metamethod.
 func (tb *LTable) RawGet(key LValue) LValue {
+	if tb.weak != 0 {
+		return strong(tb.rawGet(tb.weakKey(key)))
+	}
+	return tb.rawGet(key)
+}
+
+func (tb *LTable) rawGet(key LValue) LValue {
 	switch v := key.(type) {
 	case LNumber:
 		if isArrayKey(v) {
@@ -269,6 +342,10 @@ func (tb *LTable) RawGet(key LValue) LValue {
 			return ret
 		}
 		return LNil
+	case LInteger:
+		if nv, ok := normalizeKey(v).(LNumber); ok {
+			return tb.rawGet(nv)
+		}
 	}
 	if tb.dict == nil {
 		return LNil
@@ -288,11 +365,21 @@ func (tb *LTable) RawGetInt(key int) LValue {
 	if index >= len(tb.array) || index < 0 {
 		return LNil
 	}
+	if tb.weak != 0 {
+		return strong(tb.array[index])
+	}
 	return tb.array[index]
 }
 
 // RawGet returns an LValue associated with a given key without __index metamethod.
 func (tb *LTable) RawGetH(key LValue) LValue {
+	if tb.weak != 0 {
+		return strong(tb.rawGetH(tb.weakKey(key)))
+	}
+	return tb.rawGetH(key)
+}
+
+func (tb *LTable) rawGetH(key LValue) LValue {
 	if s, sok := key.(LString); sok {
 		if tb.strdict == nil {
 			return LNil
@@ -305,7 +392,7 @@ func (tb *LTable) RawGetH(key LValue) LValue {
 	if tb.dict == nil {
 		return LNil
 	}
-	if v, ok := tb.dict[key]; ok {
+	if v, ok := tb.dict[normalizeKey(key)]; ok {
 		return v
 	}
 	return LNil
@@ -317,6 +404,9 @@ func (tb *LTable) RawGetString(key string) LValue {
 		return LNil
 	}
 	if v, vok := tb.strdict[string(key)]; vok {
+		if tb.weak != 0 {
+			return strong(v)
+		}
 		return v
 	}
 	return LNil
@@ -324,10 +414,14 @@ func (tb *LTable) RawGetString(key string) LValue {
 
 // ForEach iterates over this table of elements, yielding each in turn to a given function.
 func (tb *LTable) ForEach(cb func(LValue, LValue)) {
+	if tb.weak != 0 {
+		tb.forEachWeak(cb)
+		return
+	}
 	if tb.array != nil {
 		for i, v := range tb.array {
 			if v != LNil {
-				cb(LNumber(i+1), v)
+				cb(tb.arrayKey(i+1), v)
 			}
 		}
 	}
@@ -341,7 +435,63 @@ func (tb *LTable) ForEach(cb func(LValue, LValue)) {
 	if tb.dict != nil {
 		for k, v := range tb.dict {
 			if v != LNil {
-				cb(k, v)
+				cb(tb.dictKey(k), v)
+			}
+		}
+	}
+}
+
+func (tb *LTable) forEachWeak(cb func(LValue, LValue)) {
+	for i, v := range tb.array {
+		if v = strong(v); v != LNil {
+			cb(tb.arrayKey(i+1), v)
+		}
+	}
+	for k, v := range tb.strdict {
+		if v = strong(v); v != LNil {
+			cb(LString(k), v)
+		}
+	}
+	for k, v := range tb.dict {
+		if k, v = strong(k), strong(v); k != LNil && v != LNil {
+			cb(tb.dictKey(k), v)
+		}
+	}
+}
+
+// All returns an iterator over the key-value pairs of this table like ForEach. Integral number keys are
+// LIntegers if the table was created by an LState with Options.IntegerSubtype.
+//
+//	for key, value := range tb.All() {
+//		...
+//	}
+func (tb *LTable) All() iter.Seq2[LValue, LValue] {
+	return func(yield func(LValue, LValue) bool) {
+		for i, v := range tb.array {
+			if v = strong(v); v != LNil && !yield(tb.arrayKey(i+1), v) {
+				return
+			}
+		}
+		for k, v := range tb.strdict {
+			if v = strong(v); v != LNil && !yield(LString(k), v) {
+				return
+			}
+		}
+		for k, v := range tb.dict {
+			if k, v = strong(k), strong(v); k != LNil && v != LNil && !yield(tb.dictKey(k), v) {
+				return
+			}
+		}
+	}
+}
+
+// Array returns an iterator over the elements at 1, 2, ... up to the first nil element like ipairs.
+func (tb *LTable) Array() iter.Seq2[int, LValue] {
+	return func(yield func(int, LValue) bool) {
+		for i := 1; ; i++ {
+			v := tb.RawGetInt(i)
+			if v == LNil || !yield(i, v) {
+				return
 			}
 		}
 	}
@@ -349,7 +499,11 @@ func (tb *LTable) ForEach(cb func(LValue, LValue)) {
 
 // This function is equivalent to lua_next ( http://www.lua.org/manual/5.1/manual.html#lua_next ).
 func (tb *LTable) Next(key LValue) (LValue, LValue) {
+	if tb.weak != 0 {
+		return tb.nextWeak(key)
+	}
 	init := false
+	key = normalizeKey(key)
 	if key == LNil {
 		key = LNumber(0)
 		init = true
@@ -361,7 +515,7 @@ func (tb *LTable) Next(key LValue) (LValue, LValue) {
 			if tb.array != nil {
 				for ; index < len(tb.array); index++ {
 					if v := tb.array[index]; v != LNil {
-						return LNumber(index + 1), v
+						return tb.arrayKey(index + 1), v
 					}
 				}
 			}
@@ -371,7 +525,7 @@ func (tb *LTable) Next(key LValue) (LValue, LValue) {
 				}
 				key = tb.keys[0]
 				if v := tb.RawGetH(key); v != LNil {
-					return key, v
+					return tb.dictKey(key), v
 				}
 			}
 		}
@@ -380,7 +534,39 @@ func (tb *LTable) Next(key LValue) (LValue, LValue) {
 	for i := tb.k2i[key] + 1; i < len(tb.keys); i++ {
 		key := tb.keys[i]
 		if v := tb.RawGetH(key); v != LNil {
-			return key, v
+			return tb.dictKey(key), v
+		}
+	}
+	return LNil, LNil
+}
+
+// nextWeak is Next for weak tables. Entries whose key or value has been collected are skipped.
+func (tb *LTable) nextWeak(key LValue) (LValue, LValue) {
+	start := 0
+	key = normalizeKey(key)
+	if key != LNil {
+		if kv, ok := key.(LNumber); ok && isArrayKey(kv) && int(kv) <= len(tb.array) {
+			start = int(kv)
+		} else {
+			i, ok := tb.k2i[tb.weakKey(key)]
+			if !ok {
+				return LNil, LNil
+			}
+			start = len(tb.array) + i + 1
+		}
+	}
+	for i := start; i < len(tb.array); i++ {
+		if v := strong(tb.array[i]); v != LNil {
+			return tb.arrayKey(i + 1), v
+		}
+	}
+	if start < len(tb.array) {
+		start = len(tb.array)
+	}
+	for i := start - len(tb.array); i < len(tb.keys); i++ {
+		key := tb.keys[i]
+		if k, v := strong(key), strong(tb.rawGetH(key)); k != LNil && v != LNil {
+			return tb.dictKey(k), v
 		}
 	}
 	return LNil, LNil
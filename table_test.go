@@ -1,6 +1,7 @@
 package lua
 
 import (
+	"runtime"
 	"testing"
 )
 
@@ -231,3 +232,93 @@ func TestTableForEach(t *testing.T) {
 		}
 	})
 }
+
+func TestTableWeak(t *testing.T) {
+	tbl := newLTable(0, 0)
+	tbl.setWeakMode(weakKeys | weakValues)
+	keep := newLTable(0, 0)
+	tbl.RawSetH(keep, LTrue)
+	tbl.RawSetString("keep", keep)
+	for i := 0; i < 200; i++ {
+		tbl.RawSetH(newLTable(0, 0), LNumber(i))
+	}
+	runtime.GC()
+	tbl.sweepWeak()
+	errorIfNotEqual(t, 2, len(tbl.keys))
+	errorIfNotEqual(t, LTrue, tbl.RawGetH(keep))
+	errorIfNotEqual(t, keep, tbl.RawGetString("keep"))
+	n := 0
+	tbl.ForEach(func(k, v LValue) { n++ })
+	errorIfNotEqual(t, 2, n)
+
+	tbl.setWeakMode(0)
+	errorIfNotEqual(t, LTrue, tbl.dict[keep])
+	errorIfNotEqual(t, keep, tbl.strdict["keep"])
+	runtime.KeepAlive(keep)
+}
+
+func TestTableAll(t *testing.T) {
+	tbl := newLTable(0, 0)
+	tbl.Append(LNumber(1))
+	tbl.Append(LNumber(2))
+	tbl.RawSetString("a", LString("a"))
+	tbl.RawSetH(LTrue, LString("true"))
+
+	pairs := map[LValue]LValue{}
+	for k, v := range tbl.All() {
+		pairs[k] = v
+	}
+	errorIfNotEqual(t, 4, len(pairs))
+	errorIfNotEqual(t, LNumber(2), pairs[LNumber(2)])
+	errorIfNotEqual(t, LString("a"), pairs[LString("a")])
+	errorIfNotEqual(t, LString("true"), pairs[LTrue])
+
+	n := 0
+	for range tbl.All() {
+		n++
+		break
+	}
+	errorIfNotEqual(t, 1, n)
+
+	// array keys match the keys of scripts in integer subtype mode
+	L := NewState(Options{IntegerSubtype: true})
+	defer L.Close()
+	errorIfScriptFail(t, L, `t = {"a", "b"}`)
+	keys := []LValue{}
+	for k := range L.GetGlobal("t").(*LTable).All() {
+		keys = append(keys, k)
+	}
+	errorIfNotEqual(t, 2, len(keys))
+	errorIfNotEqual(t, LInteger(1), keys[0])
+	errorIfNotEqual(t, LInteger(2), keys[1])
+}
+
+func TestTableArray(t *testing.T) {
+	tbl := newLTable(0, 0)
+	tbl.RawSetInt(1, LString("a"))
+	tbl.RawSetInt(2, LString("b"))
+	tbl.RawSetInt(4, LString("d"))
+	tbl.RawSetString("x", LString("x"))
+
+	values := []LValue{}
+	for i, v := range tbl.Array() {
+		errorIfNotEqual(t, len(values)+1, i)
+		values = append(values, v)
+	}
+	errorIfNotEqual(t, 2, len(values))
+	errorIfNotEqual(t, LString("b"), values[1])
+}
+
+func TestTableMoveNil(t *testing.T) {
+	L := NewState()
+	defer L.Close()
+	tbl := L.NewTable()
+	tbl.RawSetInt(1, LString("a"))
+	L.SetGlobal("t", tbl)
+	errorIfScriptFail(t, L, `
+	  table.move({}, 1, 3, 100000, t)
+	  table.move({}, 1, 1, 1, t)
+	  assert(next(t) == nil)
+	`)
+	errorIfFalse(t, len(tbl.array) <= 1, "moving nils must not grow the array part: %d", len(tbl.array))
+}
@@ -1,6 +1,7 @@
 package lua
 
 import (
+	"math"
 	"sort"
 )
 
@@ -15,17 +16,32 @@ var tableFuncs = map[string]LGFunction{
 	"concat": tableConcat,
 	"insert": tableInsert,
 	"maxn":   tableMaxN,
+	"move":   tableMove,
+	"pack":   tablePack,
 	"remove": tableRemove,
 	"sort":   tableSort,
+	"unpack": baseUnpack,
 }
 
 func tableSort(L *LState) int {
 	tbl := L.CheckTable(1)
-	sorter := lValueArraySorter{L, nil, tbl.array}
+	values := tbl.array
+	if tbl.weak != 0 {
+		values = make([]LValue, len(tbl.array))
+		for i, v := range tbl.array {
+			values[i] = strong(v)
+		}
+	}
+	sorter := lValueArraySorter{L, nil, values}
 	if L.GetTop() != 1 {
 		sorter.Fn = L.CheckFunction(2)
 	}
 	sort.Sort(sorter)
+	if tbl.weak != 0 {
+		for i, v := range values {
+			tbl.RawSetInt(i+1, v)
+		}
+	}
 	return 0
 }
 
@@ -97,4 +113,67 @@ func tableInsert(L *LState) int {
 	return 0
 }
 
+func tablePack(L *LState) int {
+	n := L.GetTop()
+	tbl := L.CreateTable(n, 1)
+	for i := 1; i <= n; i++ {
+		tbl.RawSetInt(i, L.Get(i))
+	}
+	tbl.RawSetString("n", LNumber(n))
+	L.Push(tbl)
+	return 1
+}
+
+// tableMove copies a1[f..e] to a2[t..]. Elements are read and written with the __index and __newindex metamethods
+// like Lua 5.3.
+func tableMove(L *LState) int {
+	a1 := L.CheckTable(1)
+	f := L.checkInteger(2)
+	e := L.checkInteger(3)
+	t := L.checkInteger(4)
+	a2 := a1
+	if L.GetTop() >= 5 {
+		a2 = L.CheckTable(5)
+	}
+	if e >= f {
+		if f <= 0 && e >= math.MaxInt64+f {
+			L.ArgError(3, "too many elements to move")
+		}
+		n := e - f
+		if t > math.MaxInt64-n {
+			L.ArgError(4, "destination wrap around")
+		}
+		switch {
+		case a1.Metatable == LNil && a2.Metatable == LNil && a1.weak == 0 && a2.weak == 0 &&
+			f >= 1 && e <= int64(len(a1.array)) && t >= 1 && t+n <= int64(len(a2.array)):
+			// both ranges are in the array parts
+			copy(a2.array[t-1:t+n], a1.array[f-1:e])
+		case t > e || t <= f || a1 != a2:
+			for i := int64(0); i <= n; i++ {
+				tableMoveElement(L, a1, f+i, a2, t+i)
+			}
+		default:
+			for i := n; i >= 0; i-- {
+				tableMoveElement(L, a1, f+i, a2, t+i)
+			}
+		}
+	}
+	L.Push(a2)
+	return 1
+}
+
+func tableMoveElement(L *LState, a1 *LTable, from int64, a2 *LTable, to int64) {
+	src, dst := integerValue(L, from), integerValue(L, to)
+	if a1.Metatable == LNil && a2.Metatable == LNil {
+		v := a1.RawGet(src)
+		if v == LNil && a2.RawGet(dst) == LNil {
+			// nothing to delete, and RawSet would pad the array part with nils
+			return
+		}
+		a2.RawSet(dst, v)
+		return
+	}
+	L.SetTable(a2, dst, L.GetTable(a1, src))
+}
+
 //
@@ -0,0 +1,225 @@
+package lua
+
+import (
+	"strings"
+)
+
+// maxUnicode is the largest code point accepted by the utf8 library.
+const maxUnicode = 0x10FFFF
+
+// utf8CharPattern is the pattern which matches exactly one UTF-8 byte sequence.
+const utf8CharPattern = "[\x00-\x7F\xC2-\xF4][\x80-\xBF]*"
+
+func OpenUtf8(L *LState) int {
+	mod := L.RegisterModule(Utf8LibName, utf8Funcs).(*LTable)
+	mod.RawSetString("charpattern", LString(utf8CharPattern))
+	L.Push(mod)
+	return 1
+}
+
+var utf8Funcs = map[string]LGFunction{
+	"char":      utf8Char,
+	"codes":     utf8Codes,
+	"codepoint": utf8Codepoint,
+	"len":       utf8Len,
+	"offset":    utf8Offset,
+}
+
+func utf8IsCont(s string, i int) bool {
+	return i < len(s) && s[i]&0xC0 == 0x80
+}
+
+// utf8Decode decodes the UTF-8 sequence at s[i:] like Lua 5.3. It returns the code point and the position
+// of the next sequence, or false if the sequence is invalid.
+func utf8Decode(s string, i int) (rune, int, bool) {
+	limits := [...]rune{0xFF, 0x7F, 0x7FF, 0xFFFF}
+	c := rune(s[i])
+	if c < 0x80 {
+		return c, i + 1, true
+	}
+	var res rune
+	count := 0
+	for ; c&0x40 != 0; c <<= 1 {
+		count++
+		if count > 3 || i+count >= len(s) || s[i+count]&0xC0 != 0x80 {
+			return 0, 0, false
+		}
+		res = res<<6 | rune(s[i+count]&0x3F)
+	}
+	res |= (c & 0x7F) << uint(count*5)
+	if count == 0 || res > maxUnicode || res <= limits[count] {
+		return 0, 0, false
+	}
+	return res, i + count + 1, true
+}
+
+// utf8Encode encodes a code point into a UTF-8 sequence. Unlike utf8.EncodeRune, surrogates are encoded as is.
+func utf8Encode(buf *strings.Builder, code rune) {
+	if code < 0x80 {
+		buf.WriteByte(byte(code))
+		return
+	}
+	var tmp [4]byte
+	n := len(tmp)
+	mfb := rune(0x3f) // maximum that fits in the first byte
+	for {
+		n--
+		tmp[n] = byte(0x80 | code&0x3f)
+		code >>= 6
+		mfb >>= 1
+		if code <= mfb {
+			break
+		}
+	}
+	n--
+	tmp[n] = byte(^mfb<<1 | code)
+	buf.Write(tmp[n:])
+}
+
+// utf8PosRelat converts a relative string position(negative means back from end) to an absolute one.
+func utf8PosRelat(pos, length int) int {
+	switch {
+	case pos >= 0:
+		return pos
+	case -pos > length:
+		return 0
+	}
+	return length + pos + 1
+}
+
+func utf8Char(L *LState) int {
+	var buf strings.Builder
+	top := L.GetTop()
+	for i := 1; i <= top; i++ {
+		code := L.CheckInt64(i)
+		if code < 0 || code > maxUnicode {
+			L.ArgError(i, "value out of range")
+		}
+		utf8Encode(&buf, rune(code))
+	}
+	L.allocString(buf.Len())
+	L.Push(LString(buf.String()))
+	return 1
+}
+
+func utf8Codepoint(L *LState) int {
+	str := L.CheckString(1)
+	posi := utf8PosRelat(L.OptInt(2, 1), len(str))
+	pose := utf8PosRelat(L.OptInt(3, posi), len(str))
+	if posi < 1 {
+		L.ArgError(2, "out of range")
+	}
+	if pose > len(str) {
+		L.ArgError(3, "out of range")
+	}
+	n := 0
+	for i := posi - 1; i < pose; n++ {
+		code, next, ok := utf8Decode(str, i)
+		if !ok {
+			L.RaiseError("invalid UTF-8 code")
+		}
+		L.Push(integerValue(L, int64(code)))
+		i = next
+	}
+	return n
+}
+
+func utf8Len(L *LState) int {
+	str := L.CheckString(1)
+	posi := utf8PosRelat(L.OptInt(2, 1), len(str))
+	posj := utf8PosRelat(L.OptInt(3, -1), len(str))
+	if posi < 1 || posi-1 > len(str) {
+		L.ArgError(2, "initial position out of string")
+	}
+	if posj-1 >= len(str) {
+		L.ArgError(3, "final position out of string")
+	}
+	n := 0
+	for i := posi - 1; i < posj; n++ {
+		_, next, ok := utf8Decode(str, i)
+		if !ok {
+			L.Push(LNil)
+			L.Push(integerValue(L, int64(i+1)))
+			return 2
+		}
+		i = next
+	}
+	L.Push(integerValue(L, int64(n)))
+	return 1
+}
+
+func utf8Offset(L *LState) int {
+	str := L.CheckString(1)
+	n := L.CheckInt(2)
+	posi := 1
+	if n < 0 {
+		posi = len(str) + 1
+	}
+	posi = utf8PosRelat(L.OptInt(3, posi), len(str))
+	if posi < 1 || posi-1 > len(str) {
+		L.ArgError(3, "position out of range")
+	}
+	posi--
+	if n == 0 {
+		// find the beginning of the current byte sequence
+		for posi > 0 && utf8IsCont(str, posi) {
+			posi--
+		}
+	} else {
+		if utf8IsCont(str, posi) {
+			L.RaiseError("initial position is a continuation byte")
+		}
+		if n < 0 {
+			for ; n < 0 && posi > 0; n++ {
+				posi--
+				for posi > 0 && utf8IsCont(str, posi) {
+					posi--
+				}
+			}
+		} else {
+			for n--; n > 0 && posi < len(str); n-- {
+				posi++
+				for utf8IsCont(str, posi) {
+					posi++
+				}
+			}
+		}
+	}
+	if n == 0 {
+		L.Push(integerValue(L, int64(posi+1)))
+	} else {
+		L.Push(LNil)
+	}
+	return 1
+}
+
+func utf8CodesIter(L *LState) int {
+	str := L.CheckString(1)
+	i := L.CheckInt(2) - 1
+	if i < 0 {
+		i = 0
+	} else if i < len(str) {
+		i++
+		for utf8IsCont(str, i) {
+			i++
+		}
+	}
+	if i >= len(str) {
+		return 0
+	}
+	code, next, ok := utf8Decode(str, i)
+	if !ok || utf8IsCont(str, next) {
+		L.RaiseError("invalid UTF-8 code")
+	}
+	L.Push(integerValue(L, int64(i+1)))
+	L.Push(integerValue(L, int64(code)))
+	return 2
+}
+
+func utf8Codes(L *LState) int {
+	str := L.CheckString(1)
+	L.Push(L.NewFunction(utf8CodesIter))
+	L.Push(LString(str))
+	L.Push(integerValue(L, 0))
+	return 3
+}
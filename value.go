@@ -4,6 +4,8 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"reflect"
+	"strconv"
 )
 
 type LValueType int
@@ -41,7 +43,7 @@ func LVAsBool(v LValue) bool { return v != LNil && v != LFalse }
 // if the LValue is a string or number, otherwise an empty string.
 func LVAsString(v LValue) string {
 	switch sn := v.(type) {
-	case LString, LNumber:
+	case LString, LNumber, LInteger:
 		return sn.String()
 	default:
 		return ""
@@ -52,7 +54,7 @@ func LVAsString(v LValue) string {
 // otherwise false.
 func LVCanConvToString(v LValue) bool {
 	switch v.(type) {
-	case LString, LNumber:
+	case LString, LNumber, LInteger:
 		return true
 	default:
 		return false
@@ -64,6 +66,8 @@ func LVAsNumber(v LValue) LNumber {
 	switch lv := v.(type) {
 	case LNumber:
 		return lv
+	case LInteger:
+		return LNumber(lv)
 	case LString:
 		if num, err := parseNumber(string(lv)); err == nil {
 			return num
@@ -140,6 +144,23 @@ func (nm LNumber) Format(f fmt.State, c rune) {
 	}
 }
 
+func (it LInteger) String() string   { return strconv.FormatInt(int64(it), 10) }
+func (it LInteger) Type() LValueType { return LTNumber }
+
+// fmt.Formatter interface
+func (it LInteger) Format(f fmt.State, c rune) {
+	switch c {
+	case 'q', 's':
+		defaultFormat(it.String(), f, c)
+	case 'e', 'E', 'f', 'F', 'g', 'G':
+		defaultFormat(float64(it), f, c)
+	case 'i':
+		defaultFormat(int64(it), f, 'd')
+	default:
+		defaultFormat(int64(it), f, c)
+	}
+}
+
 type LTable struct {
 	Metatable LValue
 
@@ -148,6 +169,11 @@ type LTable struct {
 	strdict map[string]LValue
 	keys    []LValue
 	k2i     map[LValue]int
+	mem     *memoryAccount
+	weak    weakMode
+	// intKeys makes integral number keys LIntegers in iterations, like integer literals of states with
+	// Options.IntegerSubtype.
+	intKeys bool
 }
 
 func (tb *LTable) String() string   { return fmt.Sprintf("table: %p", tb) }
@@ -173,7 +199,12 @@ type Global struct {
 
 	builtinMts map[int]LValue
 	tempFiles  []*os.File
-	gccount    int32
+	instLimit  int64
+	instCount  int64
+	mem        memoryAccount
+	finalizers finalizerQueue
+	gc         gcState
+	bridgeMts  map[reflect.Type]*LTable
 }
 
 type LState struct {
@@ -193,6 +224,8 @@ type LState struct {
 	uvcache      *Upvalue
 	hasErrorFunc bool
 	mainLoop     func(*LState, *callFrame)
+	hook         *hookState
+	errType      ApiErrorType
 	ctx          context.Context
 	ctxCancelFn  context.CancelFunc
 }
@@ -204,6 +237,9 @@ type LUserData struct {
 	Value     interface{}
 	Env       *LTable
 	Metatable LValue
+
+	finalizer   func(*LUserData)
+	finalizable bool
 }
 
 func (ud *LUserData) String() string   { return fmt.Sprintf("userdata: %p", ud) }
@@ -0,0 +1,636 @@
+package lua
+
+import (
+	"fmt"
+	"sync/atomic"
+)
+
+/* VerifyError {{{ */
+
+// VerifyError is returned by VerifyProto when a function prototype is malformed.
+type VerifyError struct {
+	// Proto is the (possibly nested) prototype that is malformed.
+	Proto *FunctionProto
+	// Pc is the index of the malformed instruction in Proto.Code, or -1 if the error is not caused by an instruction.
+	Pc      int
+	Message string
+}
+
+func (e *VerifyError) Error() string {
+	if e.Pc < 0 {
+		return fmt.Sprintf("bad function prototype %v:%v: %v", e.Proto.SourceName, e.Proto.LineDefined, e.Message)
+	}
+	return fmt.Sprintf("bad function prototype %v:%v: instruction %v: %v",
+		e.Proto.SourceName, e.Proto.LineDefined, e.Pc+1, e.Message)
+} // }}}
+
+// VerifyProto checks that the given function prototype and all its nested prototypes can be executed safely:
+// register indices are within NumUsedRegisters, constant, upvalue and prototype indices are within range,
+// jumps land on instructions, the debug information matches the code, and registers are written before they
+// are read.
+//
+// Prototypes created by the compiler are always valid. Prototypes that are loaded from precompiled chunks or
+// passed to LState.NewFunctionFromProto are verified automatically.
+// VerifyProto also initializes internal data of prototypes that are built by hand, so it should be called
+// before such prototypes are shared between goroutines.
+func VerifyProto(proto *FunctionProto) error {
+	if err := verifyProto(proto, 0); err != nil {
+		return err
+	}
+	proto.setVerified()
+	return nil
+}
+
+func (fp *FunctionProto) isVerified() bool {
+	return atomic.LoadUint32(&fp.verified) != 0
+}
+
+func (fp *FunctionProto) setVerified() {
+	atomic.StoreUint32(&fp.verified, 1)
+	for _, p := range fp.FunctionPrototypes {
+		p.setVerified()
+	}
+}
+
+// ensureVerified verifies proto unless it has been created by the compiler or verified already.
+func ensureVerified(proto *FunctionProto) error {
+	if proto.isVerified() {
+		return nil
+	}
+	return VerifyProto(proto)
+}
+
+type protoVerifier struct {
+	proto *FunctionProto
+	nregs int
+	// data marks the words in Code that are operands of the previous instruction rather than instructions.
+	data []bool
+	// states holds the registers state before each instruction, which is valid if reached is true.
+	states  []regState
+	reached []bool
+	// work holds the instructions whose states have changed.
+	work []int
+}
+
+func verifyProto(proto *FunctionProto, depth int) error {
+	if proto == nil {
+		return &VerifyError{Proto: &FunctionProto{}, Pc: -1, Message: "nil function prototype"}
+	}
+	if depth > maxProtoDepth {
+		return &VerifyError{Proto: proto, Pc: -1, Message: "function prototypes are nested too deeply"}
+	}
+	v := &protoVerifier{proto: proto, nregs: int(proto.NumUsedRegisters)}
+	if err := v.header(); err != nil {
+		return err
+	}
+	if err := v.code(); err != nil {
+		return err
+	}
+	if err := v.registers(); err != nil {
+		return err
+	}
+	for _, p := range proto.FunctionPrototypes {
+		if err := verifyProto(p, depth+1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (v *protoVerifier) errorf(pc int, format string, args ...interface{}) error {
+	return &VerifyError{Proto: v.proto, Pc: pc, Message: fmt.Sprintf(format, args...)}
+}
+
+func (v *protoVerifier) header() error {
+	fp := v.proto
+	if int(fp.NumParameters) > v.nregs {
+		return v.errorf(-1, "%v parameters do not fit in %v registers", fp.NumParameters, v.nregs)
+	}
+	if len(fp.Code) == 0 {
+		return v.errorf(-1, "no instructions")
+	}
+	if len(fp.DbgSourcePositions) != len(fp.Code) {
+		return v.errorf(-1, "%v source positions for %v instructions", len(fp.DbgSourcePositions), len(fp.Code))
+	}
+	if len(fp.DbgUpvalues) != int(fp.NumUpvalues) {
+		return v.errorf(-1, "%v upvalue names for %v upvalues", len(fp.DbgUpvalues), fp.NumUpvalues)
+	}
+	for _, local := range fp.DbgLocals {
+		if local == nil {
+			return v.errorf(-1, "nil local variable information")
+		}
+	}
+	for i, c := range fp.Constants {
+		switch c.(type) {
+		case *LNilType, LBool, LNumber, LInteger, LString:
+		default:
+			return v.errorf(-1, "constant %v must be nil, boolean, number or string", i)
+		}
+	}
+	if len(fp.stringConstants) != len(fp.Constants) {
+		fp.stringConstants = make([]string, len(fp.Constants))
+		for i, c := range fp.Constants {
+			if s, ok := c.(LString); ok {
+				fp.stringConstants[i] = string(s)
+			}
+		}
+	}
+	return nil
+}
+
+func (v *protoVerifier) code() error {
+	code := v.proto.Code
+	v.data = make([]bool, len(code))
+	for pc := 0; pc < len(code); pc++ {
+		inst := code[pc]
+		if opGetOpCode(inst) == OP_SETLIST && opGetArgC(inst) == 0 && pc+1 < len(code) {
+			pc++
+			v.data[pc] = true
+		}
+	}
+	if last := len(code) - 1; v.data[last] || opGetOpCode(code[last]) != OP_RETURN {
+		return v.errorf(last, "the last instruction must be RETURN")
+	}
+	for pc, inst := range code {
+		if v.data[pc] {
+			continue
+		}
+		if err := v.instruction(pc, inst); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (v *protoVerifier) reg(pc, r int) error {
+	if r < 0 || r >= v.nregs {
+		return v.errorf(pc, "register %v out of range(%v registers)", r, v.nregs)
+	}
+	return nil
+}
+
+// top checks a register from which values are pushed onto the stack. It can be just after the last register.
+func (v *protoVerifier) top(pc, r int) error {
+	if r < 0 || r > v.nregs {
+		return v.errorf(pc, "register %v out of range(%v registers)", r, v.nregs)
+	}
+	return nil
+}
+
+func (v *protoVerifier) constant(pc, idx int, str bool) error {
+	if idx >= len(v.proto.Constants) {
+		return v.errorf(pc, "constant %v out of range(%v constants)", idx, len(v.proto.Constants))
+	}
+	if _, ok := v.proto.Constants[idx].(LString); str && !ok {
+		return v.errorf(pc, "constant %v must be a string", idx)
+	}
+	return nil
+}
+
+// rk checks an RK operand, that is a register or a constant. If str is true, the constant must be a string.
+func (v *protoVerifier) rk(pc, x int, str bool) error {
+	if opIsK(x) {
+		return v.constant(pc, opIndexK(x), str)
+	}
+	return v.reg(pc, x)
+}
+
+func (v *protoVerifier) target(pc, target int) error {
+	if target < 0 || target >= len(v.proto.Code) || v.data[target] {
+		return v.errorf(pc, "jump to an invalid position %v", target+1)
+	}
+	return nil
+}
+
+// open checks that the previous instruction sets the stack top, which is required by instructions that take
+// their operands up to the stack top. The values pushed by the previous instruction must start at base or above.
+func (v *protoVerifier) open(pc, base int) error {
+	if pc > 0 && !v.data[pc-1] {
+		prev := v.proto.Code[pc-1]
+		switch opGetOpCode(prev) {
+		case OP_CALL, OP_TAILCALL, OP_VARARG:
+			if opGetArgA(prev) < base {
+				return v.errorf(pc, "values pushed by the previous instruction overlap register %v", base)
+			}
+			return nil
+		}
+	}
+	return v.errorf(pc, "no preceding instruction sets the stack top")
+}
+
+// isOpen reports whether the instruction at pc takes its operands up to the stack top.
+func (v *protoVerifier) isOpen(pc int) bool {
+	inst := v.proto.Code[pc]
+	switch opGetOpCode(inst) {
+	case OP_CALL, OP_TAILCALL, OP_RETURN, OP_SETLIST:
+		return !v.data[pc] && opGetArgB(inst) == 0
+	}
+	return false
+}
+
+func (v *protoVerifier) instruction(pc int, inst uint32) error {
+	fp := v.proto
+	op := opGetOpCode(inst)
+	if op > opCodeMax {
+		return v.errorf(pc, "invalid opcode %v", op)
+	}
+	a := opGetArgA(inst)
+	b := opGetArgB(inst)
+	c := opGetArgC(inst)
+	bx := opGetArgBx(inst)
+	sbx := opGetArgSbx(inst)
+	var errs []error
+
+	switch op {
+	case OP_MOVE, OP_UNM, OP_NOT, OP_LEN:
+		errs = append(errs, v.reg(pc, a), v.reg(pc, b))
+	case OP_MOVEN:
+		errs = append(errs, v.reg(pc, a), v.reg(pc, b))
+		if pc+c >= len(fp.Code) {
+			return v.errorf(pc, "MOVEN runs past the end of the code")
+		}
+		for i := pc + 1; i <= pc+c; i++ {
+			if v.data[i] || opGetOpCode(fp.Code[i]) != OP_MOVE {
+				return v.errorf(pc, "MOVEN must be followed by %v MOVE instructions", c)
+			}
+		}
+	case OP_LOADK:
+		errs = append(errs, v.reg(pc, a), v.constant(pc, bx, false))
+	case OP_LOADBOOL:
+		errs = append(errs, v.reg(pc, a))
+		if c != 0 {
+			errs = append(errs, v.target(pc, pc+2))
+		}
+	case OP_LOADNIL:
+		errs = append(errs, v.reg(pc, a), v.reg(pc, b))
+	case OP_GETUPVAL, OP_SETUPVAL:
+		errs = append(errs, v.reg(pc, a))
+		if b >= int(fp.NumUpvalues) {
+			return v.errorf(pc, "upvalue %v out of range(%v upvalues)", b, fp.NumUpvalues)
+		}
+	case OP_GETGLOBAL, OP_SETGLOBAL:
+		errs = append(errs, v.reg(pc, a), v.constant(pc, bx, true))
+	case OP_GETTABLE:
+		errs = append(errs, v.reg(pc, a), v.reg(pc, b), v.rk(pc, c, false))
+	case OP_GETTABLEKS:
+		errs = append(errs, v.reg(pc, a), v.reg(pc, b), v.rk(pc, c, true))
+	case OP_SETTABLE:
+		errs = append(errs, v.reg(pc, a), v.rk(pc, b, false), v.rk(pc, c, false))
+	case OP_SETTABLEKS:
+		errs = append(errs, v.reg(pc, a), v.rk(pc, b, true), v.rk(pc, c, false))
+	case OP_NEWTABLE:
+		errs = append(errs, v.reg(pc, a))
+	case OP_SELF:
+		errs = append(errs, v.reg(pc, a+1), v.reg(pc, b), v.rk(pc, c, true))
+	case OP_ADD, OP_SUB, OP_MUL, OP_DIV, OP_MOD, OP_POW:
+		errs = append(errs, v.reg(pc, a), v.rk(pc, b, false), v.rk(pc, c, false))
+	case OP_CONCAT:
+		errs = append(errs, v.reg(pc, a), v.reg(pc, b), v.reg(pc, c))
+		if b > c {
+			return v.errorf(pc, "bad CONCAT range %v..%v", b, c)
+		}
+	case OP_JMP:
+		errs = append(errs, v.target(pc, pc+1+sbx))
+	case OP_EQ, OP_LT, OP_LE:
+		errs = append(errs, v.rk(pc, b, false), v.rk(pc, c, false), v.target(pc, pc+1), v.target(pc, pc+2))
+	case OP_TEST:
+		errs = append(errs, v.reg(pc, a), v.target(pc, pc+1), v.target(pc, pc+2))
+	case OP_TESTSET:
+		errs = append(errs, v.reg(pc, a), v.reg(pc, b), v.target(pc, pc+1), v.target(pc, pc+2))
+	case OP_CALL, OP_TAILCALL:
+		errs = append(errs, v.reg(pc, a))
+		if b == 0 {
+			errs = append(errs, v.open(pc, a+1))
+		} else {
+			errs = append(errs, v.reg(pc, a+b-1))
+		}
+		if op == OP_CALL && c > 1 {
+			errs = append(errs, v.reg(pc, a+c-2))
+		}
+	case OP_RETURN:
+		if b == 0 {
+			errs = append(errs, v.top(pc, a), v.open(pc, a))
+		} else if b > 1 {
+			errs = append(errs, v.reg(pc, a+b-2))
+		}
+	case OP_FORLOOP, OP_FORPREP:
+		errs = append(errs, v.reg(pc, a+3), v.target(pc, pc+1+sbx))
+	case OP_TFORLOOP:
+		errs = append(errs, v.reg(pc, a+2+c), v.target(pc, pc+2))
+		if c < 1 {
+			return v.errorf(pc, "TFORLOOP must have at least one loop variable")
+		}
+		if pc+1 >= len(fp.Code) || v.data[pc+1] || opGetOpCode(fp.Code[pc+1]) != OP_JMP {
+			return v.errorf(pc, "TFORLOOP must be followed by JMP")
+		}
+	case OP_SETLIST:
+		errs = append(errs, v.reg(pc, a))
+		if b == 0 {
+			errs = append(errs, v.open(pc, a+1))
+		} else {
+			errs = append(errs, v.reg(pc, a+b))
+		}
+		if c == 0 && pc+1 >= len(fp.Code) {
+			return v.errorf(pc, "SETLIST runs past the end of the code")
+		}
+	case OP_CLOSE, OP_NOP:
+		/* nothing to check */
+	case OP_CLOSURE:
+		errs = append(errs, v.reg(pc, a))
+		if bx >= len(fp.FunctionPrototypes) || fp.FunctionPrototypes[bx] == nil {
+			return v.errorf(pc, "function prototype %v out of range(%v prototypes)", bx, len(fp.FunctionPrototypes))
+		}
+		nup := int(fp.FunctionPrototypes[bx].NumUpvalues)
+		if pc+nup >= len(fp.Code) {
+			return v.errorf(pc, "CLOSURE runs past the end of the code")
+		}
+		for i := pc + 1; i <= pc+nup; i++ {
+			if uvop := opGetOpCode(fp.Code[i]); v.data[i] || (uvop != OP_MOVE && uvop != OP_GETUPVAL) {
+				return v.errorf(pc, "CLOSURE must be followed by %v MOVE or GETUPVAL instructions", nup)
+			}
+		}
+	case OP_VARARG:
+		if fp.IsVarArg&VarArgIsVarArg == 0 {
+			return v.errorf(pc, "VARARG in a function that is not variadic")
+		}
+		if b == 0 {
+			errs = append(errs, v.top(pc, a))
+		} else {
+			errs = append(errs, v.reg(pc, a))
+		}
+		if b > 1 {
+			errs = append(errs, v.reg(pc, a+b-2))
+		}
+	}
+
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// regSet is a set of register indices.
+type regSet [4]uint64
+
+func (s *regSet) add(r int) {
+	s[r>>6] |= 1 << uint(r&63)
+}
+
+func (s *regSet) has(r int) bool {
+	return s[r>>6]&(1<<uint(r&63)) != 0
+}
+
+// from returns the smallest register in s that is not less than r.
+func (s *regSet) from(r int) (int, bool) {
+	for ; r < 64*len(s); r++ {
+		if s.has(r) {
+			return r, true
+		}
+	}
+	return 0, false
+}
+
+// removeFrom removes the registers that are not less than r.
+func (s *regSet) removeFrom(r int) {
+	for i := range s {
+		switch {
+		case r <= 64*i:
+			s[i] = 0
+		case r < 64*(i+1):
+			s[i] &= 1<<uint(r-64*i) - 1
+		}
+	}
+}
+
+// regState describes the registers before an instruction.
+type regState struct {
+	// written is the set of registers that are written on every path to the instruction.
+	written regSet
+	// captured is the set of registers that are referred to by open upvalues on some path to the instruction.
+	captured regSet
+}
+
+// merge merges a state that flows into an instruction into st, and reports whether st has changed.
+func (st *regState) merge(in regState) bool {
+	changed := false
+	for i := range st.written {
+		w, c := st.written[i]&in.written[i], st.captured[i]|in.captured[i]
+		changed = changed || w != st.written[i] || c != st.captured[i]
+		st.written[i], st.captured[i] = w, c
+	}
+	return changed
+}
+
+// registers checks by data flow analysis that every register is written before it is read. Calls, VARARG and
+// numeric for loops clear the registers above their results, so such registers are not written afterwards and
+// they must not be referred to by open upvalues.
+func (v *protoVerifier) registers() error {
+	fp := v.proto
+	v.states = make([]regState, len(fp.Code))
+	v.reached = make([]bool, len(fp.Code))
+	var entry regState
+	for r := 0; r < int(fp.NumParameters); r++ {
+		entry.written.add(r)
+	}
+	if np := int(fp.NumParameters); fp.IsVarArg&VarArgIsVarArg != 0 && np < v.nregs {
+		entry.written.add(np) // arg, or nil
+	}
+	if err := v.flow(-1, 0, entry); err != nil {
+		return err
+	}
+	for len(v.work) > 0 {
+		pc := v.work[len(v.work)-1]
+		v.work = v.work[:len(v.work)-1]
+		if err := v.transfer(pc); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// flow merges the state after the instruction at from into the instruction at pc.
+func (v *protoVerifier) flow(from, pc int, st regState) error {
+	if v.isOpen(pc) && from != pc-1 {
+		return v.errorf(pc, "jump to an instruction that needs the stack top set by the previous instruction")
+	}
+	if !v.reached[pc] {
+		v.reached[pc] = true
+		v.states[pc] = st
+	} else if !v.states[pc].merge(st) {
+		return nil
+	}
+	v.work = append(v.work, pc)
+	return nil
+}
+
+// read checks that the registers from..to are written.
+func (v *protoVerifier) read(pc int, st *regState, from, to int) error {
+	for r := from; r <= to; r++ {
+		if !st.written.has(r) {
+			return v.errorf(pc, "register %v is read before it is written", r)
+		}
+	}
+	return nil
+}
+
+func (v *protoVerifier) readRK(pc int, st *regState, x int) error {
+	if opIsK(x) {
+		return nil
+	}
+	return v.read(pc, st, x, x)
+}
+
+// readOpen checks the registers from base up to the values pushed by the previous instruction.
+func (v *protoVerifier) readOpen(pc int, st *regState, base int) error {
+	return v.read(pc, st, base, opGetArgA(v.proto.Code[pc-1])-1)
+}
+
+func (v *protoVerifier) write(st *regState, from, to int) {
+	for r := from; r <= to; r++ {
+		st.written.add(r)
+	}
+}
+
+// clear removes the registers from r on, which the VM clears, from the written registers.
+func (v *protoVerifier) clear(pc int, st *regState, r int) error {
+	if c, ok := st.captured.from(r); ok {
+		return v.errorf(pc, "register %v is referred to by an open upvalue", c)
+	}
+	st.written.removeFrom(r)
+	return nil
+}
+
+// transfer computes the registers state after the instruction at pc and passes it to the following instructions.
+func (v *protoVerifier) transfer(pc int) error {
+	fp := v.proto
+	st := v.states[pc]
+	inst := fp.Code[pc]
+	op := opGetOpCode(inst)
+	a := opGetArgA(inst)
+	b := opGetArgB(inst)
+	c := opGetArgC(inst)
+	next := []int{pc + 1}
+	var errs []error
+
+	switch op {
+	case OP_MOVE, OP_MOVEN, OP_UNM, OP_NOT, OP_LEN:
+		errs = append(errs, v.read(pc, &st, b, b))
+		v.write(&st, a, a)
+	case OP_LOADK, OP_GETUPVAL, OP_GETGLOBAL, OP_NEWTABLE:
+		v.write(&st, a, a)
+	case OP_LOADBOOL:
+		v.write(&st, a, a)
+		if c != 0 {
+			next = []int{pc + 2}
+		}
+	case OP_LOADNIL:
+		v.write(&st, a, b)
+	case OP_SETUPVAL, OP_SETGLOBAL:
+		errs = append(errs, v.read(pc, &st, a, a))
+	case OP_GETTABLE, OP_GETTABLEKS:
+		errs = append(errs, v.read(pc, &st, b, b), v.readRK(pc, &st, c))
+		v.write(&st, a, a)
+	case OP_SETTABLE, OP_SETTABLEKS:
+		errs = append(errs, v.read(pc, &st, a, a), v.readRK(pc, &st, b), v.readRK(pc, &st, c))
+	case OP_SELF:
+		errs = append(errs, v.read(pc, &st, b, b), v.readRK(pc, &st, c))
+		v.write(&st, a, a+1)
+	case OP_ADD, OP_SUB, OP_MUL, OP_DIV, OP_MOD, OP_POW:
+		errs = append(errs, v.readRK(pc, &st, b), v.readRK(pc, &st, c))
+		v.write(&st, a, a)
+	case OP_CONCAT:
+		errs = append(errs, v.read(pc, &st, b, c))
+		v.write(&st, a, a)
+	case OP_JMP:
+		next = []int{pc + 1 + opGetArgSbx(inst)}
+	case OP_EQ, OP_LT, OP_LE:
+		errs = append(errs, v.readRK(pc, &st, b), v.readRK(pc, &st, c))
+		next = append(next, pc+2)
+	case OP_TEST:
+		errs = append(errs, v.read(pc, &st, a, a))
+		next = append(next, pc+2)
+	case OP_TESTSET:
+		// R(A) is written only if the following JMP is taken
+		errs = append(errs, v.read(pc, &st, b, b))
+		set := st
+		v.write(&set, a, a)
+		errs = append(errs, v.flow(pc, pc+1, set))
+		next = []int{pc + 2}
+	case OP_CALL, OP_TAILCALL:
+		errs = append(errs, v.read(pc, &st, a, a))
+		if b == 0 {
+			errs = append(errs, v.readOpen(pc, &st, a+1))
+		} else {
+			errs = append(errs, v.read(pc, &st, a+1, a+b-1))
+		}
+		if op == OP_TAILCALL {
+			st.captured = regSet{}
+		}
+		errs = append(errs, v.clear(pc, &st, a))
+		if op == OP_CALL && c > 1 {
+			v.write(&st, a, a+c-2)
+		}
+	case OP_RETURN:
+		if b == 0 {
+			errs = append(errs, v.readOpen(pc, &st, a))
+		} else {
+			errs = append(errs, v.read(pc, &st, a, a+b-2))
+		}
+		next = nil
+	case OP_FORPREP:
+		errs = append(errs, v.read(pc, &st, a, a+2))
+		next = []int{pc + 1 + opGetArgSbx(inst)}
+	case OP_FORLOOP:
+		errs = append(errs, v.read(pc, &st, a, a+2))
+		loop := st
+		v.write(&loop, a+3, a+3)
+		errs = append(errs, v.flow(pc, pc+1+opGetArgSbx(inst), loop))
+		// the VM sets the stack top to R(A+1) when the loop ends
+		errs = append(errs, v.clear(pc, &st, a+1))
+	case OP_TFORLOOP:
+		errs = append(errs, v.read(pc, &st, a, a+2), v.clear(pc, &st, a+3))
+		v.write(&st, a+3, a+2+c)
+		next = []int{pc + 2 + opGetArgSbx(fp.Code[pc+1]), pc + 2}
+	case OP_SETLIST:
+		errs = append(errs, v.read(pc, &st, a, a))
+		if b == 0 {
+			errs = append(errs, v.readOpen(pc, &st, a+1))
+		} else {
+			errs = append(errs, v.read(pc, &st, a+1, a+b))
+		}
+		if c == 0 {
+			next = []int{pc + 2}
+		}
+	case OP_CLOSE:
+		st.captured.removeFrom(a)
+	case OP_CLOSURE:
+		v.write(&st, a, a)
+		nup := int(fp.FunctionPrototypes[opGetArgBx(inst)].NumUpvalues)
+		for i := pc + 1; i <= pc+nup; i++ {
+			if uv := fp.Code[i]; opGetOpCode(uv) == OP_MOVE {
+				errs = append(errs, v.read(pc, &st, opGetArgB(uv), opGetArgB(uv)))
+				st.captured.add(opGetArgB(uv))
+			}
+		}
+		next = []int{pc + nup + 1}
+	case OP_VARARG:
+		if b == 0 {
+			errs = append(errs, v.clear(pc, &st, a))
+		} else {
+			errs = append(errs, v.clear(pc, &st, a+b-1))
+			v.write(&st, a, a+b-2)
+		}
+	}
+
+	for _, n := range next {
+		errs = append(errs, v.flow(pc, n, st))
+	}
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
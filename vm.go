@@ -28,7 +28,11 @@ func mainLoop(L *LState, baseframe *callFrame) {
 		cf = L.currentFrame
 		inst = cf.Fn.Proto.Code[cf.Pc]
 		cf.Pc++
-		if jumpTable[int(inst>>26)](L, inst, baseframe) == 1 {
+		switch jumpTable[int(inst>>26)](L, inst, baseframe) {
+		case 1:
+			return
+		case 2:
+			mainLoopWithHook(L, baseframe)
 			return
 		}
 	}
@@ -54,16 +58,101 @@ func mainLoopWithContext(L *LState, baseframe *callFrame) {
 		cf.Pc++
 		select {
 		case <-L.ctx.Done():
-			L.RaiseError(L.ctx.Err().Error())
+			L.RaiseError("%s", L.ctx.Err().Error())
 			return
 		default:
-			if jumpTable[int(inst>>26)](L, inst, baseframe) == 1 {
+			switch jumpTable[int(inst>>26)](L, inst, baseframe) {
+			case 1:
+				return
+			case 2:
+				mainLoopWithHook(L, baseframe)
 				return
 			}
 		}
 	}
 }
 
+func mainLoopWithHook(L *LState, baseframe *callFrame) {
+	var inst uint32
+	var cf *callFrame
+
+	if L.stack.IsEmpty() {
+		return
+	}
+
+	L.currentFrame = L.stack.Last()
+	if L.currentFrame.Fn.IsG {
+		callGFunction(L, false)
+		return
+	}
+
+	for {
+		cf = L.currentFrame
+		if L.ctx != nil {
+			select {
+			case <-L.ctx.Done():
+				L.RaiseError("%s", L.ctx.Err().Error())
+				return
+			default:
+			}
+		}
+		inst = cf.Fn.Proto.Code[cf.Pc]
+		cf.Pc++
+		if L.G.instLimit > 0 {
+			L.countInstruction()
+		}
+		if L.G.mem.exceeded() {
+			L.checkMemory(0)
+		}
+		if L.hook != nil && !L.hook.running {
+			traceExec(L, cf)
+		}
+		if jumpTable[int(inst>>26)](L, inst, baseframe) == 1 {
+			return
+		}
+	}
+}
+
+// traceExec calls the debug hooks for the instruction that is about to be executed in the given frame.
+// cf.Pc has already been incremented as in the other instructions.
+func traceExec(L *LState, cf *callFrame) {
+	h := L.hook
+	proto := cf.Fn.Proto
+	pc := cf.Pc - 1
+	line := proto.DbgSourcePositions[pc]
+	op := opGetOpCode(proto.Code[pc])
+	newframe := cf != h.lastFrame
+	iscall := pc == 0 && (newframe || h.lastOp == OP_TAILCALL)
+	newline := false
+	switch {
+	case iscall:
+		newline = true
+	case newframe:
+		// returned from a callee: the call instruction is in the same line in most cases
+		newline = pc > 0 && line != proto.DbgSourcePositions[pc-1]
+	default:
+		newline = pc <= h.lastPc || line != h.lastLine
+	}
+	h.lastFrame, h.lastPc, h.lastLine, h.lastOp = cf, pc, line, op
+
+	if iscall && h.mask&HookCall != 0 {
+		L.callHook("call", -1)
+	}
+	if h.mask&HookCount != 0 {
+		h.counter--
+		if h.counter <= 0 {
+			h.counter = h.count
+			L.callHook("count", -1)
+		}
+	}
+	if newline && h.mask&HookLine != 0 {
+		L.callHook("line", line)
+	}
+	if op == OP_RETURN && h.mask&HookReturn != 0 {
+		L.callHook("return", -1)
+	}
+}
+
 // regv is the first target register to copy the return values to.
 // It can be reg.top, indicating that the copied values are going into new registers, or it can be below reg.top
 // Indicating that the values should be within the existing registers.
@@ -199,7 +288,17 @@ func switchToParentThread(L *LState, nargs int, haserror bool, kill bool) {
 
 func callGFunction(L *LState, tailcall bool) bool {
 	frame := L.currentFrame
+	hook := L.hook
+	if hook != nil && (hook.running || hook.mask&(HookCall|HookReturn) == 0) {
+		hook = nil
+	}
+	if hook != nil && hook.mask&HookCall != 0 {
+		L.callHook("call", -1)
+	}
 	gfnret := frame.Fn.GFunction(L)
+	if hook != nil && hook.mask&HookReturn != 0 && L.hook == hook && gfnret >= 0 {
+		L.callHook("return", -1)
+	}
 	if tailcall {
 		L.currentFrame = L.RemoveCallerFrame()
 	}
@@ -272,12 +371,16 @@ func threadRun(L *LState) {
 	defer func() {
 		if rcv := recover(); rcv != nil {
 			var lv LValue
+			typ := ApiErrorRun
 			if v, ok := rcv.(*ApiError); ok {
 				lv = v.Object
+				typ = v.Type
 			} else {
 				lv = LString(fmt.Sprint(rcv))
 			}
 			if parent := L.Parent; parent != nil {
+				// the parent reports the error with the same type, e.g. ApiErrorInstructionLimit
+				L.errType = typ
 				if L.wrapped {
 					L.Push(lv)
 					parent.Panic(L)
@@ -291,6 +394,8 @@ func threadRun(L *LState) {
 			}
 		}
 	}()
+	// limits or hooks may have been changed after the thread was created
+	L.updateMainLoop()
 	L.mainLoop(L, nil)
 }
 
@@ -681,7 +786,7 @@ func init() {
 			RA := lbase + A
 			B := int(inst & 0x1ff)    //GETB
 			C := int(inst>>9) & 0x1ff //GETC
-			v := newLTable(B, C)
+			v := L.newTable(B, C)
 			// this section is inlined by go-inline
 			// source function is 'func (rg *registry) Set(regi int, vali LValue) ' in '_state.go'
 			{
@@ -791,6 +896,27 @@ func init() {
 						rg.top = regi + 1
 					}
 				}
+			} else if it, ok := unaryv.(LInteger); ok {
+				// this section is inlined by go-inline
+				// source function is 'func (rg *registry) Set(regi int, vali LValue) ' in '_state.go'
+				{
+					rg := reg
+					regi := RA
+					vali := -it
+					newSize := regi + 1
+					// this section is inlined by go-inline
+					// source function is 'func (rg *registry) checkSize(requiredSize int) ' in '_state.go'
+					{
+						requiredSize := newSize
+						if requiredSize > cap(rg.array) {
+							rg.resize(requiredSize)
+						}
+					}
+					rg.array[regi] = vali
+					if regi >= rg.top {
+						rg.top = regi + 1
+					}
+				}
 			} else {
 				op := L.metaOp1(unaryv, "__unm")
 				if op.Type() == LTFunction {
@@ -818,25 +944,48 @@ func init() {
 						}
 					}
 				} else if str, ok1 := unaryv.(LString); ok1 {
-					if num, err := parseNumber(string(str)); err == nil {
-						// this section is inlined by go-inline
-						// source function is 'func (rg *registry) Set(regi int, vali LValue) ' in '_state.go'
-						{
-							rg := reg
-							regi := RA
-							vali := -num
-							newSize := regi + 1
+					if num, err := parseNumberValue(string(str), L.Options.IntegerSubtype); err == nil {
+						if it, ok := num.(LInteger); ok {
 							// this section is inlined by go-inline
-							// source function is 'func (rg *registry) checkSize(requiredSize int) ' in '_state.go'
+							// source function is 'func (rg *registry) Set(regi int, vali LValue) ' in '_state.go'
 							{
-								requiredSize := newSize
-								if requiredSize > cap(rg.array) {
-									rg.resize(requiredSize)
+								rg := reg
+								regi := RA
+								vali := -it
+								newSize := regi + 1
+								// this section is inlined by go-inline
+								// source function is 'func (rg *registry) checkSize(requiredSize int) ' in '_state.go'
+								{
+									requiredSize := newSize
+									if requiredSize > cap(rg.array) {
+										rg.resize(requiredSize)
+									}
+								}
+								rg.array[regi] = vali
+								if regi >= rg.top {
+									rg.top = regi + 1
 								}
 							}
-							rg.array[regi] = vali
-							if regi >= rg.top {
-								rg.top = regi + 1
+						} else {
+							// this section is inlined by go-inline
+							// source function is 'func (rg *registry) Set(regi int, vali LValue) ' in '_state.go'
+							{
+								rg := reg
+								regi := RA
+								vali := -num.(LNumber)
+								newSize := regi + 1
+								// this section is inlined by go-inline
+								// source function is 'func (rg *registry) checkSize(requiredSize int) ' in '_state.go'
+								{
+									requiredSize := newSize
+									if requiredSize > cap(rg.array) {
+										rg.resize(requiredSize)
+									}
+								}
+								rg.array[regi] = vali
+								if regi >= rg.top {
+									rg.top = regi + 1
+								}
 							}
 						}
 					} else {
@@ -909,24 +1058,47 @@ func init() {
 			B := int(inst & 0x1ff) //GETB
 			switch lv := L.rkValue(B).(type) {
 			case LString:
-				// this section is inlined by go-inline
-				// source function is 'func (rg *registry) SetNumber(regi int, vali LNumber) ' in '_state.go'
-				{
-					rg := reg
-					regi := RA
-					vali := LNumber(len(lv))
-					newSize := regi + 1
+				if L.Options.IntegerSubtype {
 					// this section is inlined by go-inline
-					// source function is 'func (rg *registry) checkSize(requiredSize int) ' in '_state.go'
+					// source function is 'func (rg *registry) Set(regi int, vali LValue) ' in '_state.go'
 					{
-						requiredSize := newSize
-						if requiredSize > cap(rg.array) {
-							rg.resize(requiredSize)
+						rg := reg
+						regi := RA
+						vali := LInteger(len(lv))
+						newSize := regi + 1
+						// this section is inlined by go-inline
+						// source function is 'func (rg *registry) checkSize(requiredSize int) ' in '_state.go'
+						{
+							requiredSize := newSize
+							if requiredSize > cap(rg.array) {
+								rg.resize(requiredSize)
+							}
+						}
+						rg.array[regi] = vali
+						if regi >= rg.top {
+							rg.top = regi + 1
 						}
 					}
-					rg.array[regi] = rg.alloc.LNumber2I(vali)
-					if regi >= rg.top {
-						rg.top = regi + 1
+				} else {
+					// this section is inlined by go-inline
+					// source function is 'func (rg *registry) SetNumber(regi int, vali LNumber) ' in '_state.go'
+					{
+						rg := reg
+						regi := RA
+						vali := LNumber(len(lv))
+						newSize := regi + 1
+						// this section is inlined by go-inline
+						// source function is 'func (rg *registry) checkSize(requiredSize int) ' in '_state.go'
+						{
+							requiredSize := newSize
+							if requiredSize > cap(rg.array) {
+								rg.resize(requiredSize)
+							}
+						}
+						rg.array[regi] = rg.alloc.LNumber2I(vali)
+						if regi >= rg.top {
+							rg.top = regi + 1
+						}
 					}
 				}
 			default:
@@ -936,8 +1108,7 @@ func init() {
 					reg.Push(lv)
 					L.Call(1, 1)
 					ret := reg.Pop()
-					if ret.Type() == LTNumber {
-						v, _ := ret.(LNumber)
+					if v, ok := ret.(LNumber); ok {
 						// this section is inlined by go-inline
 						// source function is 'func (rg *registry) SetNumber(regi int, vali LNumber) ' in '_state.go'
 						{
@@ -981,24 +1152,47 @@ func init() {
 						}
 					}
 				} else if lv.Type() == LTTable {
-					// this section is inlined by go-inline
-					// source function is 'func (rg *registry) SetNumber(regi int, vali LNumber) ' in '_state.go'
-					{
-						rg := reg
-						regi := RA
-						vali := LNumber(lv.(*LTable).Len())
-						newSize := regi + 1
+					if L.Options.IntegerSubtype {
 						// this section is inlined by go-inline
-						// source function is 'func (rg *registry) checkSize(requiredSize int) ' in '_state.go'
+						// source function is 'func (rg *registry) Set(regi int, vali LValue) ' in '_state.go'
 						{
-							requiredSize := newSize
-							if requiredSize > cap(rg.array) {
-								rg.resize(requiredSize)
+							rg := reg
+							regi := RA
+							vali := LInteger(lv.(*LTable).Len())
+							newSize := regi + 1
+							// this section is inlined by go-inline
+							// source function is 'func (rg *registry) checkSize(requiredSize int) ' in '_state.go'
+							{
+								requiredSize := newSize
+								if requiredSize > cap(rg.array) {
+									rg.resize(requiredSize)
+								}
+							}
+							rg.array[regi] = vali
+							if regi >= rg.top {
+								rg.top = regi + 1
 							}
 						}
-						rg.array[regi] = rg.alloc.LNumber2I(vali)
-						if regi >= rg.top {
-							rg.top = regi + 1
+					} else {
+						// this section is inlined by go-inline
+						// source function is 'func (rg *registry) SetNumber(regi int, vali LNumber) ' in '_state.go'
+						{
+							rg := reg
+							regi := RA
+							vali := LNumber(lv.(*LTable).Len())
+							newSize := regi + 1
+							// this section is inlined by go-inline
+							// source function is 'func (rg *registry) checkSize(requiredSize int) ' in '_state.go'
+							{
+								requiredSize := newSize
+								if requiredSize > cap(rg.array) {
+									rg.resize(requiredSize)
+								}
+							}
+							rg.array[regi] = rg.alloc.LNumber2I(vali)
+							if regi >= rg.top {
+								rg.top = regi + 1
+							}
 						}
 					}
 				} else {
@@ -1089,8 +1283,10 @@ func init() {
 				if v2, ok2 := rhs.(LNumber); ok2 {
 					ret = v1 <= v2
 				} else {
-					L.RaiseError("attempt to compare %v with %v", lhs.Type().String(), rhs.Type().String())
+					ret = numberLessThan(L, lhs, rhs, true)
 				}
+			} else if _, ok1 := lhs.(LInteger); ok1 {
+				ret = numberLessThan(L, lhs, rhs, true)
 			} else {
 				if lhs.Type() != rhs.Type() {
 					L.RaiseError("attempt to compare %v with %v", lhs.Type().String(), rhs.Type().String())
@@ -1286,7 +1482,7 @@ func init() {
 							if CompatVarArg {
 								ls.reg.SetTop(cf.LocalBase + nargs + np + 1)
 								if (proto.IsVarArg & VarArgNeedsArg) != 0 {
-									argtb := newLTable(nvarargs, 0)
+									argtb := ls.newTable(nvarargs, 0)
 									for i := 0; i < nvarargs; i++ {
 										argtb.RawSetInt(i+1, ls.reg.Get(cf.LocalBase+np+i))
 									}
@@ -1305,8 +1501,14 @@ func init() {
 				}
 				ls.currentFrame = newcf
 			}
-			if callable.IsG && callGFunction(L, false) {
-				return 1
+			if callable.IsG {
+				if callGFunction(L, false) {
+					return 1
+				}
+				// the Go function may have set a hook or a limit, the main loop must be switched
+				if L.needsCheckedLoop() {
+					return 2
+				}
 			}
 			return 0
 		},
@@ -1372,6 +1574,9 @@ func init() {
 				if L.currentFrame == nil || L.currentFrame.Fn.IsG || luaframe == baseframe {
 					return 1
 				}
+				if L.needsCheckedLoop() {
+					return 2
+				}
 			} else {
 				base := cf.Base
 				cf.Fn = callable
@@ -1468,7 +1673,7 @@ func init() {
 							if CompatVarArg {
 								ls.reg.SetTop(cf.LocalBase + nargs + np + 1)
 								if (proto.IsVarArg & VarArgNeedsArg) != 0 {
-									argtb := newLTable(nvarargs, 0)
+									argtb := ls.newTable(nvarargs, 0)
 									for i := 0; i < nvarargs; i++ {
 										argtb.RawSetInt(i+1, ls.reg.Get(cf.LocalBase+np+i))
 									}
@@ -1881,8 +2086,9 @@ func init() {
 				} else {
 					L.RaiseError("for statement limit must be a number")
 				}
-			} else {
-				L.RaiseError("for statement init must be a number")
+			} else if L.forLoop(RA) {
+				Sbx := int(inst&0x3ffff) - opMaxArgSbx //GETSBX
+				cf.Pc += Sbx
 			}
 			return 0
 		},
@@ -1893,33 +2099,31 @@ func init() {
 			A := int(inst>>18) & 0xff //GETA
 			RA := lbase + A
 			Sbx := int(inst&0x3ffff) - opMaxArgSbx //GETSBX
-			if init, ok1 := reg.Get(RA).(LNumber); ok1 {
-				if step, ok2 := reg.Get(RA + 2).(LNumber); ok2 {
+			init, ok1 := reg.Get(RA).(LNumber)
+			step, ok2 := reg.Get(RA + 2).(LNumber)
+			if _, ok3 := reg.Get(RA + 1).(LInteger); ok1 && ok2 && !ok3 {
+				// this section is inlined by go-inline
+				// source function is 'func (rg *registry) SetNumber(regi int, vali LNumber) ' in '_state.go'
+				{
+					rg := reg
+					regi := RA
+					vali := LNumber(init - step)
+					newSize := regi + 1
 					// this section is inlined by go-inline
-					// source function is 'func (rg *registry) SetNumber(regi int, vali LNumber) ' in '_state.go'
+					// source function is 'func (rg *registry) checkSize(requiredSize int) ' in '_state.go'
 					{
-						rg := reg
-						regi := RA
-						vali := LNumber(init - step)
-						newSize := regi + 1
-						// this section is inlined by go-inline
-						// source function is 'func (rg *registry) checkSize(requiredSize int) ' in '_state.go'
-						{
-							requiredSize := newSize
-							if requiredSize > cap(rg.array) {
-								rg.resize(requiredSize)
-							}
-						}
-						rg.array[regi] = rg.alloc.LNumber2I(vali)
-						if regi >= rg.top {
-							rg.top = regi + 1
+						requiredSize := newSize
+						if requiredSize > cap(rg.array) {
+							rg.resize(requiredSize)
 						}
 					}
-				} else {
-					L.RaiseError("for statement step must be a number")
+					rg.array[regi] = rg.alloc.LNumber2I(vali)
+					if regi >= rg.top {
+						rg.top = regi + 1
+					}
 				}
 			} else {
-				L.RaiseError("for statement init must be a number")
+				L.forPrep(RA)
 			}
 			cf.Pc += Sbx
 			return 0
@@ -2063,7 +2267,10 @@ func init() {
 				cf.Pc++
 			}
 			offset := (C - 1) * FieldsPerFlush
-			table := reg.Get(RA).(*LTable)
+			table, ok := reg.Get(RA).(*LTable)
+			if !ok {
+				L.RaiseError("attempt to set list items of a non-table object(%v)", reg.Get(RA).Type().String())
+			}
 			nelem := B
 			if B == 0 {
 				nelem = reg.Top() - RA - 1
@@ -2314,6 +2521,9 @@ func objectArith(L *LState, opcode int, lhs, rhs LValue) LValue {
 	case OP_POW:
 		event = "__pow"
 	}
+	if v, ok := integerArith(L, opcode, lhs, rhs); ok {
+		return v
+	}
 	op := L.metaOp2(lhs, rhs, event)
 	if _, ok := op.(*LFunction); ok {
 		L.reg.Push(op)
@@ -2323,12 +2533,12 @@ func objectArith(L *LState, opcode int, lhs, rhs LValue) LValue {
 		return L.reg.Pop()
 	}
 	if str, ok := lhs.(LString); ok {
-		if lnum, err := parseNumber(string(str)); err == nil {
+		if lnum, err := parseNumberValue(string(str), L.Options.IntegerSubtype); err == nil {
 			lhs = lnum
 		}
 	}
 	if str, ok := rhs.(LString); ok {
-		if rnum, err := parseNumber(string(str)); err == nil {
+		if rnum, err := parseNumberValue(string(str), L.Options.IntegerSubtype); err == nil {
 			rhs = rnum
 		}
 	}
@@ -2337,8 +2547,11 @@ func objectArith(L *LState, opcode int, lhs, rhs LValue) LValue {
 			return numberArith(L, opcode, LNumber(v1), LNumber(v2))
 		}
 	}
-	L.RaiseError(fmt.Sprintf("cannot perform %v operation between %v and %v",
-		strings.TrimLeft(event, "_"), lhs.Type().String(), rhs.Type().String()))
+	if v, ok := integerArith(L, opcode, lhs, rhs); ok {
+		return v
+	}
+	L.RaiseError("cannot perform %v operation between %v and %v",
+		strings.TrimLeft(event, "_"), lhs.Type().String(), rhs.Type().String())
 
 	return LNil
 }
@@ -2365,15 +2578,18 @@ func stringConcat(L *LState, total, last int) LValue {
 		} else {
 			buf := make([]string, total+1)
 			buf[total] = LVAsString(rhs)
+			n := len(buf[total])
 			for total > 0 {
 				lhs = L.reg.Get(i)
 				if !LVCanConvToString(lhs) {
 					break
 				}
 				buf[total-1] = LVAsString(lhs)
+				n += len(buf[total-1])
 				i--
 				total--
 			}
+			L.allocString(n)
 			rhs = LString(strings.Join(buf, ""))
 		}
 	}
@@ -2386,7 +2602,10 @@ func lessThan(L *LState, lhs, rhs LValue) bool {
 		if v2, ok2 := rhs.(LNumber); ok2 {
 			return v1 < v2
 		}
-		L.RaiseError("attempt to compare %v with %v", lhs.Type().String(), rhs.Type().String())
+		return numberLessThan(L, lhs, rhs, false)
+	}
+	if _, ok1 := lhs.(LInteger); ok1 {
+		return numberLessThan(L, lhs, rhs, false)
 	}
 	if lhs.Type() != rhs.Type() {
 		L.RaiseError("attempt to compare %v with %v", lhs.Type().String(), rhs.Type().String())
@@ -2413,9 +2632,13 @@ func equals(L *LState, lhs, rhs LValue, raw bool) bool {
 	case LTNil:
 		ret = true
 	case LTNumber:
-		v1, _ := lhs.(LNumber)
-		v2, _ := rhs.(LNumber)
-		ret = v1 == v2
+		v1, ok1 := lhs.(LNumber)
+		v2, ok2 := rhs.(LNumber)
+		if ok1 && ok2 {
+			ret = v1 == v2
+		} else {
+			ret = numberEquals(lhs, rhs)
+		}
 	case LTBool:
 		ret = bool(lhs.(LBool)) == bool(rhs.(LBool))
 	case LTString:
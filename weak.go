@@ -0,0 +1,192 @@
+package lua
+
+import (
+	"fmt"
+	"strings"
+	"weak"
+)
+
+type weakMode uint8
+
+const (
+	weakKeys weakMode = 1 << iota
+	weakValues
+)
+
+// weakRef is a weak reference to a collectable LValue stored in a weak table.
+// weakRefs never escape from LTable methods. Two weakRefs are equal if they refer to the same object,
+// so they can be used as map keys.
+type weakRef struct {
+	tb weak.Pointer[LTable]
+	fn weak.Pointer[LFunction]
+	ud weak.Pointer[LUserData]
+	th weak.Pointer[LState]
+}
+
+func (w weakRef) String() string   { return fmt.Sprintf("weak reference: %v", w.value()) }
+func (w weakRef) Type() LValueType { return w.value().Type() }
+
+// value returns the referenced value, or LNil if the value has been collected.
+func (w weakRef) value() LValue {
+	switch {
+	case w.tb != weak.Pointer[LTable]{}:
+		if v := w.tb.Value(); v != nil {
+			return v
+		}
+	case w.fn != weak.Pointer[LFunction]{}:
+		if v := w.fn.Value(); v != nil {
+			return v
+		}
+	case w.ud != weak.Pointer[LUserData]{}:
+		if v := w.ud.Value(); v != nil {
+			return v
+		}
+	case w.th != weak.Pointer[LState]{}:
+		if v := w.th.Value(); v != nil {
+			return v
+		}
+	}
+	return LNil
+}
+
+// makeWeak returns a weak reference to lv if lv is collectable. Otherwise it returns lv as is;
+// strings, numbers and booleans are values and they are never removed from weak tables.
+func makeWeak(lv LValue) LValue {
+	switch v := lv.(type) {
+	case *LTable:
+		return weakRef{tb: weak.Make(v)}
+	case *LFunction:
+		return weakRef{fn: weak.Make(v)}
+	case *LUserData:
+		return weakRef{ud: weak.Make(v)}
+	case *LState:
+		return weakRef{th: weak.Make(v)}
+	}
+	return lv
+}
+
+// strong returns the value referenced by lv if lv is a weak reference. Otherwise it returns lv as is.
+func strong(lv LValue) LValue {
+	if w, ok := lv.(weakRef); ok {
+		return w.value()
+	}
+	return lv
+}
+
+// weakModeOf returns the weak mode that the __mode field of mt specifies. It is read only when a metatable
+// is set, like Lua 5.1 which does not support changing __mode of metatables in use.
+func weakModeOf(mt LValue) weakMode {
+	tb, ok := mt.(*LTable)
+	if !ok {
+		return 0
+	}
+	s, ok := tb.RawGetString("__mode").(LString)
+	if !ok {
+		return 0
+	}
+	var mode weakMode
+	if strings.Contains(string(s), "k") {
+		mode |= weakKeys
+	}
+	if strings.Contains(string(s), "v") {
+		mode |= weakValues
+	}
+	return mode
+}
+
+func (tb *LTable) weakKey(key LValue) LValue {
+	if tb.weak&weakKeys != 0 {
+		return makeWeak(key)
+	}
+	return key
+}
+
+func (tb *LTable) weakValue(value LValue) LValue {
+	if tb.weak&weakValues != 0 {
+		return makeWeak(value)
+	}
+	return value
+}
+
+// setWeakMode changes the weakness of this table. All entries are stored again with the new mode.
+func (tb *LTable) setWeakMode(mode weakMode) {
+	if tb.weak == mode {
+		return
+	}
+	array := make([]LValue, len(tb.array))
+	for i, v := range tb.array {
+		array[i] = strong(v)
+	}
+	hkeys := make([]LValue, 0, len(tb.keys))
+	hvalues := make([]LValue, 0, len(tb.keys))
+	for _, key := range tb.keys {
+		if k, v := strong(key), strong(tb.RawGetH(key)); k != LNil && v != LNil {
+			hkeys = append(hkeys, k)
+			hvalues = append(hvalues, v)
+		}
+	}
+
+	mem := tb.mem
+	tb.mem = nil
+	tb.weak = mode
+	if tb.array != nil {
+		tb.array = tb.array[:0]
+	}
+	tb.dict = nil
+	tb.strdict = nil
+	tb.keys = nil
+	tb.k2i = nil
+	for i, v := range array {
+		if v != LNil {
+			tb.RawSetInt(i+1, v)
+		}
+	}
+	for i, k := range hkeys {
+		tb.RawSet(k, hvalues[i])
+	}
+	tb.mem = mem
+}
+
+// sweepWeak removes entries whose key or value has been collected from this weak table.
+func (tb *LTable) sweepWeak() {
+	if tb.weak == 0 {
+		return
+	}
+	for i, v := range tb.array {
+		if w, ok := v.(weakRef); ok && w.value() == LNil {
+			tb.array[i] = LNil
+		}
+	}
+	if tb.keys == nil {
+		return
+	}
+	keys := make([]LValue, 0, len(tb.keys))
+	k2i := make(map[LValue]int, len(tb.keys))
+	for _, key := range tb.keys {
+		var v LValue
+		if s, ok := key.(LString); ok {
+			v = strong(tb.strdict[string(s)])
+		} else {
+			v = strong(tb.dict[key])
+		}
+		if v == LNil || v == nil || strong(key) == LNil {
+			if s, ok := key.(LString); ok {
+				delete(tb.strdict, string(s))
+			} else {
+				delete(tb.dict, key)
+			}
+			continue
+		}
+		k2i[key] = len(keys)
+		keys = append(keys, key)
+	}
+	tb.keys = keys
+	tb.k2i = k2i
+}
+
+// needsSweep reports whether this weak table should be swept after a new key has been added.
+// Sweeping whenever the number of keys reaches a power of two keeps the amortized cost constant.
+func (tb *LTable) needsSweep() bool {
+	n := len(tb.keys)
+	return tb.weak != 0 && n >= 64 && n&(n-1) == 0
+}